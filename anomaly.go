@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Anomaly kinds found by the scan.
+const (
+	anomalyReturnZ    = "return_zscore"
+	anomalyMcapZero   = "mcap_zero"
+	anomalyVolumeFlat = "volume_flatline"
+	anomalyRankChange = "rank_change"
+)
+
+// anomalyMinReturns is how many daily returns a coin needs before its
+// returns are scored.
+const anomalyMinReturns = 20
+
+// anomalyWebhookBatch is the maximum number of anomalies per webhook request.
+const anomalyWebhookBatch = 100
+
+type Anomaly struct {
+	Date       time.Time `json:"date"`
+	ID         string    `json:"id"`
+	VsCurrency string    `json:"vs_currency"`
+	Kind       string    `json:"kind"`
+	Score      float64   `json:"score"`
+	Detail     string    `json:"detail"`
+}
+
+func (a Anomaly) key() string {
+	return a.ID + "|" + a.VsCurrency + "|" + formatDate(a.Date) + "|" + a.Kind
+}
+
+// seriesPoint is one stored day of a coin with its market cap rank among all
+// coins of the same currency on that day.
+type seriesPoint struct {
+	Date      time.Time
+	Price     float64
+	MarketCap float64
+	Volume    float64
+	Rank      int
+}
+
+// RunAnomalyScan scans the last ANOMALY_WINDOW_DAYS of the configured
+// currency, stores anomalies not found by an earlier scan and, when alert is
+// set, posts them to ANOMALY_WEBHOOK_URL along with the stored ones no
+// earlier post delivered. It returns the new anomalies.
+func RunAnomalyScan(ctx context.Context, cfg Config, db *sql.DB, alert bool) ([]Anomaly, error) {
+	status.SetPhase("anomalies")
+
+	since := yesterdayUTC().AddDate(0, 0, -cfg.AnomalyWindowDays)
+	known, err := getAnomalyKeys(ctx, db, cfg.CHAnomaliesTable, cfg.VsCurrency, since)
+	if err != nil {
+		return nil, err
+	}
+
+	coins := 0
+	var found, unsent []Anomaly
+	err = scanSeries(ctx, db, cfg.CHTable, cfg.VsCurrency, since, func(id string, pts []seriesPoint) {
+		coins++
+		for _, a := range detectAnomalies(cfg, id, pts) {
+			alerted, ok := known[a.key()]
+			if !ok {
+				found = append(found, a)
+			}
+			if !alerted {
+				unsent = append(unsent, a)
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range found {
+		metricAnomalies.WithLabelValues(a.Kind).Inc()
+	}
+	if err := insertAnomalies(ctx, db, cfg.CHAnomaliesTable, found, false); err != nil {
+		return found, err
+	}
+
+	log.WithFields(log.Fields{
+		"coins":     coins,
+		"since":     formatDate(since),
+		"anomalies": len(found),
+		"unsent":    len(unsent),
+	}).Info("anomaly scan finished")
+
+	if alert && cfg.AnomalyWebhookURL != "" && len(unsent) > 0 {
+		sent, err := postAnomalies(ctx, cfg, unsent)
+		if merr := insertAnomalies(ctx, db, cfg.CHAnomaliesTable, unsent[:sent], true); merr != nil {
+			log.Warnf("mark posted anomalies: %v", merr)
+		}
+		if err != nil {
+			return found, fmt.Errorf("anomaly webhook: %w", err)
+		}
+	}
+	return found, nil
+}
+
+// detectAnomalies applies every check to the date-ordered series of one coin.
+func detectAnomalies(cfg Config, id string, pts []seriesPoint) []Anomaly {
+	var out []Anomaly
+	add := func(p seriesPoint, kind string, score float64, format string, args ...any) {
+		out = append(out, Anomaly{
+			Date:       p.Date,
+			ID:         id,
+			VsCurrency: cfg.VsCurrency,
+			Kind:       kind,
+			Score:      score,
+			Detail:     fmt.Sprintf(format, args...),
+		})
+	}
+
+	// Robust z-score of log returns: distance from the median in units of
+	// the median absolute deviation, so past outliers don't hide new ones.
+	var returns []float64
+	var returnAt []int
+	for i := 1; i < len(pts); i++ {
+		if pts[i-1].Price > 0 && pts[i].Price > 0 {
+			returns = append(returns, math.Log(pts[i].Price/pts[i-1].Price))
+			returnAt = append(returnAt, i)
+		}
+	}
+	if len(returns) >= anomalyMinReturns {
+		med := median(returns)
+		dev := make([]float64, len(returns))
+		for i, r := range returns {
+			dev[i] = math.Abs(r - med)
+		}
+		if mad := median(dev); mad > 0 {
+			for i, r := range returns {
+				z := 0.6745 * (r - med) / mad
+				if math.Abs(z) > cfg.AnomalyZScore {
+					p := pts[returnAt[i]]
+					add(p, anomalyReturnZ, z, "return %+.1f%% has robust z-score %.1f", (math.Exp(r)-1)*100, z)
+				}
+			}
+		}
+	}
+
+	flat := 1
+	for i := 1; i < len(pts); i++ {
+		prev, p := pts[i-1], pts[i]
+		consecutive := daysBetween(prev.Date, p.Date) == 1
+
+		if p.MarketCap == 0 && prev.MarketCap > 0 {
+			add(p, anomalyMcapZero, prev.MarketCap, "market cap dropped to zero from %g", prev.MarketCap)
+		}
+
+		if consecutive && p.Volume > 0 && !valueChanged(prev.Volume, p.Volume) {
+			flat++
+		} else {
+			flat = 1
+		}
+		if flat == cfg.AnomalyFlatlineDays {
+			add(p, anomalyVolumeFlat, float64(flat), "volume unchanged at %g for %d days", p.Volume, flat)
+		}
+
+		if consecutive && prev.MarketCap > 0 && p.MarketCap > 0 && min(prev.Rank, p.Rank) <= cfg.AnomalyRankTop {
+			if d := p.Rank - prev.Rank; d >= cfg.AnomalyRankJump || -d >= cfg.AnomalyRankJump {
+				add(p, anomalyRankChange, float64(d), "market cap rank moved from %d to %d", prev.Rank, p.Rank)
+			}
+		}
+	}
+	return out
+}
+
+func median(xs []float64) float64 {
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
+
+// postAnomalies sends the anomalies as JSON in batches, retrying transport
+// errors and 5xx answers, and returns how many were delivered.
+func postAnomalies(ctx context.Context, cfg Config, anomalies []Anomaly) (int, error) {
+	client := &http.Client{Timeout: cfg.RequestTimeout}
+	for start := 0; start < len(anomalies); start += anomalyWebhookBatch {
+		batch := anomalies[start:min(start+anomalyWebhookBatch, len(anomalies))]
+		body, err := json.Marshal(map[string]any{
+			"source":    "cg-range-etl",
+			"anomalies": batch,
+		})
+		if err != nil {
+			return start, err
+		}
+
+		for attempt := 0; ; attempt++ {
+			st, err := postJSON(ctx, client, cfg.AnomalyWebhookURL, body)
+			if err == nil {
+				break
+			}
+			if (st != 0 && st < 500) || attempt >= cfg.MaxRetriesPerBlock {
+				return start, err
+			}
+			log.Warnf("anomaly webhook attempt %d failed: %v", attempt, err)
+			select {
+			case <-ctx.Done():
+				return start, ctx.Err()
+			case <-time.After(backoffSleep(attempt)):
+			}
+		}
+	}
+	log.WithField("anomalies", len(anomalies)).Info("anomaly webhook sent")
+	return len(anomalies), nil
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, fmt.Errorf("http %d: %s", resp.StatusCode, truncate(b, 300))
+	}
+	return resp.StatusCode, nil
+}
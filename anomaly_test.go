@@ -0,0 +1,127 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+// quietSeries is n consecutive days whose price alternates by 1%, with
+// changing volume, a steady market cap and rank 10; mod adjusts day i.
+func quietSeries(n int, mod func(i int, p *seriesPoint)) []seriesPoint {
+	start := mustParseDate("2024-01-01")
+	pts := make([]seriesPoint, n)
+	for i := range pts {
+		price := 100.0
+		if i%2 == 1 {
+			price = 101
+		}
+		pts[i] = seriesPoint{
+			Date:      start.AddDate(0, 0, i),
+			Price:     price,
+			MarketCap: 1e6,
+			Volume:    float64(1000 + i),
+			Rank:      10,
+		}
+		if mod != nil {
+			mod(i, &pts[i])
+		}
+	}
+	return pts
+}
+
+func TestDetectAnomalies(t *testing.T) {
+	cfg := Config{
+		VsCurrency:          "usd",
+		AnomalyZScore:       6,
+		AnomalyFlatlineDays: 5,
+		AnomalyRankTop:      200,
+		AnomalyRankJump:     25,
+	}
+
+	tests := []struct {
+		name string
+		pts  []seriesPoint
+		want []string // date:kind
+	}{
+		{
+			name: "quiet",
+			pts:  quietSeries(31, nil),
+		},
+		{
+			name: "price spike",
+			pts: quietSeries(31, func(i int, p *seriesPoint) {
+				if i == 20 {
+					p.Price *= 3
+				}
+			}),
+			want: []string{"2024-01-21:return_zscore", "2024-01-22:return_zscore"},
+		},
+		{
+			name: "too few returns to score",
+			pts: quietSeries(anomalyMinReturns, func(i int, p *seriesPoint) {
+				if i == 10 {
+					p.Price *= 3
+				}
+			}),
+		},
+		{
+			name: "market cap drops to zero",
+			pts: quietSeries(10, func(i int, p *seriesPoint) {
+				if i >= 5 {
+					p.MarketCap = 0
+				}
+			}),
+			want: []string{"2024-01-06:mcap_zero"},
+		},
+		{
+			name: "volume flatline",
+			pts: quietSeries(10, func(i int, p *seriesPoint) {
+				if i >= 2 && i <= 8 {
+					p.Volume = 500
+				}
+			}),
+			want: []string{"2024-01-07:volume_flatline"},
+		},
+		{
+			name: "flatline broken by a missing day",
+			pts: quietSeries(8, func(i int, p *seriesPoint) {
+				p.Volume = 500
+				if i >= 4 {
+					p.Date = p.Date.AddDate(0, 0, 1)
+				}
+			}),
+		},
+		{
+			name: "rank jump",
+			pts: quietSeries(5, func(i int, p *seriesPoint) {
+				if i == 3 {
+					p.Rank = 40
+				}
+			}),
+			want: []string{"2024-01-04:rank_change", "2024-01-05:rank_change"},
+		},
+		{
+			name: "rank jump outside the top",
+			pts: quietSeries(5, func(i int, p *seriesPoint) {
+				p.Rank = 300
+				if i == 3 {
+					p.Rank = 400
+				}
+			}),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, a := range detectAnomalies(cfg, "x", tt.pts) {
+				if a.ID != "x" || a.VsCurrency != "usd" || a.Detail == "" {
+					t.Errorf("incomplete anomaly %+v", a)
+				}
+				got = append(got, formatDate(a.Date)+":"+a.Kind)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("anomalies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
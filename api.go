@@ -0,0 +1,410 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	apiDefaultLimit = 1000
+	apiMaxLimit     = 10000
+	apiQueryTimeout = 30 * time.Second
+)
+
+// pointFields are the values of a daily point the API can return, in their
+// default order.
+var pointFields = []string{"timestamp", "price", "market_cap", "volume"}
+
+// registerAPI adds the read API over the stored market data to mux. The query
+// endpoints page with limit and offset and answer CSV for format=csv; the
+// feed endpoints stream newly stored points.
+func registerAPI(mux *http.ServeMux, cfg Config, db *sql.DB) {
+	mux.HandleFunc("GET /v1/coins", handleAPICoins(cfg, db))
+	mux.HandleFunc("GET /v1/coins/{id}/series", handleAPISeries(cfg, db))
+	mux.HandleFunc("GET /v1/snapshot", handleAPISnapshot(cfg, db))
+	mux.HandleFunc("GET /v1/feed/sse", handleFeedSSE)
+	mux.HandleFunc("GET /v1/feed/ws", handleFeedWS)
+}
+
+// apiTable is a result with named columns, written as JSON objects or CSV.
+type apiTable struct {
+	columns []string
+	rows    [][]any
+}
+
+type apiPage struct {
+	limit  int
+	offset int
+}
+
+// handleAPICoins lists the stored universe with the stored date range per
+// coin. ?active=true|false filters by the last known listing state.
+func handleAPICoins(cfg Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, vs, err := apiParams(cfg, r)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		var active *bool
+		if v := r.URL.Query().Get("active"); v != "" {
+			b, err := strconv.ParseBool(v)
+			if err != nil {
+				apiError(w, http.StatusBadRequest, fmt.Errorf("bad active: %q", v))
+				return
+			}
+			active = &b
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), apiQueryTimeout)
+		defer cancel()
+		coins, err := getUniverse(ctx, db, cfg.CHCoinsTable)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err)
+			return
+		}
+		ranges, err := getDateRanges(ctx, db, cfg.CHTable, vs)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err)
+			return
+		}
+
+		ids := make([]string, 0, len(coins))
+		for _, id := range sortedKeys(coins) {
+			if active == nil || coins[id].Active == *active {
+				ids = append(ids, id)
+			}
+		}
+		more := len(ids) > page.offset+page.limit
+		ids = ids[min(page.offset, len(ids)):min(page.offset+page.limit, len(ids))]
+
+		t := apiTable{columns: []string{"id", "symbol", "name", "active", "first_date", "last_date"}}
+		for _, id := range ids {
+			c := coins[id]
+			first, last := any(nil), any(nil)
+			if rg, ok := ranges[id]; ok {
+				first, last = formatDate(rg.Min), formatDate(rg.Max)
+			}
+			t.rows = append(t.rows, []any{c.ID, c.Symbol, c.Name, c.Active, first, last})
+		}
+		writeAPITable(w, r, t, page, more, map[string]any{"vs_currency": vs})
+	}
+}
+
+// handleAPISeries returns the daily points of one coin between from (default
+// START_DATE) and to (default yesterday), or its weekly or monthly rollups
+// for resolution=week|month.
+func handleAPISeries(cfg Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, vs, err := apiParams(cfg, r)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		resolution := r.URL.Query().Get("resolution")
+		ru, rolled := rollupFor(resolution)
+		if !rolled && resolution != "" && resolution != "day" {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("resolution must be day, week or month, got %q", resolution))
+			return
+		}
+		all := pointFields
+		if rolled {
+			all = rollupFields
+		}
+		fields, err := parseFields(r.URL.Query().Get("fields"), all)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		from, err := parseDay(r.URL.Query().Get("from"), cfg.StartDate)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("bad from: %w", err))
+			return
+		}
+		to, err := parseDay(r.URL.Query().Get("to"), yesterdayUTC())
+		if err != nil {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("bad to: %w", err))
+			return
+		}
+		if to.Before(from) {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("to is before from"))
+			return
+		}
+		id := r.PathValue("id")
+		meta := map[string]any{
+			"id":          id,
+			"vs_currency": vs,
+			"from":        formatDate(from),
+			"to":          formatDate(to),
+			"resolution":  "day",
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), apiQueryTimeout)
+		defer cancel()
+		if rolled {
+			// A period is returned when it starts in the window, so from
+			// snaps back to the start of its period.
+			from = ru.start(from)
+			pts, err := queryRollupSeries(ctx, db, ru.table(cfg), id, vs, from, to, page.limit+1, page.offset)
+			if err != nil {
+				apiError(w, http.StatusInternalServerError, err)
+				return
+			}
+			more := len(pts) > page.limit
+			pts = pts[:min(len(pts), page.limit)]
+
+			t := apiTable{columns: append([]string{"period"}, fields...)}
+			for _, p := range pts {
+				t.rows = append(t.rows, append([]any{formatDate(p.Period)}, rollupValues(p, fields)...))
+			}
+			meta["from"], meta["resolution"] = formatDate(from), ru.resolution
+			writeAPITable(w, r, t, page, more, meta)
+			return
+		}
+
+		pts, err := querySeries(ctx, db, cfg.CHTable, id, vs, from, to, page.limit+1, page.offset)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err)
+			return
+		}
+		more := len(pts) > page.limit
+		pts = pts[:min(len(pts), page.limit)]
+
+		t := apiTable{columns: append([]string{"date"}, fields...)}
+		for _, p := range pts {
+			t.rows = append(t.rows, append([]any{formatDate(p.Timestamp)}, pointValues(p, fields)...))
+		}
+		writeAPITable(w, r, t, page, more, meta)
+	}
+}
+
+// handleAPISnapshot returns every coin on one day (default yesterday) ranked
+// by market cap.
+func handleAPISnapshot(cfg Config, db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		page, vs, err := apiParams(cfg, r)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		fields, err := parseFields(r.URL.Query().Get("fields"), pointFields)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, err)
+			return
+		}
+		day, err := parseDay(r.URL.Query().Get("date"), yesterdayUTC())
+		if err != nil {
+			apiError(w, http.StatusBadRequest, fmt.Errorf("bad date: %w", err))
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), apiQueryTimeout)
+		defer cancel()
+		pts, err := querySnapshot(ctx, db, cfg.CHTable, vs, day, page.limit+1, page.offset)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, err)
+			return
+		}
+		more := len(pts) > page.limit
+		pts = pts[:min(len(pts), page.limit)]
+
+		t := apiTable{columns: append([]string{"rank", "id", "symbol"}, fields...)}
+		for i, p := range pts {
+			t.rows = append(t.rows, append([]any{page.offset + i + 1, p.ID, p.Symbol}, pointValues(p, fields)...))
+		}
+		writeAPITable(w, r, t, page, more, map[string]any{
+			"date":        formatDate(day),
+			"vs_currency": vs,
+		})
+	}
+}
+
+// apiParams parses the paging and currency parameters every endpoint takes.
+func apiParams(cfg Config, r *http.Request) (apiPage, string, error) {
+	q := r.URL.Query()
+	page := apiPage{limit: apiDefaultLimit}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > apiMaxLimit {
+			return page, "", fmt.Errorf("limit must be within [1, %d], got %q", apiMaxLimit, v)
+		}
+		page.limit = n
+	}
+	if v := q.Get("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return page, "", fmt.Errorf("offset must be >= 0, got %q", v)
+		}
+		page.offset = n
+	}
+
+	vs, err := resolveVs(cfg, q.Get("vs"))
+	return page, vs, err
+}
+
+// resolveVs returns the requested currency, COINGECKO_VS_CURRENCY when none
+// is given.
+func resolveVs(cfg Config, vs string) (string, error) {
+	vs = strings.ToLower(vs)
+	if vs == "" {
+		vs = cfg.VsCurrency
+	}
+	if !vsCurrencies[vs] {
+		return "", fmt.Errorf("unsupported currency %q", vs)
+	}
+	return vs, nil
+}
+
+// parseFields picks the requested fields out of all, all of them when none
+// are given.
+func parseFields(s string, all []string) ([]string, error) {
+	if s == "" {
+		return all, nil
+	}
+	known := parseCSVSet(strings.Join(all, ","))
+	seen := make(map[string]bool)
+	var out []string
+	for _, f := range strings.Split(s, ",") {
+		f = strings.TrimSpace(f)
+		if !known[f] {
+			return nil, fmt.Errorf("unknown field %q (want any of %s)", f, strings.Join(all, ", "))
+		}
+		if !seen[f] {
+			seen[f] = true
+			out = append(out, f)
+		}
+	}
+	return out, nil
+}
+
+func parseDay(s string, def time.Time) (time.Time, error) {
+	if s == "" {
+		return def, nil
+	}
+	t, err := time.Parse("2006-01-02", s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("want YYYY-MM-DD, got %q", s)
+	}
+	return t.UTC(), nil
+}
+
+func rollupValues(p RollupPoint, fields []string) []any {
+	out := make([]any, len(fields))
+	for i, f := range fields {
+		switch f {
+		case "first_price":
+			out[i] = p.FirstPrice
+		case "last_price":
+			out[i] = p.LastPrice
+		case "min_price":
+			out[i] = p.MinPrice
+		case "max_price":
+			out[i] = p.MaxPrice
+		case "avg_price":
+			out[i] = p.AvgPrice
+		case "market_cap":
+			out[i] = p.MarketCap
+		case "volume":
+			out[i] = p.Volume
+		case "days":
+			out[i] = p.Days
+		}
+	}
+	return out
+}
+
+func pointValues(p DailyPoint, fields []string) []any {
+	out := make([]any, len(fields))
+	for i, f := range fields {
+		switch f {
+		case "timestamp":
+			out[i] = p.Timestamp
+		case "price":
+			out[i] = p.Price
+		case "market_cap":
+			out[i] = p.MarketCap
+		case "volume":
+			out[i] = p.Volume
+		}
+	}
+	return out
+}
+
+// writeAPITable writes t as CSV for format=csv and otherwise as JSON with
+// meta, the paging state and the rows as objects under data.
+func writeAPITable(w http.ResponseWriter, r *http.Request, t apiTable, page apiPage, more bool, meta map[string]any) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", "json":
+	case "csv":
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		if more {
+			w.Header().Set("X-Next-Offset", strconv.Itoa(page.offset+page.limit))
+		}
+		cw := csv.NewWriter(w)
+		_ = cw.Write(t.columns)
+		rec := make([]string, len(t.columns))
+		for _, row := range t.rows {
+			for i, v := range row {
+				rec[i] = csvValue(v)
+			}
+			_ = cw.Write(rec)
+		}
+		cw.Flush()
+		if err := cw.Error(); err != nil {
+			log.Debugf("http: write csv: %v", err)
+		}
+		return
+	default:
+		apiError(w, http.StatusBadRequest, fmt.Errorf("format must be json or csv, got %q", format))
+		return
+	}
+
+	data := make([]map[string]any, len(t.rows))
+	for i, row := range t.rows {
+		obj := make(map[string]any, len(t.columns))
+		for j, c := range t.columns {
+			obj[c] = row[j]
+		}
+		data[i] = obj
+	}
+	body := map[string]any{
+		"data":   data,
+		"limit":  page.limit,
+		"offset": page.offset,
+	}
+	if more {
+		body["next_offset"] = page.offset + page.limit
+	}
+	for k, v := range meta {
+		body[k] = v
+	}
+	writeJSON(w, http.StatusOK, body)
+}
+
+func csvValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case time.Time:
+		return x.Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func apiError(w http.ResponseWriter, code int, err error) {
+	if code >= http.StatusInternalServerError {
+		log.Warnf("api: %v", err)
+	}
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
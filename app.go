@@ -0,0 +1,303 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// app is the wiring shared by the subcommands: clients, the symbol history
+// and a worker pool that is started only by commands that fetch data.
+type app struct {
+	mu  sync.RWMutex
+	cfg Config
+
+	cg   *CGClient
+	db   *sql.DB
+	syms *SymbolHistory
+
+	pool      *workerPool
+	tasksCh   chan Task
+	resultsCh chan TaskResult
+
+	// reloaded wakes the sync loop after a reload changed settings it
+	// applies between rounds.
+	reloaded chan struct{}
+}
+
+func openApp(ctx context.Context, cfg Config) (*app, error) {
+	db, err := openClickHouse(ctx, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("clickhouse connect: %w", err)
+	}
+	return &app{
+		cfg:  cfg,
+		cg:   NewCGClient(cfg),
+		db:   db,
+		syms: &SymbolHistory{},
+
+		reloaded: make(chan struct{}, 1),
+	}, nil
+}
+
+// config returns the current configuration, which a reload may replace.
+func (a *app) config() Config {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.cfg
+}
+
+func (a *app) Close() error {
+	return a.db.Close()
+}
+
+// migrate creates every table the ETL reads or writes and restores the days
+// an earlier process left for the derived tables.
+func (a *app) migrate(ctx context.Context) error {
+	cfg := a.config()
+	steps := []struct {
+		name   string
+		create func() error
+	}{
+		{"table", func() error { return createTable(ctx, a.db, cfg.CHTable) }},
+		{"bounds table", func() error { return createBoundsTable(ctx, a.db, cfg.CHBoundsTable) }},
+		{"empty days table", func() error { return createEmptyDaysTable(ctx, a.db, cfg.CHEmptyDaysTable) }},
+		{"revisions table", func() error { return createRevisionsTable(ctx, a.db, cfg.CHRevisionsTable) }},
+		{"quarantine table", func() error { return createQuarantineTable(ctx, a.db, cfg.CHQuarantineTable) }},
+		{"anomalies table", func() error { return createAnomaliesTable(ctx, a.db, cfg.CHAnomaliesTable) }},
+		{"market tables", func() error {
+			return createMarketTables(ctx, a.db, cfg.CHMarketRankTable, cfg.CHMarketTotalsTable)
+		}},
+		{"global tables", func() error {
+			return createGlobalTables(ctx, a.db, cfg.CHGlobalTable, cfg.CHGlobalDefiTable, cfg.CHGlobalHistoryTable)
+		}},
+		{"category tables", func() error {
+			return createCategoryTables(ctx, a.db, cfg.CHCategoriesTable, cfg.CHCategoryMarketTable, cfg.CHCategoryMembersTable, cfg.CHCategoryCapTable)
+		}},
+		{"exchange tables", func() error {
+			return createExchangeTables(ctx, a.db, cfg.CHExchangesTable, cfg.CHExchangeVolumeTable, cfg.CHTickersTable)
+		}},
+		{"fx table", func() error { return createFXTable(ctx, a.db, cfg.CHFXTable) }},
+		{"indicators table", func() error { return createIndicatorsTable(ctx, a.db, cfg.CHIndicatorsTable) }},
+		{"rollup tables", func() error {
+			for _, r := range rollups {
+				if err := createRollupTables(ctx, a.db, cfg.CHTable, r.table(cfg), r.view(cfg), r.periodFn); err != nil {
+					return fmt.Errorf("%s: %w", r.resolution, err)
+				}
+			}
+			return nil
+		}},
+		{"coins table", func() error { return createCoinsTable(ctx, a.db, cfg.CHCoinsTable) }},
+		{"universe tables", func() error {
+			return createUniverseTables(ctx, a.db, cfg.CHUniverseTable, cfg.CHUniverseEventsTable)
+		}},
+		{"run tables", func() error { return createRunTables(ctx, a.db, cfg.CHRunsTable, cfg.CHTaskResultsTable) }},
+		{"pending days table", func() error { return createPendingTable(ctx, a.db, cfg.CHPendingDaysTable) }},
+	}
+	for _, s := range steps {
+		if err := s.create(); err != nil {
+			return fmt.Errorf("create %s: %w", s.name, err)
+		}
+	}
+	if err := a.loadPendingDays(ctx); err != nil {
+		return fmt.Errorf("load pending days: %w", err)
+	}
+	return nil
+}
+
+func (a *app) loadSymbols(ctx context.Context) {
+	history, err := getSymbolHistory(ctx, a.db, a.config().CHUniverseTable)
+	if err != nil {
+		log.Warnf("load symbol history: %v", err)
+		return
+	}
+	a.syms.Set(history)
+}
+
+func (a *app) serveHTTP(ctx context.Context) {
+	cfg := a.config()
+	if cfg.HTTPAddr != "" {
+		go serveHTTP(ctx, cfg.HTTPAddr, newHTTPMux(cfg, a.db, a.cg))
+	}
+}
+
+func (a *app) serveGRPC(ctx context.Context) {
+	cfg := a.config()
+	if cfg.GRPCAddr != "" {
+		go serveGRPC(ctx, cfg.GRPCAddr, newGRPCServer(cfg, a.db))
+	}
+}
+
+func (a *app) startWorkers(ctx context.Context) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.pool != nil {
+		return
+	}
+
+	cfg := a.cfg
+	a.tasksCh = make(chan Task, cfg.Workers*2)
+	a.resultsCh = make(chan TaskResult, cfg.Workers*4)
+	a.pool = newWorkerPool(func(wid int, stop <-chan struct{}) {
+		worker(ctx, wid, cfg, a.cg, a.db, a.syms, stop, a.tasksCh, a.resultsCh)
+	})
+	a.pool.Resize(cfg.Workers)
+}
+
+// loadUniverse fetches /coins/list and stores the snapshot. err is set when
+// either list could not be loaded; all and active then hold what was.
+func (a *app) loadUniverse(ctx context.Context) (all, active []Coin, diff UniverseDiff, err error) {
+	cfg := a.config()
+	all, active, err = fetchCoinsLists(ctx, a.cg, cfg)
+	log.WithFields(log.Fields{
+		"coins_total":  len(all),
+		"active_total": len(active),
+	}).Info("coins list loaded")
+	if err != nil {
+		return all, active, diff, err
+	}
+
+	diff, serr := saveUniverse(ctx, cfg, a.db, a.syms, all, active)
+	if serr != nil {
+		log.Warnf("save coin universe: %v", serr)
+	}
+	return all, active, diff, nil
+}
+
+// storedActiveCoins is the active set from the last stored universe, used
+// when /coins/list is unavailable.
+func (a *app) storedActiveCoins(ctx context.Context) ([]Coin, error) {
+	cfg := a.config()
+	stored, err := getUniverse(ctx, a.db, cfg.CHCoinsTable)
+	if err != nil {
+		return nil, err
+	}
+	active := make(map[string]Coin)
+	for id, c := range stored {
+		if c.Active && (cfg.CoinIDsFilter == nil || cfg.CoinIDsFilter[id]) {
+			active[id] = c.Coin
+		}
+	}
+	return coinFromIDMap(active), nil
+}
+
+// backfill discovers the bounds of coins and fills their history. Task
+// failures are reported after both phases have run.
+func (a *app) backfill(ctx context.Context, coins []Coin) (map[string]Coin, error) {
+	a.startWorkers(ctx)
+	a.refreshFX(ctx)
+	cfg := a.config()
+
+	bounds, derr := RunDiscovery(ctx, cfg, a.db, coins, a.tasksCh, a.resultsCh)
+	if derr != nil && !errors.Is(derr, errTasksFailed) {
+		return nil, fmt.Errorf("discovery: %w", derr)
+	}
+
+	active, err := RunBackfill(ctx, cfg, a.db, bounds, a.tasksCh, a.resultsCh)
+	if err != nil {
+		return active, err
+	}
+	return active, derr
+}
+
+// syncLoop runs incremental sync every SyncEvery, refreshing the universe,
+// repairing gaps, syncing exchanges and scanning for anomalies on their own
+// schedules, until ctx is cancelled. Reloaded settings take effect from the
+// next round.
+func (a *app) syncLoop(ctx context.Context, activeCoins []Coin, lastRefresh time.Time) error {
+	cfg := a.config()
+	a.startWorkers(ctx)
+
+	activeCoins = filterCoins(activeCoins, cfg.CoinIDsFilter)
+	log.WithField("active_coins_for_incremental", len(activeCoins)).Info("incremental target set")
+	status.SetActiveCoins(len(activeCoins))
+
+	go a.globalLoop(ctx)
+	go a.categoryLoop(ctx)
+
+	ticker := time.NewTicker(cfg.SyncEvery)
+	defer ticker.Stop()
+
+	var (
+		lastGapScan     time.Time
+		lastAnomalyScan time.Time
+		lastExchanges   time.Time
+		forceRefresh    bool
+	)
+
+	for round := 0; ; round++ {
+		status.SetRound(round)
+
+		select {
+		case <-ctx.Done():
+			log.Info("exit")
+			return nil
+		default:
+		}
+
+		if forceRefresh || cfg.CoinsRefreshEvery > 0 && time.Since(lastRefresh) >= cfg.CoinsRefreshEvery {
+			activeCoins = refreshUniverse(ctx, cfg, a.cg, a.db, a.syms, filterCoins(activeCoins, cfg.CoinIDsFilter), a.tasksCh, a.resultsCh)
+			lastRefresh = time.Now()
+			forceRefresh = false
+			status.SetActiveCoins(len(activeCoins))
+		}
+
+		a.refreshFX(ctx)
+		if err := runIncrementalOnce(ctx, cfg, a.db, activeCoins, a.tasksCh, a.resultsCh); err != nil && ctx.Err() == nil {
+			log.Warnf("incremental failed: %v", err)
+		}
+
+		if cfg.GapScanEvery > 0 && time.Since(lastGapScan) >= cfg.GapScanEvery {
+			if err := RunGapRepair(ctx, cfg, a.db, a.tasksCh, a.resultsCh); err != nil && ctx.Err() == nil {
+				log.Warnf("gap repair failed: %v", err)
+			}
+			lastGapScan = time.Now()
+		}
+
+		a.refreshDerived(ctx)
+
+		if cfg.ExchangeSyncEvery > 0 && time.Since(lastExchanges) >= cfg.ExchangeSyncEvery {
+			if err := a.syncExchanges(ctx); err != nil && ctx.Err() == nil {
+				log.Warnf("exchange sync failed: %v", err)
+			}
+			lastExchanges = time.Now()
+		}
+
+		if cfg.AnomalyScanEvery > 0 && time.Since(lastAnomalyScan) >= cfg.AnomalyScanEvery {
+			if _, err := RunAnomalyScan(ctx, cfg, a.db, true); err != nil && ctx.Err() == nil {
+				log.Warnf("anomaly scan failed: %v", err)
+			}
+			lastAnomalyScan = time.Now()
+		}
+
+		status.SetPhase("idle")
+
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-ticker.C:
+				break wait
+			case <-a.reloaded:
+				next := a.config()
+				if next.SyncEvery != cfg.SyncEvery {
+					ticker.Reset(next.SyncEvery)
+				}
+				// A new coin filter re-reads the universe right away so
+				// newly included coins are onboarded.
+				changed := !sameSet(next.CoinIDsFilter, cfg.CoinIDsFilter)
+				cfg = next
+				if changed {
+					forceRefresh = true
+					break wait
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// categoryPageSize is the /coins/markets page size used to resolve
+	// COINGECKO_CATEGORIES.
+	categoryPageSize = 250
+	// membershipInsertBatch is how many membership changes are buffered
+	// before they are written.
+	membershipInsertBatch = 500
+	// runCategories is the run kind of a membership check.
+	runCategories = "categories"
+)
+
+// membershipUnknownStart starts the memberships found on the first check of
+// a coin. When it joined is unknown, so it counts for its whole history.
+var membershipUnknownStart = time.Unix(0, 0).UTC()
+
+// CategoryMembership is a coin belonging to a category from ValidFrom up to,
+// not including, ValidTo; a zero ValidTo is a current membership.
+type CategoryMembership struct {
+	ID         string
+	CategoryID string
+	ValidFrom  time.Time
+	ValidTo    time.Time
+}
+
+// categoryDays collects, per currency, the days whose category market caps
+// changed through membership rather than stored points.
+var categoryDays = &dayTracker{name: "category"}
+
+// refreshCategoryMarkets stores the category list and today's
+// /coins/categories snapshot, and returns the category ids by name.
+func refreshCategoryMarkets(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB) (map[string]string, error) {
+	cats, _, body, err := cg.CategoriesList(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("categories list: %w; body=%s", err, truncate(body, 300))
+	}
+	if err := insertCategories(ctx, db, cfg.CHCategoriesTable, cats); err != nil {
+		return nil, err
+	}
+	byName := make(map[string]string, len(cats))
+	for _, c := range cats {
+		byName[c.Name] = c.ID
+	}
+
+	markets, _, body, err := cg.Categories(ctx)
+	if err != nil {
+		return byName, fmt.Errorf("categories: %w; body=%s", err, truncate(body, 300))
+	}
+	if err := insertCategoryMarkets(ctx, db, cfg.CHCategoryMarketTable, time.Now(), markets); err != nil {
+		return byName, err
+	}
+	log.WithFields(log.Fields{
+		"categories": len(cats),
+		"markets":    len(markets),
+	}).Info("categories stored")
+	return byName, nil
+}
+
+// refreshMemberships checks the categories of every scheduled active coin
+// on /coins/{id} and records the memberships that started or ended since
+// the last check. Coins checked for the first time get their category
+// market caps recomputed over their whole history.
+func (a *app) refreshMemberships(ctx context.Context, byName map[string]string) error {
+	cfg := a.config()
+	coins, err := a.storedActiveCoins(ctx)
+	if err != nil {
+		return err
+	}
+	sort.Slice(coins, func(i, j int) bool { return coins[i].ID < coins[j].ID })
+	known, err := getOpenMemberships(ctx, a.db, cfg.CHCategoryMembersTable)
+	if err != nil {
+		return err
+	}
+
+	run := startRun(ctx, cfg, a.db, runCategories)
+	today := dateOnlyUTC(time.Now())
+	var (
+		changes   []CategoryMembership
+		firstSeen []string
+		unknown   = make(map[string]bool)
+		total     int
+	)
+	flush := func() error {
+		err := insertMemberships(ctx, a.db, cfg.CHCategoryMembersTable, changes)
+		total += len(changes)
+		changes = changes[:0]
+		return err
+	}
+
+	for _, c := range coins {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+		names, status, body, cerr := a.cg.CoinCategories(ctx, c.ID)
+		res := TaskResult{Task: Task{CoinID: c.ID, Symbol: c.Symbol}, HTTPStatus: status}
+		if cerr != nil {
+			res.Err = fmt.Sprintf("%v; body=%s", cerr, truncate(body, 300))
+			run.record(ctx, res)
+			continue
+		}
+
+		cur := make(map[string]bool, len(names))
+		for _, n := range names {
+			if id, ok := byName[n]; ok {
+				cur[id] = true
+			} else {
+				unknown[n] = true
+			}
+		}
+		was, checked := known[c.ID]
+		start := today
+		if !checked {
+			start = membershipUnknownStart
+			if len(cur) > 0 {
+				firstSeen = append(firstSeen, c.ID)
+			}
+		}
+		before := len(changes)
+		for _, cat := range sortedKeys(cur) {
+			if _, ok := was[cat]; !ok {
+				changes = append(changes, CategoryMembership{ID: c.ID, CategoryID: cat, ValidFrom: start})
+			}
+		}
+		for _, cat := range sortedKeys(was) {
+			if !cur[cat] {
+				changes = append(changes, CategoryMembership{ID: c.ID, CategoryID: cat, ValidFrom: was[cat], ValidTo: today})
+			}
+		}
+		res.Inserted = len(changes) - before
+		run.record(ctx, res)
+
+		if len(changes) >= membershipInsertBatch {
+			if err = flush(); err != nil {
+				break
+			}
+		}
+	}
+	if err == nil {
+		err = flush()
+	}
+	if err == nil && len(firstSeen) > 0 {
+		err = a.markCategoryHistory(ctx, firstSeen)
+	}
+	run.finish(ctx, err)
+
+	if len(unknown) > 0 {
+		log.WithField("names", sortedKeys(unknown)).Debug("categories not in the categories list")
+	}
+	log.WithFields(log.Fields{
+		"coins":      len(coins),
+		"changes":    total,
+		"first_seen": len(firstSeen),
+	}).Info("category memberships checked")
+	return err
+}
+
+// markCategoryHistory queues every stored day of ids for the category market
+// cap refresh.
+func (a *app) markCategoryHistory(ctx context.Context, ids []string) error {
+	cfg := a.config()
+	for _, vs := range append([]string{cfg.VsCurrency}, sortedKeys(cfg.DerivedVsCurrencies)...) {
+		ranges, err := getDateRanges(ctx, a.db, cfg.CHTable, vs)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if rg, ok := ranges[id]; ok {
+				categoryDays.Add(vs, daysInclusive(rg.Min, rg.Max)...)
+			}
+		}
+	}
+	return nil
+}
+
+// refreshCategoryCaps rebuilds the category market caps of the days in
+// changed and of the days queued by membership changes. Days that fail are
+// kept for the next call.
+func (a *app) refreshCategoryCaps(ctx context.Context, changed map[string][]time.Time) {
+	cfg := a.config()
+	for vs, days := range changed {
+		categoryDays.Add(vs, days...)
+	}
+
+	pending, takenAt := categoryDays.Take()
+	defer categoryDays.Clear(ctx, takenAt)
+	for vs, days := range pending {
+		start := time.Now()
+		for i := 0; i < len(days); i += marketRebuildChunk {
+			chunk := days[i:min(i+marketRebuildChunk, len(days))]
+			if err := rebuildCategoryDays(ctx, a.db, cfg.CHTable, cfg.CHCategoryMembersTable, cfg.CHCategoryCapTable, vs, chunk); err != nil {
+				categoryDays.Add(vs, days[i:]...)
+				if ctx.Err() == nil {
+					log.WithField("vs", vs).Warnf("category market cap refresh failed: %v", err)
+				}
+				break
+			}
+		}
+		log.WithFields(log.Fields{
+			"vs":      vs,
+			"days":    len(days),
+			"elapsed": time.Since(start).Round(time.Millisecond),
+		}).Debug("category market caps refreshed")
+	}
+}
+
+// categoryLoop refreshes the categories every CATEGORY_REFRESH_EVERY and
+// checks memberships when the last check is CATEGORY_MEMBERSHIP_EVERY old,
+// until ctx is cancelled.
+func (a *app) categoryLoop(ctx context.Context) {
+	cfg := a.config()
+	if cfg.CategoryRefreshEvery <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cfg.CategoryRefreshEvery)
+	defer ticker.Stop()
+
+	for {
+		cfg = a.config()
+		byName, err := refreshCategoryMarkets(ctx, cfg, a.cg, a.db)
+		if err != nil && ctx.Err() == nil {
+			log.Warnf("category refresh failed: %v", err)
+		}
+		if byName != nil && cfg.CategoryMembershipEvery > 0 && a.membershipDue(ctx, cfg) {
+			if err := a.refreshMemberships(ctx, byName); err != nil && ctx.Err() == nil {
+				log.Warnf("category membership check failed: %v", err)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// membershipDue reports whether the last membership check that ran to the
+// end started at least CATEGORY_MEMBERSHIP_EVERY ago. Failed and interrupted
+// checks do not count.
+func (a *app) membershipDue(ctx context.Context, cfg Config) bool {
+	last, err := getLastFinishedRun(ctx, a.db, cfg.CHRunsTable, runCategories)
+	if err != nil {
+		log.Warnf("category membership: last finished run: %v", err)
+		return false
+	}
+	return time.Since(last) >= cfg.CategoryMembershipEvery
+}
+
+// resolveCategoryFilter turns COINGECKO_CATEGORIES into the coin id filter
+// the scheduler applies, from the coins /coins/markets lists per category.
+func resolveCategoryFilter(ctx context.Context, cfg *Config, cg *CGClient) error {
+	if len(cfg.CategoryFilter) == 0 {
+		return nil
+	}
+	ids := make(map[string]bool)
+	for _, cat := range sortedKeys(cfg.CategoryFilter) {
+		n := 0
+		for page := 1; ; page++ {
+			coins, _, body, err := cg.CategoryCoinIDs(ctx, cat, cfg.VsCurrency, page, categoryPageSize)
+			if err != nil {
+				return fmt.Errorf("category %s: %w; body=%s", cat, err, truncate(body, 300))
+			}
+			for _, id := range coins {
+				ids[id] = true
+			}
+			n += len(coins)
+			if len(coins) < categoryPageSize {
+				break
+			}
+		}
+		if n == 0 {
+			log.WithField("category", cat).Warn("category has no coins")
+		}
+		log.WithFields(log.Fields{"category": cat, "coins": n}).Info("category resolved")
+	}
+	cfg.CoinIDsFilter = ids
+	return nil
+}
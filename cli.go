@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Exit codes of the CLI. exitIncomplete means the command ran to the end but
+// some tasks failed after their retries or verification found problems.
+const (
+	exitOK          = 0
+	exitFailure     = 1
+	exitUsage       = 2
+	exitIncomplete  = 3
+	exitConfig      = 78
+	exitInterrupted = 130
+)
+
+const defaultCommand = "run"
+
+type command struct {
+	name    string
+	args    string
+	summary string
+	run     func(ctx context.Context, cfg Config, args []string) error
+}
+
+var commands []command
+
+func init() {
+	commands = []command{
+		{"run", "", "backfill, then sync forever (default)", cmdRun},
+		{"backfill", "[--ids a,b]", "discover bounds and backfill history, then exit", cmdBackfill},
+		{"sync", "[--once]", "incremental sync loop; --once runs a single round", cmdSync},
+		{"gaps", "[--list]", "repair internal date gaps; --list only prints them", cmdGaps},
+		{"repair", "--id X --from YYYY-MM-DD [--to YYYY-MM-DD] [--vs usd]", "re-fetch and overwrite a window of one coin", cmdRepair},
+		{"verify", "[--max-lag N] [-v]", "check gaps, coverage and sync lag", cmdVerify},
+		{"coins", "list [--active]", "print the stored coin universe", cmdCoins},
+		{"status", "[--url URL]", "print recent runs and sync lag", cmdStatus},
+		{"ranks", "[--from YYYY-MM-DD] [--to YYYY-MM-DD] [--vs usd]", "rebuild the daily rank, dominance and totals tables", cmdRanks},
+		{"derive", "[--ids a,b] [--from YYYY-MM-DD] [--to YYYY-MM-DD]", "rebuild DERIVED_VS_CURRENCIES from the stored base currency", cmdDerive},
+		{"indicators", "[--ids a,b] [--from YYYY-MM-DD] [--vs usd]", "recompute the indicators table", cmdIndicators},
+		{"categories", "[--members]", "store the categories and their market data; --members also checks coin membership", cmdCategories},
+		{"exchanges", "", "store the exchanges, their daily volume (pro API) and the TICKER_IDS tickers", cmdExchanges},
+		{"global", "[--history]", "store a /global and DeFi snapshot; --history also backfills the daily totals (pro API)", cmdGlobal},
+		{"anomalies", "[--days N] [--no-alert]", "scan recent data for anomalies, store and alert new ones", cmdAnomalies},
+		{"plan", "[--format text|json] [--coins N]", "print the task plan, API credits and duration without fetching", cmdPlan},
+		{"migrate", "", "create the ClickHouse tables and exit", cmdMigrate},
+		{"config", "print [--format yaml|env]", "print the effective config with secrets masked", nil},
+	}
+}
+
+// categoryFiltered are the commands COINGECKO_CATEGORIES narrows down to the
+// coins of its categories.
+var categoryFiltered = map[string]bool{
+	"run":      true,
+	"backfill": true,
+	"sync":     true,
+	"gaps":     true,
+	"verify":   true,
+	"plan":     true,
+}
+
+// usageError is a bad invocation; an empty message means flag parsing has
+// already reported it.
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usagef(format string, args ...any) error {
+	return usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+// errVerifyFailed is returned by verify when the stored data has problems.
+var errVerifyFailed = errors.New("verification found problems")
+
+func runCLI(args []string) int {
+	global := flag.NewFlagSet("global", flag.ContinueOnError)
+	global.SetOutput(io.Discard)
+	configPath := global.String("config", os.Getenv("CONFIG_FILE"), "config file (.yaml, .yml or .toml)")
+	overrides := registerConfigFlags(global)
+	if err := global.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			printUsage(os.Stdout)
+			return exitOK
+		}
+		fmt.Fprintf(os.Stderr, "%v\n\n", err)
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+	args = global.Args()
+
+	name := defaultCommand
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(os.Stdout)
+		return exitOK
+	}
+
+	var cmd *command
+	for i := range commands {
+		if commands[i].name == name {
+			cmd = &commands[i]
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage(os.Stderr)
+		return exitUsage
+	}
+
+	cfg, err := LoadConfig(*configPath, overrides)
+	if name == "config" {
+		return configExitCode(cmdConfig(cfg, err, args))
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	}
+
+	lvl, err := log.ParseLevel(cfg.LogLevel)
+	if err != nil {
+		lvl = log.InfoLevel
+	}
+	log.SetLevel(lvl)
+	log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		ch := make(chan os.Signal, 2)
+		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
+		<-ch
+		log.Warn("shutdown signal received")
+		cancel()
+	}()
+
+	shutdownTracing, err := setupTracing(ctx, cfg)
+	if err != nil {
+		log.Errorf("tracing: %v", err)
+		return exitFailure
+	}
+	defer func() {
+		flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = shutdownTracing(flushCtx)
+	}()
+
+	if categoryFiltered[name] {
+		if err := resolveCategoryFilter(ctx, &cfg, NewCGClient(cfg)); err != nil {
+			log.Errorf("COINGECKO_CATEGORIES: %v", err)
+			return exitFailure
+		}
+	}
+
+	return exitCode(ctx, name, cmd.run(ctx, cfg, args))
+}
+
+func exitCode(ctx context.Context, name string, err error) int {
+	var ue usageError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &ue):
+		if ue.msg != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", name, ue.msg)
+		}
+		return exitUsage
+	case ctx.Err() != nil:
+		log.Warnf("%s interrupted: %v", name, err)
+		return exitInterrupted
+	case errors.Is(err, errTasksFailed), errors.Is(err, errVerifyFailed):
+		log.Warnf("%s incomplete: %v", name, err)
+		return exitIncomplete
+	default:
+		log.Errorf("%s failed: %v", name, err)
+		return exitFailure
+	}
+}
+
+func configExitCode(err error) int {
+	var ce *ConfigError
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+		return exitOK
+	case errors.As(err, &ce):
+		fmt.Fprintln(os.Stderr, err)
+		return exitConfig
+	default:
+		return exitCode(context.Background(), "config", err)
+	}
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s [--config FILE] [--<setting> VALUE ...] <command> [flags]\n\ncommands:\n", os.Args[0])
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-9s %s\n", c.name, c.summary)
+		if c.args != "" {
+			fmt.Fprintf(w, "  %-9s   %s %s\n", "", c.name, c.args)
+		}
+	}
+	fmt.Fprint(w, `
+settings come from defaults, then the config file (CONFIG_FILE), then env vars,
+then flags; a later source wins. Every env var has a flag and a file key, e.g.
+COINGECKO_RPS, --coingecko-rps and coingecko_rps.
+`)
+	fmt.Fprintf(w, "\nexit codes: %d ok, %d error, %d usage, %d incomplete, %d config, %d interrupted\n",
+		exitOK, exitFailure, exitUsage, exitIncomplete, exitConfig, exitInterrupted)
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(os.Stderr)
+	return fs
+}
+
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return usageError{}
+	}
+	if fs.NArg() > 0 {
+		return usagef("unexpected arguments: %s", strings.Join(fs.Args(), " "))
+	}
+	return nil
+}
@@ -3,8 +3,10 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
 
@@ -21,13 +23,310 @@ CREATE TABLE IF NOT EXISTS %s
     timestamp   DateTime64(3, 'UTC'),
     price       Float64,
     market_cap  Float64,
-    volume      Float64
+    volume      Float64,
+    provenance  LowCardinality(String) DEFAULT 'api',
+    inserted_at DateTime64(3, 'UTC') DEFAULT now64(3)
 ) ENGINE = MergeTree
 PARTITION BY toYYYYMM(_date)
 ORDER BY (_date, id, symbol, vs_currency, timestamp)
 SETTINGS index_granularity = 8192;
 `
 
+// addProvenanceColumn upgrades daily tables created before derived
+// currencies; their rows were all fetched.
+const addProvenanceColumn = `ALTER TABLE %s ADD COLUMN IF NOT EXISTS provenance LowCardinality(String) DEFAULT 'api'`
+
+// addInsertedAtColumn upgrades daily tables created before revisions
+// replaced rows by insert time; their rows count as inserted at the epoch.
+const addInsertedAtColumn = `ALTER TABLE %s ADD COLUMN IF NOT EXISTS inserted_at DateTime64(3, 'UTC') DEFAULT toDateTime64(0, 3, 'UTC')`
+
+const createCoinFXTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date       Date,
+    base        LowCardinality(String),
+    vs_currency LowCardinality(String),
+    rate        Float64,
+    source      LowCardinality(String),
+    fetched_at  DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(fetched_at)
+ORDER BY (base, vs_currency, _date);
+`
+
+// createMarketRankTable and createMarketTotalsTable hold values derived from
+// the daily table across coins: rank by market cap and dominance share per
+// coin, and the market totals per day. refreshMarketRanks rebuilds them for
+// the days whose points changed.
+const createMarketRankTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date       Date,
+    vs_currency LowCardinality(String),
+    id          LowCardinality(String),
+    symbol      LowCardinality(String),
+    rank        UInt32,
+    market_cap  Float64,
+    dominance   Float64,
+    computed_at DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(computed_at)
+PARTITION BY toYYYYMM(_date)
+ORDER BY (vs_currency, _date, id);
+`
+
+const createMarketTotalsTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date            Date,
+    vs_currency      LowCardinality(String),
+    total_market_cap Float64,
+    total_volume     Float64,
+    coins            UInt32,
+    computed_at      DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(computed_at)
+ORDER BY (vs_currency, _date);
+`
+
+// createRollupTable holds one row of aggregate states per coin, currency and
+// period (week or month); createRollupView feeds it from every insert into
+// the daily table. %[3]s is the function mapping a day to its period.
+const createRollupTable = `
+CREATE TABLE IF NOT EXISTS %[1]s
+(
+    period          Date,
+    id              LowCardinality(String),
+    vs_currency     LowCardinality(String),
+    first_price     AggregateFunction(argMin, Float64, DateTime64(3, 'UTC')),
+    last_price      AggregateFunction(argMax, Float64, DateTime64(3, 'UTC')),
+    min_price       SimpleAggregateFunction(min, Float64),
+    max_price       SimpleAggregateFunction(max, Float64),
+    avg_price       AggregateFunction(avg, Float64),
+    last_market_cap AggregateFunction(argMax, Float64, DateTime64(3, 'UTC')),
+    volume          SimpleAggregateFunction(sum, Float64),
+    days            SimpleAggregateFunction(sum, UInt32)
+) ENGINE = AggregatingMergeTree
+PARTITION BY toYear(period)
+ORDER BY (id, vs_currency, period);
+`
+
+const createRollupView = `
+CREATE MATERIALIZED VIEW IF NOT EXISTS %[1]s TO %[2]s AS
+SELECT
+    %[3]s(_date) AS period,
+    id,
+    vs_currency,
+    argMinState(price, timestamp) AS first_price,
+    argMaxState(price, timestamp) AS last_price,
+    min(price) AS min_price,
+    max(price) AS max_price,
+    avgState(price) AS avg_price,
+    argMaxState(market_cap, timestamp) AS last_market_cap,
+    sum(volume) AS volume,
+    toUInt32(count()) AS days
+FROM %[4]s
+GROUP BY period, id, vs_currency;
+`
+
+// rollupInsert aggregates the daily table into a rollup table like the view,
+// but from the latest point per day, so it can rebuild periods whose days
+// were revised.
+const rollupInsert = `
+INSERT INTO %[1]s (period, id, vs_currency, first_price, last_price, min_price, max_price, avg_price, last_market_cap, volume, days)
+SELECT
+    %[2]s(d) AS period,
+    cid,
+    vs,
+    argMinState(price, ts),
+    argMaxState(price, ts),
+    min(price),
+    max(price),
+    avgState(price),
+    argMaxState(mc, ts),
+    sum(vol),
+    toUInt32(count())
+FROM (
+    SELECT
+        _date AS d,
+        id AS cid,
+        vs_currency AS vs,
+        max(timestamp) AS ts,
+        argMax(price, timestamp) AS price,
+        argMax(market_cap, timestamp) AS mc,
+        argMax(volume, timestamp) AS vol
+    FROM %[3]s
+    WHERE %[4]s
+    GROUP BY d, cid, vs
+)
+GROUP BY period, cid, vs`
+
+const createCoinBoundsTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    id         LowCardinality(String),
+    symbol     LowCardinality(String),
+    has_data   UInt8,
+    first_date Date,
+    last_date  Date,
+    probes     UInt32,
+    updated_at DateTime('UTC') DEFAULT now()
+) ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY id;
+`
+
+const createCoinEmptyDaysTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date       Date,
+    id          LowCardinality(String),
+    vs_currency LowCardinality(String),
+    checked_at  DateTime('UTC') DEFAULT now()
+) ENGINE = ReplacingMergeTree(checked_at)
+ORDER BY (id, vs_currency, _date);
+`
+
+const createCoinRevisionsTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date          Date,
+    id             LowCardinality(String),
+    vs_currency    LowCardinality(String),
+    old_timestamp  DateTime64(3, 'UTC'),
+    new_timestamp  DateTime64(3, 'UTC'),
+    old_price      Float64,
+    new_price      Float64,
+    old_market_cap Float64,
+    new_market_cap Float64,
+    old_volume     Float64,
+    new_volume     Float64,
+    revised_at     DateTime('UTC') DEFAULT now()
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(_date)
+ORDER BY (id, vs_currency, _date, revised_at);
+`
+
+const createCoinsUniverseTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    id         String,
+    symbol     String,
+    name       String,
+    active     UInt8,
+    first_seen DateTime('UTC'),
+    updated_at DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY id;
+`
+
+const createCoinsHistoryTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    id         String,
+    symbol     String,
+    name       String,
+    valid_from Date,
+    valid_to   Nullable(Date),
+    updated_at DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (id, valid_from);
+`
+
+const createCoinsEventsTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    event_time DateTime64(3, 'UTC'),
+    id         String,
+    event      LowCardinality(String),
+    old_value  String,
+    new_value  String
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(event_time)
+ORDER BY (id, event_time);
+`
+
+const createETLRunsTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    run_id      UUID,
+    kind        LowCardinality(String),
+    started_at  DateTime64(3, 'UTC'),
+    finished_at Nullable(DateTime64(3, 'UTC')),
+    status      LowCardinality(String),
+    config_hash String,
+    tasks       UInt32,
+    inserted    UInt64,
+    api_days    UInt64,
+    errors      UInt32,
+    retried     UInt32,
+    missing     UInt32,
+    error       String,
+    updated_at  DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(updated_at)
+PARTITION BY toYYYYMM(started_at)
+ORDER BY (started_at, run_id);
+`
+
+const createETLTaskResultsTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    run_id        UUID,
+    finished_at   DateTime64(3, 'UTC'),
+    phase         LowCardinality(String),
+    id            LowCardinality(String),
+    symbol        LowCardinality(String),
+    vs_currency   LowCardinality(String),
+    window_from   Date,
+    window_to     Date,
+    retry         UInt16,
+    http_status   UInt16,
+    api_days      UInt32,
+    inserted      UInt32,
+    empty         UInt8,
+    missing_dates Array(String),
+    error         String
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(finished_at)
+ORDER BY (id, finished_at);
+`
+
+const createCoinQuarantineTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date          Date,
+    id             LowCardinality(String),
+    symbol         LowCardinality(String),
+    vs_currency    LowCardinality(String),
+    timestamp      DateTime64(3, 'UTC'),
+    price          Float64,
+    market_cap     Float64,
+    volume         Float64,
+    phase          LowCardinality(String),
+    reasons        Array(LowCardinality(String)),
+    detail         String,
+    quarantined_at DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(quarantined_at)
+PARTITION BY toYYYYMM(_date)
+ORDER BY (id, vs_currency, _date);
+`
+
+const createCoinAnomaliesTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date       Date,
+    id          LowCardinality(String),
+    vs_currency LowCardinality(String),
+    kind        LowCardinality(String),
+    score       Float64,
+    detail      String,
+    alerted     UInt8 DEFAULT 0,
+    detected_at DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(detected_at)
+PARTITION BY toYYYYMM(_date)
+ORDER BY (id, vs_currency, _date, kind);
+`
+
+// addAlertedColumn upgrades anomaly tables created before delivery was
+// recorded; their anomalies count as already posted.
+const addAlertedColumn = `ALTER TABLE %s ADD COLUMN IF NOT EXISTS alerted UInt8 DEFAULT 1 AFTER detail`
+
 type DailyPoint struct {
 	ID         string
 	Symbol     string
@@ -36,8 +335,198 @@ type DailyPoint struct {
 	Price      float64
 	MarketCap  float64
 	Volume     float64
+	Provenance string // empty for fetched points
 }
 
+const createCoinIndicatorsTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date       Date,
+    id          LowCardinality(String),
+    vs_currency LowCardinality(String),
+    indicator   LowCardinality(String),
+    value       Float64,
+    computed_at DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(computed_at)
+PARTITION BY toYYYYMM(_date)
+ORDER BY (id, vs_currency, indicator, _date);
+`
+
+// createPendingDaysTable holds the days the derived tables still have to be
+// rebuilt for, so a restart doesn't lose them. tracker names the refresh and
+// key its currency or coin.
+const createPendingDaysTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    tracker  LowCardinality(String),
+    key      String,
+    _date    Date,
+    added_at DateTime64(3, 'UTC')
+) ENGINE = ReplacingMergeTree(added_at)
+ORDER BY (tracker, key, _date);
+`
+
+// createCoinGlobalTable and createCoinGlobalDefiTable keep every /global and
+// /global/decentralized_finance_defi snapshot; createCoinGlobalHistoryTable
+// holds the daily totals from /global/market_cap_chart.
+const createCoinGlobalTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    snapshot_at               DateTime64(3, 'UTC'),
+    updated_at                DateTime64(3, 'UTC'),
+    active_cryptocurrencies   UInt32,
+    markets                   UInt32,
+    total_market_cap          Map(LowCardinality(String), Float64),
+    total_volume              Map(LowCardinality(String), Float64),
+    market_cap_percentage     Map(LowCardinality(String), Float64),
+    market_cap_change_24h_usd Float64
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(snapshot_at)
+ORDER BY snapshot_at;
+`
+
+const createCoinGlobalDefiTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    snapshot_at             DateTime64(3, 'UTC'),
+    defi_market_cap         Float64,
+    eth_market_cap          Float64,
+    defi_to_eth_ratio       Float64,
+    trading_volume_24h      Float64,
+    defi_dominance          Float64,
+    top_coin_name           String,
+    top_coin_defi_dominance Float64
+) ENGINE = MergeTree
+PARTITION BY toYYYYMM(snapshot_at)
+ORDER BY snapshot_at;
+`
+
+const createCoinGlobalHistoryTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date       Date,
+    vs_currency LowCardinality(String),
+    timestamp   DateTime64(3, 'UTC'),
+    market_cap  Float64,
+    volume      Float64,
+    fetched_at  DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(fetched_at)
+ORDER BY (vs_currency, _date);
+`
+
+// Category tables: the category list, the /coins/categories snapshot per
+// day, coin membership as validity intervals (valid_to NULL while current)
+// and the daily market cap of each category computed from its members.
+const createCoinCategoriesTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    category_id LowCardinality(String),
+    name        String,
+    updated_at  DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY category_id;
+`
+
+const createCategoryMarketTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date                 Date,
+    category_id           LowCardinality(String),
+    name                  String,
+    market_cap            Float64,
+    market_cap_change_24h Float64,
+    volume_24h            Float64,
+    top_3_coins           Array(String),
+    updated_at            DateTime64(3, 'UTC'),
+    fetched_at            DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(fetched_at)
+PARTITION BY toYYYYMM(_date)
+ORDER BY (category_id, _date);
+`
+
+const createCategoryMembershipTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    id          LowCardinality(String),
+    category_id LowCardinality(String),
+    valid_from  Date,
+    valid_to    Nullable(Date),
+    updated_at  DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY (id, category_id, valid_from);
+`
+
+const createCategoryMarketCapTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date       Date,
+    vs_currency LowCardinality(String),
+    category_id LowCardinality(String),
+    market_cap  Float64,
+    volume      Float64,
+    coins       UInt32,
+    computed_at DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(computed_at)
+PARTITION BY toYYYYMM(_date)
+ORDER BY (vs_currency, category_id, _date);
+`
+
+// Exchange tables: the /exchanges list, the daily BTC trading volume of
+// each exchange and a daily snapshot of the tickers of the coins in
+// TICKER_IDS.
+const createCoinExchangesTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    id                   LowCardinality(String),
+    name                 String,
+    year_established     UInt16,
+    country              String,
+    url                  String,
+    trust_score          UInt8,
+    trust_score_rank     UInt32,
+    trade_volume_24h_btc Float64,
+    updated_at           DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(updated_at)
+ORDER BY id;
+`
+
+const createExchangeVolumeTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date       Date,
+    exchange_id LowCardinality(String),
+    timestamp   DateTime64(3, 'UTC'),
+    volume_btc  Float64,
+    inserted_at DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(inserted_at)
+PARTITION BY toYYYYMM(_date)
+ORDER BY (exchange_id, _date);
+`
+
+const createTickersTable = `
+CREATE TABLE IF NOT EXISTS %s
+(
+    _date                Date,
+    coin_id              LowCardinality(String),
+    exchange_id          LowCardinality(String),
+    exchange_name        String,
+    base                 String,
+    target               String,
+    last                 Float64,
+    volume               Float64,
+    converted_last_usd   Float64,
+    converted_volume_usd Float64,
+    trust_score          LowCardinality(String),
+    bid_ask_spread_pct   Float64,
+    last_traded_at       DateTime64(3, 'UTC'),
+    is_anomaly           UInt8,
+    is_stale             UInt8,
+    fetched_at           DateTime64(3, 'UTC') DEFAULT now64(3)
+) ENGINE = ReplacingMergeTree(fetched_at)
+PARTITION BY toYYYYMM(_date)
+ORDER BY (coin_id, _date, exchange_id, base, target);
+`
+
 func chDSN(host, port, user, pass, db string) string {
 	u := &url.URL{
 		Scheme: "clickhouse",
@@ -83,18 +572,130 @@ func openClickHouse(ctx context.Context, cfg Config) (*sql.DB, error) {
 }
 
 func createTable(ctx context.Context, db *sql.DB, table string) error {
-	_, err := db.ExecContext(ctx, fmt.Sprintf(createCoinGeckoTable, table))
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(createCoinGeckoTable, table)); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(addProvenanceColumn, table)); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(addInsertedAtColumn, table))
+	return err
+}
+
+func createGlobalTables(ctx context.Context, db *sql.DB, table, defiTable, historyTable string) error {
+	for _, q := range []string{
+		fmt.Sprintf(createCoinGlobalTable, table),
+		fmt.Sprintf(createCoinGlobalDefiTable, defiTable),
+		fmt.Sprintf(createCoinGlobalHistoryTable, historyTable),
+	} {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createCategoryTables(ctx context.Context, db *sql.DB, table, marketTable, membershipTable, capTable string) error {
+	for _, q := range []string{
+		fmt.Sprintf(createCoinCategoriesTable, table),
+		fmt.Sprintf(createCategoryMarketTable, marketTable),
+		fmt.Sprintf(createCategoryMembershipTable, membershipTable),
+		fmt.Sprintf(createCategoryMarketCapTable, capTable),
+	} {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createExchangeTables(ctx context.Context, db *sql.DB, table, volumeTable, tickersTable string) error {
+	for _, q := range []string{
+		fmt.Sprintf(createCoinExchangesTable, table),
+		fmt.Sprintf(createExchangeVolumeTable, volumeTable),
+		fmt.Sprintf(createTickersTable, tickersTable),
+	} {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createFXTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createCoinFXTable, table))
+	return err
+}
+
+func createMarketTables(ctx context.Context, db *sql.DB, rankTable, totalsTable string) error {
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(createMarketRankTable, rankTable)); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createMarketTotalsTable, totalsTable))
+	return err
+}
+
+func createBoundsTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createCoinBoundsTable, table))
+	return err
+}
+
+func createEmptyDaysTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createCoinEmptyDaysTable, table))
+	return err
+}
+
+func createRevisionsTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createCoinRevisionsTable, table))
+	return err
+}
+
+func createCoinsTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createCoinsUniverseTable, table))
+	return err
+}
+
+func createUniverseTables(ctx context.Context, db *sql.DB, historyTable, eventsTable string) error {
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(createCoinsHistoryTable, historyTable)); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createCoinsEventsTable, eventsTable))
+	return err
+}
+
+func createQuarantineTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createCoinQuarantineTable, table))
+	return err
+}
+
+func createAnomaliesTable(ctx context.Context, db *sql.DB, table string) error {
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(createCoinAnomaliesTable, table)); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(addAlertedColumn, table))
+	return err
+}
+
+func createIndicatorsTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createCoinIndicatorsTable, table))
+	return err
+}
+
+func createPendingTable(ctx context.Context, db *sql.DB, table string) error {
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createPendingDaysTable, table))
 	return err
 }
 
-func getExistingDays(ctx context.Context, db *sql.DB, table, id string, from, to time.Time) (map[string]struct{}, error) {
+func getExistingDays(ctx context.Context, db *sql.DB, table, id, vs string, from, to time.Time) (map[string]struct{}, error) {
+	defer observeQuery("existing_days", time.Now())
+
 	q := fmt.Sprintf(`
 SELECT toString(_date) as d
 FROM %s
-WHERE id = ? AND _date BETWEEN toDate(?) AND toDate(?)
+WHERE id = ? AND vs_currency = ? AND _date BETWEEN toDate(?) AND toDate(?)
 GROUP BY d`, table)
 
-	rows, err := db.QueryContext(ctx, q, id, formatDate(from), formatDate(to))
+	rows, err := db.QueryContext(ctx, q, id, vs, formatDate(from), formatDate(to))
 	if err != nil {
 		return nil, err
 	}
@@ -111,60 +712,1623 @@ GROUP BY d`, table)
 	return out, rows.Err()
 }
 
-func getMaxDate(ctx context.Context, db *sql.DB, table, id string) (time.Time, bool, error) {
-	q := fmt.Sprintf(`SELECT max(_date) FROM %s WHERE id = ?`, table)
-	var dt sql.NullTime
-	if err := db.QueryRowContext(ctx, q, id).Scan(&dt); err != nil {
-		return time.Time{}, false, err
+// getDailyPoints returns the latest stored point per day, keyed by date.
+func getDailyPoints(ctx context.Context, db *sql.DB, table, id, vs string, from, to time.Time) (map[string]DailyPoint, error) {
+	defer observeQuery("daily_points", time.Now())
+
+	q := fmt.Sprintf(`
+SELECT
+    toString(_date) AS d,
+    argMax(symbol, timestamp),
+    max(timestamp),
+    argMax(price, timestamp),
+    argMax(market_cap, timestamp),
+    argMax(volume, timestamp)
+FROM %s
+WHERE id = ? AND vs_currency = ? AND _date BETWEEN toDate(?) AND toDate(?)
+GROUP BY d`, table)
+
+	rows, err := db.QueryContext(ctx, q, id, vs, formatDate(from), formatDate(to))
+	if err != nil {
+		return nil, err
 	}
-	if !dt.Valid {
-		return time.Time{}, false, nil
+	defer rows.Close()
+
+	out := make(map[string]DailyPoint)
+	for rows.Next() {
+		var d string
+		p := DailyPoint{ID: id, VsCurrency: vs}
+		if err := rows.Scan(&d, &p.Symbol, &p.Timestamp, &p.Price, &p.MarketCap, &p.Volume); err != nil {
+			return nil, err
+		}
+		p.Timestamp = p.Timestamp.UTC()
+		out[d] = p
 	}
-	return dateOnlyUTC(dt.Time), true, nil
+	return out, rows.Err()
 }
 
-func getMinDate(ctx context.Context, db *sql.DB, table, id string) (time.Time, bool, error) {
-	q := fmt.Sprintf(`SELECT min(_date) FROM %s WHERE id = ?`, table)
-	var dt sql.NullTime
-	if err := db.QueryRowContext(ctx, q, id).Scan(&dt); err != nil {
-		return time.Time{}, false, err
+// getLastPointBefore returns the latest point in [since, before) of the main
+// or the quarantine table, which share these columns.
+func getLastPointBefore(ctx context.Context, db *sql.DB, table, id, vs string, since, before time.Time) (DailyPoint, bool, error) {
+	defer observeQuery("last_point_before", time.Now())
+
+	q := fmt.Sprintf(`
+SELECT symbol, timestamp, price, market_cap, volume
+FROM %s
+WHERE id = ? AND vs_currency = ? AND _date >= toDate(?) AND _date < toDate(?)
+ORDER BY timestamp DESC
+LIMIT 1`, table)
+
+	p := DailyPoint{ID: id, VsCurrency: vs}
+	err := db.QueryRowContext(ctx, q, id, vs, formatDate(since), formatDate(before)).
+		Scan(&p.Symbol, &p.Timestamp, &p.Price, &p.MarketCap, &p.Volume)
+	if err == sql.ErrNoRows {
+		return p, false, nil
 	}
-	if !dt.Valid {
-		return time.Time{}, false, nil
+	if err != nil {
+		return p, false, err
 	}
-	return dateOnlyUTC(dt.Time), true, nil
+	p.Timestamp = p.Timestamp.UTC()
+	return p, true, nil
 }
 
-func insertDailyPoints(ctx context.Context, db *sql.DB, table string, pts []DailyPoint) (int, error) {
-	if len(pts) == 0 {
-		return 0, nil
+// deleteSuperseded deletes the rows of one coin on days that were inserted
+// before before, i.e. the ones a later insert of the same days replaced.
+func deleteSuperseded(ctx context.Context, db *sql.DB, table, id, vs string, days []string, before time.Time) error {
+	defer observeQuery("delete_superseded", time.Now())
+
+	if len(days) == 0 {
+		return nil
 	}
 
 	var sb strings.Builder
-	sb.WriteString("INSERT INTO ")
+	sb.WriteString("DELETE FROM ")
 	sb.WriteString(table)
-	sb.WriteString(" (id, symbol, vs_currency, timestamp, price, market_cap, volume) VALUES ")
+	sb.WriteString(" WHERE id = ? AND vs_currency = ? AND inserted_at < ? AND _date IN (")
 
-	args := make([]any, 0, len(pts)*7)
-	for i, p := range pts {
+	args := make([]any, 0, len(days)+3)
+	args = append(args, id, vs, before.UTC().Truncate(time.Millisecond))
+	for i, d := range days {
 		if i > 0 {
 			sb.WriteString(",")
 		}
-		sb.WriteString("(?, ?, ?, ?, ?, ?, ?)")
-		args = append(args,
-			p.ID,
-			p.Symbol,
-			p.VsCurrency,
-			p.Timestamp.UTC(),
-			p.Price,
-			p.MarketCap,
-			p.Volume,
-		)
+		sb.WriteString("toDate(?)")
+		args = append(args, d)
 	}
+	sb.WriteString(")")
 
 	_, err := db.ExecContext(ctx, sb.String(), args...)
-	if err != nil {
-		return 0, err
+	return err
+}
+
+func getMaxDate(ctx context.Context, db *sql.DB, table, id, vs string) (time.Time, bool, error) {
+	defer observeQuery("max_date", time.Now())
+
+	q := fmt.Sprintf(`SELECT max(_date) FROM %s WHERE id = ? AND vs_currency = ?`, table)
+	var dt sql.NullTime
+	if err := db.QueryRowContext(ctx, q, id, vs).Scan(&dt); err != nil {
+		return time.Time{}, false, err
 	}
-	return len(pts), nil
+	if !dt.Valid {
+		return time.Time{}, false, nil
+	}
+	return dateOnlyUTC(dt.Time), true, nil
+}
+
+func getMinDate(ctx context.Context, db *sql.DB, table, id, vs string) (time.Time, bool, error) {
+	defer observeQuery("min_date", time.Now())
+
+	q := fmt.Sprintf(`SELECT min(_date) FROM %s WHERE id = ? AND vs_currency = ?`, table)
+	var dt sql.NullTime
+	if err := db.QueryRowContext(ctx, q, id, vs).Scan(&dt); err != nil {
+		return time.Time{}, false, err
+	}
+	if !dt.Valid {
+		return time.Time{}, false, nil
+	}
+	return dateOnlyUTC(dt.Time), true, nil
+}
+
+type dateRange struct {
+	Min time.Time
+	Max time.Time
+}
+
+func getDateRanges(ctx context.Context, db *sql.DB, table, vs string) (map[string]dateRange, error) {
+	defer observeQuery("date_ranges", time.Now())
+
+	q := fmt.Sprintf(`SELECT id, min(_date), max(_date) FROM %s WHERE vs_currency = ? GROUP BY id`, table)
+
+	rows, err := db.QueryContext(ctx, q, vs)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]dateRange)
+	for rows.Next() {
+		var (
+			id       string
+			min, max time.Time
+		)
+		if err := rows.Scan(&id, &min, &max); err != nil {
+			return nil, err
+		}
+		out[id] = dateRange{Min: dateOnlyUTC(min), Max: dateOnlyUTC(max)}
+	}
+	return out, rows.Err()
+}
+
+func getCoinBounds(ctx context.Context, db *sql.DB, table string) (map[string]CoinBounds, error) {
+	defer observeQuery("coin_bounds", time.Now())
+
+	q := fmt.Sprintf(`SELECT id, symbol, has_data, first_date, last_date, probes, updated_at FROM %s FINAL`, table)
+
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]CoinBounds)
+	for rows.Next() {
+		var (
+			b       CoinBounds
+			hasData uint8
+			probes  uint32
+		)
+		if err := rows.Scan(&b.ID, &b.Symbol, &hasData, &b.First, &b.Last, &probes, &b.CheckedAt); err != nil {
+			return nil, err
+		}
+		b.HasData = hasData == 1
+		if b.HasData {
+			b.First = dateOnlyUTC(b.First)
+			b.Last = dateOnlyUTC(b.Last)
+		} else {
+			b.First, b.Last = time.Time{}, time.Time{}
+		}
+		b.Probes = int(probes)
+		out[b.ID] = b
+	}
+	return out, rows.Err()
+}
+
+func insertCoinBounds(ctx context.Context, db *sql.DB, table string, bounds []CoinBounds) error {
+	defer observeQuery("insert_bounds", time.Now())
+
+	if len(bounds) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (id, symbol, has_data, first_date, last_date, probes) VALUES ")
+
+	args := make([]any, 0, len(bounds)*6)
+	for i, b := range bounds {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?)")
+		var hasData uint8
+		first, last := time.Unix(0, 0).UTC(), time.Unix(0, 0).UTC()
+		if b.HasData {
+			hasData = 1
+			first, last = b.First, b.Last
+		}
+		args = append(args,
+			b.ID,
+			b.Symbol,
+			hasData,
+			first,
+			last,
+			uint32(b.Probes),
+		)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+func insertDailyPoints(ctx context.Context, db *sql.DB, table string, pts []DailyPoint) (int, error) {
+	defer observeQuery("insert_daily", time.Now())
+
+	if len(pts) == 0 {
+		return 0, nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (id, symbol, vs_currency, timestamp, price, market_cap, volume, provenance, inserted_at) VALUES ")
+
+	now := time.Now().UTC()
+	args := make([]any, 0, len(pts)*9)
+	for i, p := range pts {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		provenance := p.Provenance
+		if provenance == "" {
+			provenance = provenanceAPI
+		}
+		args = append(args,
+			p.ID,
+			p.Symbol,
+			p.VsCurrency,
+			p.Timestamp.UTC(),
+			p.Price,
+			p.MarketCap,
+			p.Volume,
+			provenance,
+			now,
+		)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	if err != nil {
+		return 0, err
+	}
+	return len(pts), nil
+}
+
+func insertEmptyDays(ctx context.Context, db *sql.DB, table, id, vs string, days []time.Time) error {
+	defer observeQuery("insert_empty_days", time.Now())
+
+	if len(days) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (_date, id, vs_currency) VALUES ")
+
+	args := make([]any, 0, len(days)*3)
+	for i, d := range days {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?)")
+		args = append(args, dateOnlyUTC(d), id, vs)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// findGaps returns the missing day ranges between the first and last stored day
+// of every coin and currency. Days confirmed empty by the API and quarantined
+// days are not gaps.
+func findGaps(ctx context.Context, db *sql.DB, table, emptyTable, quarantineTable string) ([]Gap, error) {
+	defer observeQuery("find_gaps", time.Now())
+
+	q := fmt.Sprintf(`
+SELECT id, vs_currency, sym, prev + 1 AS gap_from, next - 1 AS gap_to
+FROM (
+    SELECT id, vs_currency, argMaxIf(symbol, d, symbol != '') AS sym, arraySort(groupUniqArray(d)) AS ds
+    FROM (
+        SELECT id, vs_currency, toString(symbol) AS symbol, _date AS d FROM %s
+        UNION ALL
+        SELECT id, vs_currency, '' AS symbol, _date AS d FROM %s
+        UNION ALL
+        SELECT id, vs_currency, '' AS symbol, _date AS d FROM %s
+    )
+    GROUP BY id, vs_currency
+)
+ARRAY JOIN arrayPopBack(ds) AS prev, arrayPopFront(ds) AS next
+WHERE dateDiff('day', prev, next) > 1
+ORDER BY id, vs_currency, gap_from`, table, emptyTable, quarantineTable)
+
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []Gap
+	for rows.Next() {
+		var g Gap
+		if err := rows.Scan(&g.ID, &g.VsCurrency, &g.Symbol, &g.From, &g.To); err != nil {
+			return nil, err
+		}
+		g.From = dateOnlyUTC(g.From)
+		g.To = dateOnlyUTC(g.To)
+		out = append(out, g)
+	}
+	return out, rows.Err()
+}
+
+func insertQuarantined(ctx context.Context, db *sql.DB, table string, pts []QuarantinedPoint) error {
+	defer observeQuery("insert_quarantined", time.Now())
+
+	if len(pts) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (_date, id, symbol, vs_currency, timestamp, price, market_cap, volume, phase, reasons, detail) VALUES ")
+
+	args := make([]any, 0, len(pts)*11)
+	for i, p := range pts {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args,
+			dateOnlyUTC(p.Timestamp),
+			p.ID,
+			p.Symbol,
+			p.VsCurrency,
+			p.Timestamp.UTC(),
+			p.Price,
+			p.MarketCap,
+			p.Volume,
+			string(p.Phase),
+			p.Reasons,
+			p.Detail,
+		)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+type Revision struct {
+	Old DailyPoint
+	New DailyPoint
+}
+
+func insertRevisions(ctx context.Context, db *sql.DB, table string, revs []Revision) error {
+	defer observeQuery("insert_revisions", time.Now())
+
+	if len(revs) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (_date, id, vs_currency, old_timestamp, new_timestamp, old_price, new_price, old_market_cap, new_market_cap, old_volume, new_volume) VALUES ")
+
+	args := make([]any, 0, len(revs)*11)
+	for i, r := range revs {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args,
+			dateOnlyUTC(r.New.Timestamp),
+			r.New.ID,
+			r.New.VsCurrency,
+			r.Old.Timestamp.UTC(),
+			r.New.Timestamp.UTC(),
+			r.Old.Price,
+			r.New.Price,
+			r.Old.MarketCap,
+			r.New.MarketCap,
+			r.Old.Volume,
+			r.New.Volume,
+		)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+func getUniverse(ctx context.Context, db *sql.DB, table string) (map[string]UniverseCoin, error) {
+	q := fmt.Sprintf(`SELECT id, symbol, name, active, first_seen FROM %s FINAL`, table)
+
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]UniverseCoin)
+	for rows.Next() {
+		var (
+			c      UniverseCoin
+			active uint8
+		)
+		if err := rows.Scan(&c.ID, &c.Symbol, &c.Name, &active, &c.FirstSeen); err != nil {
+			return nil, err
+		}
+		c.Active = active == 1
+		out[c.ID] = c
+	}
+	return out, rows.Err()
+}
+
+func insertUniverse(ctx context.Context, db *sql.DB, table string, coins []UniverseCoin) error {
+	const chunk = 5000
+
+	for len(coins) > 0 {
+		n := min(len(coins), chunk)
+		part := coins[:n]
+		coins = coins[n:]
+
+		var sb strings.Builder
+		sb.WriteString("INSERT INTO ")
+		sb.WriteString(table)
+		sb.WriteString(" (id, symbol, name, active, first_seen) VALUES ")
+
+		args := make([]any, 0, len(part)*5)
+		for i, c := range part {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString("(?, ?, ?, ?, ?)")
+			var active uint8
+			if c.Active {
+				active = 1
+			}
+			args = append(args, c.ID, c.Symbol, c.Name, active, c.FirstSeen.UTC())
+		}
+
+		if _, err := db.ExecContext(ctx, sb.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getSymbolHistory(ctx context.Context, db *sql.DB, table string) (map[string][]SymbolVersion, error) {
+	q := fmt.Sprintf(`SELECT id, symbol, name, valid_from, valid_to FROM %s FINAL ORDER BY id, valid_from`, table)
+
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string][]SymbolVersion)
+	for rows.Next() {
+		var (
+			v  SymbolVersion
+			to sql.NullTime
+		)
+		if err := rows.Scan(&v.ID, &v.Symbol, &v.Name, &v.ValidFrom, &to); err != nil {
+			return nil, err
+		}
+		v.ValidFrom = dateOnlyUTC(v.ValidFrom)
+		if to.Valid {
+			v.ValidTo = dateOnlyUTC(to.Time)
+		}
+		out[v.ID] = append(out[v.ID], v)
+	}
+	return out, rows.Err()
+}
+
+func insertSymbolVersions(ctx context.Context, db *sql.DB, table string, versions []SymbolVersion) error {
+	const chunk = 5000
+
+	for len(versions) > 0 {
+		n := min(len(versions), chunk)
+		part := versions[:n]
+		versions = versions[n:]
+
+		var sb strings.Builder
+		sb.WriteString("INSERT INTO ")
+		sb.WriteString(table)
+		sb.WriteString(" (id, symbol, name, valid_from, valid_to) VALUES ")
+
+		args := make([]any, 0, len(part)*5)
+		for i, v := range part {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString("(?, ?, ?, ?, ?)")
+			var to *time.Time
+			if !v.ValidTo.IsZero() {
+				t := v.ValidTo
+				to = &t
+			}
+			args = append(args, v.ID, v.Symbol, v.Name, v.ValidFrom, to)
+		}
+
+		if _, err := db.ExecContext(ctx, sb.String(), args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertUniverseEvents(ctx context.Context, db *sql.DB, table string, events []UniverseEvent) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (event_time, id, event, old_value, new_value) VALUES ")
+
+	args := make([]any, 0, len(events)*5)
+	for i, e := range events {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?)")
+		args = append(args, e.At.UTC(), e.ID, e.Kind, e.Old, e.New)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+func getLatestDates(ctx context.Context, db *sql.DB, table string) (map[string]time.Time, error) {
+	defer observeQuery("latest_dates", time.Now())
+
+	q := fmt.Sprintf(`SELECT vs_currency, max(_date) FROM %s GROUP BY vs_currency`, table)
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			vs  string
+			max time.Time
+		)
+		if err := rows.Scan(&vs, &max); err != nil {
+			return nil, err
+		}
+		out[vs] = dateOnlyUTC(max)
+	}
+	return out, rows.Err()
+}
+
+func createRunTables(ctx context.Context, db *sql.DB, runsTable, resultsTable string) error {
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(createETLRunsTable, runsTable)); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(createETLTaskResultsTable, resultsTable))
+	return err
+}
+
+// insertRun writes the current state of a run; the row with the latest
+// updated_at wins, so a run is inserted once when it starts and again when
+// it finishes.
+func insertRun(ctx context.Context, db *sql.DB, table string, r RunRecord) error {
+	defer observeQuery("insert_run", time.Now())
+
+	var finished any
+	if !r.FinishedAt.IsZero() {
+		finished = r.FinishedAt.UTC()
+	}
+
+	q := fmt.Sprintf(`INSERT INTO %s (run_id, kind, started_at, finished_at, status, config_hash, tasks, inserted, api_days, errors, retried, missing, error) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, table)
+	_, err := db.ExecContext(ctx, q,
+		r.ID,
+		r.Kind,
+		r.StartedAt.UTC(),
+		finished,
+		r.Status,
+		r.ConfigHash,
+		uint32(r.Tasks),
+		uint64(r.Inserted),
+		uint64(r.APIDays),
+		uint32(r.Errors),
+		uint32(r.Retried),
+		uint32(r.Missing),
+		r.Error,
+	)
+	return err
+}
+
+func insertTaskResults(ctx context.Context, db *sql.DB, table, runID string, results []taskResultRow) error {
+	defer observeQuery("insert_task_results", time.Now())
+
+	if len(results) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (run_id, finished_at, phase, id, symbol, vs_currency, window_from, window_to, retry, http_status, api_days, inserted, empty, missing_dates, error) VALUES ")
+
+	args := make([]any, 0, len(results)*15)
+	for i, r := range results {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+
+		var empty uint8
+		if r.Empty {
+			empty = 1
+		}
+		missing := r.MissingDates
+		if missing == nil {
+			missing = []string{}
+		}
+		args = append(args,
+			runID,
+			r.At.UTC(),
+			string(r.Task.Phase),
+			r.Task.CoinID,
+			r.Task.Symbol,
+			r.Task.VsCurrency,
+			r.Task.From,
+			r.Task.To,
+			uint16(r.Task.Retry),
+			uint16(r.HTTPStatus),
+			uint32(r.APIDays),
+			uint32(r.Inserted),
+			empty,
+			missing,
+			r.Err,
+		)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// getLatestRuns returns the most recent run of every kind.
+func getLatestRuns(ctx context.Context, db *sql.DB, table string) ([]RunRecord, error) {
+	defer observeQuery("latest_runs", time.Now())
+
+	q := fmt.Sprintf(`
+SELECT toString(run_id), kind, started_at, finished_at, status, config_hash, tasks, inserted, api_days, errors, retried, missing, error
+FROM %s FINAL
+ORDER BY started_at DESC
+LIMIT 1 BY kind`, table)
+
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []RunRecord
+	for rows.Next() {
+		var (
+			r                             RunRecord
+			finished                      *time.Time
+			tasks, errs, retried, missing uint32
+			inserted, apiDays             uint64
+		)
+		if err := rows.Scan(&r.ID, &r.Kind, &r.StartedAt, &finished, &r.Status, &r.ConfigHash,
+			&tasks, &inserted, &apiDays, &errs, &retried, &missing, &r.Error); err != nil {
+			return nil, err
+		}
+		if finished != nil {
+			r.FinishedAt = *finished
+		}
+		r.Tasks, r.Inserted, r.APIDays = int(tasks), int(inserted), int(apiDays)
+		r.Errors, r.Retried, r.Missing = int(errs), int(retried), int(missing)
+		out = append(out, r)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Kind < out[j].Kind })
+	return out, rows.Err()
+}
+
+// getLastFinishedRun returns when the last run of kind that ran to the end,
+// with or without task errors, started. It is zero when there was none.
+func getLastFinishedRun(ctx context.Context, db *sql.DB, table, kind string) (time.Time, error) {
+	defer observeQuery("last_finished_run", time.Now())
+
+	q := fmt.Sprintf(`SELECT maxOrNull(started_at) FROM %s FINAL WHERE kind = ? AND status IN (?, ?)`, table)
+	var started *time.Time
+	if err := db.QueryRowContext(ctx, q, kind, RunOK, RunWithError).Scan(&started); err != nil || started == nil {
+		return time.Time{}, err
+	}
+	return *started, nil
+}
+
+// scanSeries streams the daily points of every coin in vs since the given day,
+// ranked by market cap per day, and calls fn once per coin in date order.
+func scanSeries(ctx context.Context, db *sql.DB, table, vs string, since time.Time, fn func(id string, pts []seriesPoint)) error {
+	defer observeQuery("scan_series", time.Now())
+
+	q := fmt.Sprintf(`
+SELECT id, d, price, market_cap, volume,
+       toUInt32(rank() OVER (PARTITION BY d ORDER BY market_cap DESC)) AS mc_rank
+FROM (
+    SELECT
+        toString(id) AS id,
+        _date AS d,
+        argMax(price, timestamp) AS price,
+        argMax(market_cap, timestamp) AS market_cap,
+        argMax(volume, timestamp) AS volume
+    FROM %s
+    WHERE vs_currency = ? AND _date >= toDate(?)
+    GROUP BY id, d
+)
+ORDER BY id, d`, table)
+
+	rows, err := db.QueryContext(ctx, q, vs, formatDate(since))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var (
+		cur string
+		pts []seriesPoint
+	)
+	for rows.Next() {
+		var (
+			id   string
+			p    seriesPoint
+			rank uint32
+		)
+		if err := rows.Scan(&id, &p.Date, &p.Price, &p.MarketCap, &p.Volume, &rank); err != nil {
+			return err
+		}
+		if id != cur && len(pts) > 0 {
+			fn(cur, pts)
+			pts = pts[:0]
+		}
+		cur = id
+		p.Date = dateOnlyUTC(p.Date)
+		p.Rank = int(rank)
+		pts = append(pts, p)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(pts) > 0 {
+		fn(cur, pts)
+	}
+	return nil
+}
+
+// getAnomalyKeys returns the keys of the anomalies already stored since the
+// given day, so a scan only reports new ones, each with whether it has been
+// posted to the webhook.
+func getAnomalyKeys(ctx context.Context, db *sql.DB, table, vs string, since time.Time) (map[string]bool, error) {
+	defer observeQuery("anomaly_keys", time.Now())
+
+	q := fmt.Sprintf(`SELECT id, _date, kind, max(alerted) FROM %s WHERE vs_currency = ? AND _date >= toDate(?) GROUP BY id, _date, kind`, table)
+	rows, err := db.QueryContext(ctx, q, vs, formatDate(since))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]bool)
+	for rows.Next() {
+		a := Anomaly{VsCurrency: vs}
+		var alerted uint8
+		if err := rows.Scan(&a.ID, &a.Date, &a.Kind, &alerted); err != nil {
+			return nil, err
+		}
+		out[a.key()] = alerted == 1
+	}
+	return out, rows.Err()
+}
+
+// insertAnomalies stores anomalies; stored again with alerted set, they
+// replace the rows written before they were posted.
+func insertAnomalies(ctx context.Context, db *sql.DB, table string, anomalies []Anomaly, alerted bool) error {
+	defer observeQuery("insert_anomalies", time.Now())
+
+	if len(anomalies) == 0 {
+		return nil
+	}
+
+	var flag uint8
+	if alerted {
+		flag = 1
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (_date, id, vs_currency, kind, score, detail, alerted) VALUES ")
+
+	args := make([]any, 0, len(anomalies)*7)
+	for i, a := range anomalies {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, dateOnlyUTC(a.Date), a.ID, a.VsCurrency, a.Kind, a.Score, a.Detail, flag)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// dailyPointsQuery selects one point per coin and day, the latest stored one.
+const dailyPointsQuery = `
+SELECT
+    toString(id) AS cid,
+    argMax(symbol, timestamp),
+    max(timestamp),
+    argMax(price, timestamp),
+    argMax(market_cap, timestamp) AS mc,
+    argMax(volume, timestamp)
+FROM %s
+WHERE %s
+GROUP BY cid, _date
+ORDER BY %s
+LIMIT %d OFFSET %d`
+
+// querySeries returns the points of one coin between from and to in date
+// order, skipping offset days and returning at most limit.
+func querySeries(ctx context.Context, db *sql.DB, table, id, vs string, from, to time.Time, limit, offset int) ([]DailyPoint, error) {
+	defer observeQuery("api_series", time.Now())
+
+	q := fmt.Sprintf(dailyPointsQuery, table,
+		"id = ? AND vs_currency = ? AND _date BETWEEN toDate(?) AND toDate(?)",
+		"_date", limit, offset)
+	return queryDailyPoints(ctx, db, q, vs, id, vs, formatDate(from), formatDate(to))
+}
+
+// querySnapshot returns the points of every coin on day by market cap,
+// largest first.
+func querySnapshot(ctx context.Context, db *sql.DB, table, vs string, day time.Time, limit, offset int) ([]DailyPoint, error) {
+	defer observeQuery("api_snapshot", time.Now())
+
+	q := fmt.Sprintf(dailyPointsQuery, table,
+		"vs_currency = ? AND _date = toDate(?)",
+		"mc DESC, cid", limit, offset)
+	return queryDailyPoints(ctx, db, q, vs, vs, formatDate(day))
+}
+
+// streamSeries calls fn for every point of one coin between from and to in
+// date order while the rows are read, so long histories are never held in
+// memory. An error from fn stops the query.
+func streamSeries(ctx context.Context, db *sql.DB, table, id, vs string, from, to time.Time, fn func(DailyPoint) error) error {
+	defer observeQuery("stream_series", time.Now())
+
+	q := fmt.Sprintf(`
+SELECT
+    toString(id) AS cid,
+    argMax(symbol, timestamp),
+    max(timestamp),
+    argMax(price, timestamp),
+    argMax(market_cap, timestamp),
+    argMax(volume, timestamp)
+FROM %s
+WHERE id = ? AND vs_currency = ? AND _date BETWEEN toDate(?) AND toDate(?)
+GROUP BY cid, _date
+ORDER BY _date`, table)
+	return eachDailyPoint(ctx, db, q, vs, fn, id, vs, formatDate(from), formatDate(to))
+}
+
+func queryDailyPoints(ctx context.Context, db *sql.DB, q, vs string, args ...any) ([]DailyPoint, error) {
+	var out []DailyPoint
+	err := eachDailyPoint(ctx, db, q, vs, func(p DailyPoint) error {
+		out = append(out, p)
+		return nil
+	}, args...)
+	return out, err
+}
+
+func eachDailyPoint(ctx context.Context, db *sql.DB, q, vs string, fn func(DailyPoint) error, args ...any) error {
+	rows, err := db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := DailyPoint{VsCurrency: vs}
+		if err := rows.Scan(&p.ID, &p.Symbol, &p.Timestamp, &p.Price, &p.MarketCap, &p.Volume); err != nil {
+			return err
+		}
+		p.Timestamp = p.Timestamp.UTC()
+		if err := fn(p); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// rebuildMarketDays recomputes rank, dominance and totals of vs for the given
+// days from the daily table, replacing what was derived before.
+func rebuildMarketDays(ctx context.Context, db *sql.DB, table, rankTable, totalsTable, vs string, days []time.Time) error {
+	defer observeQuery("rebuild_market_days", time.Now())
+
+	if len(days) == 0 {
+		return nil
+	}
+
+	in := make([]string, len(days))
+	args := make([]any, 0, len(days)+1)
+	args = append(args, vs)
+	for i, d := range days {
+		in[i] = "toDate(?)"
+		args = append(args, formatDate(d))
+	}
+	where := "vs_currency = ? AND _date IN (" + strings.Join(in, ",") + ")"
+
+	for _, t := range []string{rankTable, totalsTable} {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", t, where), args...); err != nil {
+			return err
+		}
+	}
+
+	perCoin := fmt.Sprintf(`
+SELECT
+    _date AS d,
+    toString(id) AS cid,
+    argMax(toString(symbol), timestamp) AS sym,
+    argMax(market_cap, timestamp) AS mc,
+    argMax(volume, timestamp) AS vol
+FROM %s
+WHERE %s
+GROUP BY d, cid`, table, where)
+
+	q := fmt.Sprintf(`
+INSERT INTO %s (_date, vs_currency, id, symbol, rank, market_cap, dominance)
+SELECT d, ?, cid, sym,
+       toUInt32(row_number() OVER (PARTITION BY d ORDER BY mc DESC, cid)),
+       mc,
+       mc / sum(mc) OVER (PARTITION BY d)
+FROM (%s)
+WHERE mc > 0`, rankTable, perCoin)
+	if _, err := db.ExecContext(ctx, q, append([]any{vs}, args...)...); err != nil {
+		return err
+	}
+
+	q = fmt.Sprintf(`
+INSERT INTO %s (_date, vs_currency, total_market_cap, total_volume, coins)
+SELECT d, ?, sum(mc), sum(vol), toUInt32(countIf(mc > 0))
+FROM (%s)
+GROUP BY d`, totalsTable, perCoin)
+	_, err := db.ExecContext(ctx, q, append([]any{vs}, args...)...)
+	return err
+}
+
+// rollupFilled is the comment that marks a rollup table whose fill from the
+// stored daily rows finished.
+const rollupFilled = "filled"
+
+// createRollupTables creates the rollup table and the view feeding it, then
+// fills the table with the daily rows inserted before the view. A table whose
+// fill did not finish is dropped and built again, so no day is counted twice.
+func createRollupTables(ctx context.Context, db *sql.DB, dailyTable, table, view, periodFn string) error {
+	comment, err := tableComment(ctx, db, table)
+	if err != nil {
+		return err
+	}
+	if comment == rollupFilled {
+		_, err := db.ExecContext(ctx, fmt.Sprintf(createRollupView, view, table, periodFn, dailyTable))
+		return err
+	}
+
+	for _, q := range []string{
+		"DROP VIEW IF EXISTS " + view,
+		"DROP TABLE IF EXISTS " + table,
+		fmt.Sprintf(createRollupTable, table),
+	} {
+		if _, err := db.ExecContext(ctx, q); err != nil {
+			return err
+		}
+	}
+	viewCreatedAt := time.Now().UTC().Truncate(time.Millisecond)
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(createRollupView, view, table, periodFn, dailyTable)); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, fmt.Sprintf(rollupInsert, table, periodFn, dailyTable, "inserted_at < ?"), viewCreatedAt); err != nil {
+		return fmt.Errorf("fill %s: %w", table, err)
+	}
+	_, err = db.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s MODIFY COMMENT '%s'", table, rollupFilled))
+	return err
+}
+
+// tableComment returns the comment of table, or "" when it does not exist.
+func tableComment(ctx context.Context, db *sql.DB, table string) (string, error) {
+	database, name := "", table
+	if i := strings.IndexByte(table, '.'); i >= 0 {
+		database, name = table[:i], table[i+1:]
+	}
+	var comment string
+	err := db.QueryRowContext(ctx,
+		`SELECT comment FROM system.tables WHERE database = if(? = '', currentDatabase(), ?) AND name = ?`,
+		database, database, name).Scan(&comment)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return comment, err
+}
+
+// rebuildRollup replaces the periods of one coin and currency from first to
+// last, which must be period starts, with aggregates of the stored days.
+func rebuildRollup(ctx context.Context, db *sql.DB, dailyTable, table, periodFn, id, vs string, first, last time.Time) error {
+	defer observeQuery("rebuild_rollup", time.Now())
+
+	q := fmt.Sprintf(`DELETE FROM %s WHERE id = ? AND vs_currency = ? AND period BETWEEN toDate(?) AND toDate(?)`, table)
+	if _, err := db.ExecContext(ctx, q, id, vs, formatDate(first), formatDate(last)); err != nil {
+		return err
+	}
+	where := fmt.Sprintf("id = ? AND vs_currency = ? AND %s(_date) BETWEEN toDate(?) AND toDate(?)", periodFn)
+	_, err := db.ExecContext(ctx, fmt.Sprintf(rollupInsert, table, periodFn, dailyTable, where), id, vs, formatDate(first), formatDate(last))
+	return err
+}
+
+// queryRollupSeries returns the periods of one coin whose start lies between
+// from and to, merging the aggregate states.
+func queryRollupSeries(ctx context.Context, db *sql.DB, table, id, vs string, from, to time.Time, limit, offset int) ([]RollupPoint, error) {
+	defer observeQuery("api_rollup_series", time.Now())
+
+	q := fmt.Sprintf(`
+SELECT
+    period,
+    argMinMerge(first_price),
+    argMaxMerge(last_price),
+    min(min_price),
+    max(max_price),
+    avgMerge(avg_price),
+    argMaxMerge(last_market_cap),
+    sum(volume),
+    sum(days)
+FROM %s
+WHERE id = ? AND vs_currency = ? AND period BETWEEN toDate(?) AND toDate(?)
+GROUP BY period
+ORDER BY period
+LIMIT %d OFFSET %d`, table, limit, offset)
+
+	rows, err := db.QueryContext(ctx, q, id, vs, formatDate(from), formatDate(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []RollupPoint
+	for rows.Next() {
+		var (
+			p    RollupPoint
+			days uint64
+		)
+		if err := rows.Scan(&p.Period, &p.FirstPrice, &p.LastPrice, &p.MinPrice, &p.MaxPrice, &p.AvgPrice, &p.MarketCap, &p.Volume, &days); err != nil {
+			return nil, err
+		}
+		p.Period = dateOnlyUTC(p.Period)
+		p.Days = int(days)
+		out = append(out, p)
+	}
+	return out, rows.Err()
+}
+
+func insertIndicators(ctx context.Context, db *sql.DB, table string, values []IndicatorValue) error {
+	defer observeQuery("insert_indicators", time.Now())
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (_date, id, vs_currency, indicator, value) VALUES ")
+
+	args := make([]any, 0, len(values)*5)
+	for i, v := range values {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?)")
+		args = append(args, dateOnlyUTC(v.Date), v.ID, v.VsCurrency, v.Indicator, v.Value)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+func insertFXRates(ctx context.Context, db *sql.DB, table, base string, rates []FXRate) error {
+	defer observeQuery("insert_fx_rates", time.Now())
+
+	if len(rates) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (_date, base, vs_currency, rate, source) VALUES ")
+
+	args := make([]any, 0, len(rates)*5)
+	for i, r := range rates {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?)")
+		args = append(args, dateOnlyUTC(r.Date), base, r.VsCurrency, r.Rate, r.Source)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+func insertPendingDays(ctx context.Context, db *sql.DB, table, tracker string, days map[string][]time.Time, addedAt time.Time) error {
+	defer observeQuery("insert_pending_days", time.Now())
+
+	if len(days) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (tracker, key, _date, added_at) VALUES ")
+
+	var args []any
+	for _, key := range sortedKeys(days) {
+		for _, d := range days[key] {
+			if len(args) > 0 {
+				sb.WriteString(",")
+			}
+			sb.WriteString("(?, ?, ?, ?)")
+			args = append(args, tracker, key, dateOnlyUTC(d), addedAt.UTC())
+		}
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// getPendingDays returns the stored days of tracker by key.
+func getPendingDays(ctx context.Context, db *sql.DB, table, tracker string) (map[string][]time.Time, error) {
+	defer observeQuery("get_pending_days", time.Now())
+
+	q := fmt.Sprintf(`SELECT DISTINCT key, _date FROM %s WHERE tracker = ?`, table)
+	rows, err := db.QueryContext(ctx, q, tracker)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string][]time.Time)
+	for rows.Next() {
+		var (
+			key string
+			day time.Time
+		)
+		if err := rows.Scan(&key, &day); err != nil {
+			return nil, err
+		}
+		out[key] = append(out[key], day)
+	}
+	return out, rows.Err()
+}
+
+// deletePendingDays deletes the days of tracker added before the given time.
+func deletePendingDays(ctx context.Context, db *sql.DB, table, tracker string, before time.Time) error {
+	defer observeQuery("delete_pending_days", time.Now())
+
+	q := fmt.Sprintf(`DELETE FROM %s WHERE tracker = ? AND added_at < ?`, table)
+	_, err := db.ExecContext(ctx, q, tracker, before.UTC().Truncate(time.Millisecond))
+	return err
+}
+
+// getFXRates returns every stored rate against base.
+func getFXRates(ctx context.Context, db *sql.DB, table, base string) ([]FXRate, error) {
+	defer observeQuery("get_fx_rates", time.Now())
+
+	q := fmt.Sprintf(`
+SELECT _date, toString(vs_currency), argMax(rate, fetched_at), argMax(toString(source), fetched_at)
+FROM %s
+WHERE base = ?
+GROUP BY _date, vs_currency`, table)
+
+	rows, err := db.QueryContext(ctx, q, base)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []FXRate
+	for rows.Next() {
+		var r FXRate
+		if err := rows.Scan(&r.Date, &r.VsCurrency, &r.Rate, &r.Source); err != nil {
+			return nil, err
+		}
+		r.Date = dateOnlyUTC(r.Date)
+		out = append(out, r)
+	}
+	return out, rows.Err()
+}
+
+func getLatestFXDates(ctx context.Context, db *sql.DB, table, base string) (map[string]time.Time, error) {
+	defer observeQuery("latest_fx_dates", time.Now())
+
+	q := fmt.Sprintf(`SELECT toString(vs_currency), max(_date) FROM %s WHERE base = ? GROUP BY vs_currency`, table)
+	rows, err := db.QueryContext(ctx, q, base)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]time.Time)
+	for rows.Next() {
+		var (
+			vs string
+			d  time.Time
+		)
+		if err := rows.Scan(&vs, &d); err != nil {
+			return nil, err
+		}
+		out[vs] = dateOnlyUTC(d)
+	}
+	return out, rows.Err()
+}
+
+func insertGlobalSnapshot(ctx context.Context, db *sql.DB, table string, g GlobalSnapshot) error {
+	defer observeQuery("insert_global", time.Now())
+
+	q := fmt.Sprintf(`INSERT INTO %s (snapshot_at, updated_at, active_cryptocurrencies, markets, total_market_cap, total_volume, market_cap_percentage, market_cap_change_24h_usd) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, table)
+	_, err := db.ExecContext(ctx, q,
+		g.SnapshotAt.UTC(),
+		g.UpdatedAt.UTC(),
+		g.ActiveCryptocurrencies,
+		g.Markets,
+		g.TotalMarketCap,
+		g.TotalVolume,
+		g.MarketCapPercentage,
+		g.MarketCapChange24hUSD,
+	)
+	return err
+}
+
+func insertGlobalDefiSnapshot(ctx context.Context, db *sql.DB, table string, d GlobalDefiSnapshot) error {
+	defer observeQuery("insert_global_defi", time.Now())
+
+	q := fmt.Sprintf(`INSERT INTO %s (snapshot_at, defi_market_cap, eth_market_cap, defi_to_eth_ratio, trading_volume_24h, defi_dominance, top_coin_name, top_coin_defi_dominance) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, table)
+	_, err := db.ExecContext(ctx, q,
+		d.SnapshotAt.UTC(),
+		d.DefiMarketCap,
+		d.EthMarketCap,
+		d.DefiToEthRatio,
+		d.TradingVolume24h,
+		d.DefiDominance,
+		d.TopCoinName,
+		d.TopCoinDefiDominance,
+	)
+	return err
+}
+
+func insertGlobalHistory(ctx context.Context, db *sql.DB, table string, pts []GlobalPoint) error {
+	defer observeQuery("insert_global_history", time.Now())
+
+	if len(pts) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (_date, vs_currency, timestamp, market_cap, volume) VALUES ")
+
+	args := make([]any, 0, len(pts)*5)
+	for i, p := range pts {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?)")
+		args = append(args, dateOnlyUTC(p.Timestamp), p.VsCurrency, p.Timestamp.UTC(), p.MarketCap, p.Volume)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// getGlobalHistoryMax returns the last stored day of the global history in
+// vs; ok is false when there is none.
+func getGlobalHistoryMax(ctx context.Context, db *sql.DB, table, vs string) (time.Time, bool, error) {
+	defer observeQuery("global_history_max", time.Now())
+
+	q := fmt.Sprintf(`SELECT max(_date) FROM %s WHERE vs_currency = ?`, table)
+	var dt sql.NullTime
+	if err := db.QueryRowContext(ctx, q, vs).Scan(&dt); err != nil {
+		return time.Time{}, false, err
+	}
+	if !dt.Valid {
+		return time.Time{}, false, nil
+	}
+	return dateOnlyUTC(dt.Time), true, nil
+}
+
+func insertCategories(ctx context.Context, db *sql.DB, table string, cats []Category) error {
+	defer observeQuery("insert_categories", time.Now())
+
+	if len(cats) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (category_id, name) VALUES ")
+
+	args := make([]any, 0, len(cats)*2)
+	for i, c := range cats {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?)")
+		args = append(args, c.ID, c.Name)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+func insertCategoryMarkets(ctx context.Context, db *sql.DB, table string, day time.Time, markets []CategoryMarket) error {
+	defer observeQuery("insert_category_markets", time.Now())
+
+	if len(markets) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (_date, category_id, name, market_cap, market_cap_change_24h, volume_24h, top_3_coins, updated_at) VALUES ")
+
+	args := make([]any, 0, len(markets)*8)
+	for i, m := range markets {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?)")
+		top := m.Top3CoinIDs
+		if top == nil {
+			top = []string{}
+		}
+		args = append(args, dateOnlyUTC(day), m.ID, m.Name, m.MarketCap, m.MarketCapChange24h, m.Volume24h, top, m.UpdatedAt.UTC())
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// getOpenMemberships returns the current categories of every coin with the
+// day each membership started. Coins whose memberships all ended map to an
+// empty set.
+func getOpenMemberships(ctx context.Context, db *sql.DB, table string) (map[string]map[string]time.Time, error) {
+	defer observeQuery("open_memberships", time.Now())
+
+	q := fmt.Sprintf(`SELECT toString(id), toString(category_id), valid_from, valid_to IS NULL FROM %s FINAL`, table)
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]map[string]time.Time)
+	for rows.Next() {
+		var (
+			id, cat string
+			from    time.Time
+			open    uint8
+		)
+		if err := rows.Scan(&id, &cat, &from, &open); err != nil {
+			return nil, err
+		}
+		if out[id] == nil {
+			out[id] = make(map[string]time.Time)
+		}
+		if open == 1 {
+			out[id][cat] = dateOnlyUTC(from)
+		}
+	}
+	return out, rows.Err()
+}
+
+func insertMemberships(ctx context.Context, db *sql.DB, table string, ms []CategoryMembership) error {
+	defer observeQuery("insert_memberships", time.Now())
+
+	if len(ms) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (id, category_id, valid_from, valid_to) VALUES ")
+
+	args := make([]any, 0, len(ms)*4)
+	for i, m := range ms {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?)")
+		var to *time.Time
+		if !m.ValidTo.IsZero() {
+			d := dateOnlyUTC(m.ValidTo)
+			to = &d
+		}
+		args = append(args, m.ID, m.CategoryID, dateOnlyUTC(m.ValidFrom), to)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// rebuildCategoryDays recomputes the market cap of every category on days
+// in vs from the coins that were members on each day.
+func rebuildCategoryDays(ctx context.Context, db *sql.DB, table, membershipTable, capTable, vs string, days []time.Time) error {
+	defer observeQuery("rebuild_category_days", time.Now())
+
+	if len(days) == 0 {
+		return nil
+	}
+
+	in := make([]string, len(days))
+	args := make([]any, 0, len(days)+1)
+	args = append(args, vs)
+	for i, d := range days {
+		in[i] = "toDate(?)"
+		args = append(args, formatDate(d))
+	}
+	where := "vs_currency = ? AND _date IN (" + strings.Join(in, ",") + ")"
+
+	if _, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s", capTable, where), args...); err != nil {
+		return err
+	}
+
+	q := fmt.Sprintf(`
+INSERT INTO %s (_date, vs_currency, category_id, market_cap, volume, coins)
+SELECT p.d, ?, m.cat, sum(p.mc), sum(p.vol), toUInt32(count())
+FROM (
+    SELECT _date AS d, toString(id) AS cid, argMax(market_cap, timestamp) AS mc, argMax(volume, timestamp) AS vol
+    FROM %s
+    WHERE %s
+    GROUP BY d, cid
+) AS p
+INNER JOIN (
+    SELECT toString(id) AS cid, toString(category_id) AS cat, valid_from, valid_to
+    FROM %s FINAL
+) AS m ON p.cid = m.cid
+WHERE p.d >= m.valid_from AND (m.valid_to IS NULL OR p.d < m.valid_to)
+GROUP BY p.d, m.cat`, capTable, table, where, membershipTable)
+	_, err := db.ExecContext(ctx, q, append([]any{vs}, args...)...)
+	return err
+}
+
+func insertExchanges(ctx context.Context, db *sql.DB, table string, exs []Exchange) error {
+	defer observeQuery("insert_exchanges", time.Now())
+
+	if len(exs) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (id, name, year_established, country, url, trust_score, trust_score_rank, trade_volume_24h_btc) VALUES ")
+
+	args := make([]any, 0, len(exs)*8)
+	for i, e := range exs {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?)")
+		args = append(args, e.ID, e.Name, uint16(e.YearEstablished), e.Country, e.URL, uint8(e.TrustScore), uint32(e.TrustScoreRank), e.TradeVolume24hBTC)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// getExchangeIDs returns the stored exchanges ordered by trust score rank,
+// unranked ones last.
+func getExchangeIDs(ctx context.Context, db *sql.DB, table string) ([]string, error) {
+	defer observeQuery("exchange_ids", time.Now())
+
+	q := fmt.Sprintf(`SELECT toString(id) FROM %s FINAL ORDER BY trust_score_rank = 0, trust_score_rank, id`, table)
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		out = append(out, id)
+	}
+	return out, rows.Err()
+}
+
+// ExchangeVolumePoint is the BTC trading volume of one exchange on one day.
+type ExchangeVolumePoint struct {
+	ExchangeID string
+	Timestamp  time.Time
+	VolumeBTC  float64
+}
+
+func insertExchangeVolume(ctx context.Context, db *sql.DB, table string, pts []ExchangeVolumePoint) error {
+	defer observeQuery("insert_exchange_volume", time.Now())
+
+	if len(pts) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (_date, exchange_id, timestamp, volume_btc) VALUES ")
+
+	args := make([]any, 0, len(pts)*4)
+	for i, p := range pts {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?)")
+		args = append(args, dateOnlyUTC(p.Timestamp), p.ExchangeID, p.Timestamp.UTC(), p.VolumeBTC)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
+}
+
+// getExchangeVolumeRanges returns the first and last stored volume day of
+// every exchange.
+func getExchangeVolumeRanges(ctx context.Context, db *sql.DB, table string) (map[string]dateRange, error) {
+	defer observeQuery("exchange_volume_ranges", time.Now())
+
+	q := fmt.Sprintf(`SELECT toString(exchange_id), min(_date), max(_date) FROM %s GROUP BY exchange_id`, table)
+	rows, err := db.QueryContext(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	out := make(map[string]dateRange)
+	for rows.Next() {
+		var (
+			id       string
+			min, max time.Time
+		)
+		if err := rows.Scan(&id, &min, &max); err != nil {
+			return nil, err
+		}
+		out[id] = dateRange{Min: dateOnlyUTC(min), Max: dateOnlyUTC(max)}
+	}
+	return out, rows.Err()
+}
+
+func insertTickers(ctx context.Context, db *sql.DB, table, coinID string, day time.Time, tickers []Ticker) error {
+	defer observeQuery("insert_tickers", time.Now())
+
+	if len(tickers) == 0 {
+		return nil
+	}
+
+	var sb strings.Builder
+	sb.WriteString("INSERT INTO ")
+	sb.WriteString(table)
+	sb.WriteString(" (_date, coin_id, exchange_id, exchange_name, base, target, last, volume, converted_last_usd, converted_volume_usd, trust_score, bid_ask_spread_pct, last_traded_at, is_anomaly, is_stale) VALUES ")
+
+	args := make([]any, 0, len(tickers)*15)
+	for i, t := range tickers {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		sb.WriteString("(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+		var anomaly, stale uint8
+		if t.IsAnomaly {
+			anomaly = 1
+		}
+		if t.IsStale {
+			stale = 1
+		}
+		args = append(args,
+			dateOnlyUTC(day), coinID, t.Market.Identifier, t.Market.Name, t.Base, t.Target,
+			t.Last, t.Volume, t.ConvertedLast["usd"], t.ConvertedVolume["usd"],
+			t.TrustScore, t.BidAskSpreadPercentage, t.LastTradedAt.UTC(),
+			anomaly, stale,
+		)
+	}
+
+	_, err := db.ExecContext(ctx, sb.String(), args...)
+	return err
 }
@@ -3,13 +3,19 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"golang.org/x/time/rate"
 )
 
@@ -31,6 +37,7 @@ type CGClient struct {
 	apiKeyHeader string
 	httpClient   *http.Client
 	limiter      *rate.Limiter
+	breaker      *circuitBreaker
 }
 
 func NewCGClient(cfg Config) *CGClient {
@@ -42,9 +49,124 @@ func NewCGClient(cfg Config) *CGClient {
 			Timeout: cfg.RequestTimeout,
 		},
 		limiter: rate.NewLimiter(rate.Limit(cfg.CGRPS), cfg.CGBurst),
+		breaker: newCircuitBreaker(cfg.CGBreakerFailures, cfg.CGBreakerCooldown),
 	}
 }
 
+var errCircuitOpen = errors.New("coingecko circuit open")
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+// circuitBreaker stops calling CoinGecko for cooldown after threshold
+// consecutive failures (transport errors, 429 and 5xx), then lets a single
+// request through to probe whether the API has recovered. Requests made
+// meanwhile wait for it.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	cooldown  time.Duration
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{threshold: threshold, cooldown: cooldown}
+}
+
+// breakerProbePoll is how often a request waiting on a probe in flight
+// checks the breaker again.
+const breakerProbePoll = time.Second
+
+// allow reports whether a request may go out now and, if not, how long
+// until the breaker should be asked again.
+func (b *circuitBreaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return true, 0
+	}
+	if left := b.cooldown - time.Since(b.openedAt); left > 0 {
+		return false, left
+	}
+	if b.probing {
+		return false, breakerProbePoll
+	}
+	b.probing = true
+	return true, 0
+}
+
+// wait blocks while the circuit is open, so requests resume once the
+// cooldown ends instead of failing and using up their retries.
+func (b *circuitBreaker) wait(ctx context.Context) error {
+	for {
+		ok, retryIn := b.allow()
+		if ok {
+			return nil
+		}
+		t := time.NewTimer(retryIn)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w: %w", errCircuitOpen, ctx.Err())
+		case <-t.C:
+		}
+	}
+}
+
+// record notes the outcome of a request: status 0 is a transport error and a
+// negative status means the request never reached CoinGecko.
+func (b *circuitBreaker) record(status int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	switch {
+	case status < 0:
+	case status == 0 || status == 429 || status >= 500:
+		b.failures++
+		if b.threshold > 0 && b.failures >= b.threshold {
+			b.openedAt = time.Now()
+		}
+	default:
+		b.failures = 0
+	}
+}
+
+func (b *circuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case b.threshold <= 0 || b.failures < b.threshold:
+		return circuitClosed
+	case time.Since(b.openedAt) < b.cooldown:
+		return circuitOpen
+	default:
+		return circuitHalfOpen
+	}
+}
+
+// SetRate changes the request rate; waiting callers pick it up immediately.
+func (c *CGClient) SetRate(rps float64, burst int) {
+	c.limiter.SetLimit(rate.Limit(rps))
+	c.limiter.SetBurst(burst)
+}
+
+func (c *CGClient) CircuitState() string {
+	return c.breaker.State()
+}
+
+func (c *CGClient) Ping(ctx context.Context) (int, error) {
+	status, _, err := c.getJSONRaw(ctx, "ping", c.baseURL+"/ping")
+	return status, err
+}
+
 func (c *CGClient) CoinsList(ctx context.Context, status string) ([]Coin, int, []byte, error) {
 	q := url.Values{}
 	q.Set("include_platform", "false")
@@ -55,7 +177,7 @@ func (c *CGClient) CoinsList(ctx context.Context, status string) ([]Coin, int, [
 
 	full := c.baseURL + "/coins/list?" + q.Encode()
 
-	statusCode, body, err := c.getJSONRaw(ctx, full)
+	statusCode, body, err := c.getJSONRaw(ctx, "coins/list", full)
 	if err != nil {
 		return nil, statusCode, body, err
 	}
@@ -77,7 +199,7 @@ func (c *CGClient) MarketChartRange(ctx context.Context, id, vs, fromDate, toDat
 	}
 	full := fmt.Sprintf("%s/coins/%s/market_chart/range?%s", c.baseURL, url.PathEscape(id), q.Encode())
 
-	status, body, err := c.getJSONRaw(ctx, full)
+	status, body, err := c.getJSONRaw(ctx, "coins/market_chart/range", full)
 	if err != nil {
 		return MarketChartRangeResp{}, status, body, err
 	}
@@ -89,13 +211,249 @@ func (c *CGClient) MarketChartRange(ctx context.Context, id, vs, fromDate, toDat
 	return out, status, body, nil
 }
 
-func (c *CGClient) getJSONRaw(ctx context.Context, fullURL string) (int, []byte, error) {
+// ExchangeRates returns the /exchange_rates values: units of each currency
+// per BTC.
+func (c *CGClient) ExchangeRates(ctx context.Context) (map[string]float64, int, []byte, error) {
+	status, body, err := c.getJSONRaw(ctx, "exchange_rates", c.baseURL+"/exchange_rates")
+	if err != nil {
+		return nil, status, body, err
+	}
+
+	var out struct {
+		Rates map[string]struct {
+			Value float64 `json:"value"`
+		} `json:"rates"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return nil, status, body, err
+	}
+	rates := make(map[string]float64, len(out.Rates))
+	for k, r := range out.Rates {
+		rates[k] = r.Value
+	}
+	return rates, status, body, nil
+}
+
+// GlobalResp is /global: market totals per currency and the market cap
+// share of the largest coins in percent.
+type GlobalResp struct {
+	Data struct {
+		ActiveCryptocurrencies          int                `json:"active_cryptocurrencies"`
+		Markets                         int                `json:"markets"`
+		TotalMarketCap                  map[string]float64 `json:"total_market_cap"`
+		TotalVolume                     map[string]float64 `json:"total_volume"`
+		MarketCapPercentage             map[string]float64 `json:"market_cap_percentage"`
+		MarketCapChangePercentage24hUSD float64            `json:"market_cap_change_percentage_24h_usd"`
+		UpdatedAt                       int64              `json:"updated_at"`
+	} `json:"data"`
+}
+
+// GlobalDefiResp is /global/decentralized_finance_defi; CoinGecko sends most
+// of its numbers as strings.
+type GlobalDefiResp struct {
+	Data struct {
+		DefiMarketCap        json.Number `json:"defi_market_cap"`
+		EthMarketCap         json.Number `json:"eth_market_cap"`
+		DefiToEthRatio       json.Number `json:"defi_to_eth_ratio"`
+		TradingVolume24h     json.Number `json:"trading_volume_24h"`
+		DefiDominance        json.Number `json:"defi_dominance"`
+		TopCoinName          string      `json:"top_coin_name"`
+		TopCoinDefiDominance float64     `json:"top_coin_defi_dominance"`
+	} `json:"data"`
+}
+
+// GlobalChartResp is /global/market_cap_chart (pro API).
+type GlobalChartResp struct {
+	MarketCapChart struct {
+		MarketCap [][]float64 `json:"market_cap"`
+		Volume    [][]float64 `json:"volume"`
+	} `json:"market_cap_chart"`
+}
+
+func (c *CGClient) Global(ctx context.Context) (GlobalResp, int, []byte, error) {
+	var out GlobalResp
+	status, body, err := c.getJSON(ctx, "global", c.baseURL+"/global", &out)
+	return out, status, body, err
+}
+
+func (c *CGClient) GlobalDefi(ctx context.Context) (GlobalDefiResp, int, []byte, error) {
+	var out GlobalDefiResp
+	status, body, err := c.getJSON(ctx, "global/decentralized_finance_defi", c.baseURL+"/global/decentralized_finance_defi", &out)
+	return out, status, body, err
+}
+
+// GlobalMarketCapChart returns the total market cap and volume over the last
+// days ("max" for all of them).
+func (c *CGClient) GlobalMarketCapChart(ctx context.Context, vs, days string) (GlobalChartResp, int, []byte, error) {
+	q := url.Values{}
+	q.Set("vs_currency", vs)
+	q.Set("days", days)
+	var out GlobalChartResp
+	status, body, err := c.getJSON(ctx, "global/market_cap_chart", c.baseURL+"/global/market_cap_chart?"+q.Encode(), &out)
+	return out, status, body, err
+}
+
+// Category is one entry of /coins/categories/list.
+type Category struct {
+	ID   string `json:"category_id"`
+	Name string `json:"name"`
+}
+
+// CategoryMarket is one entry of /coins/categories.
+type CategoryMarket struct {
+	ID                 string    `json:"id"`
+	Name               string    `json:"name"`
+	MarketCap          float64   `json:"market_cap"`
+	MarketCapChange24h float64   `json:"market_cap_change_24h"`
+	Volume24h          float64   `json:"volume_24h"`
+	Top3CoinIDs        []string  `json:"top_3_coins_id"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+func (c *CGClient) CategoriesList(ctx context.Context) ([]Category, int, []byte, error) {
+	var out []Category
+	status, body, err := c.getJSON(ctx, "coins/categories/list", c.baseURL+"/coins/categories/list", &out)
+	return out, status, body, err
+}
+
+func (c *CGClient) Categories(ctx context.Context) ([]CategoryMarket, int, []byte, error) {
+	var out []CategoryMarket
+	status, body, err := c.getJSON(ctx, "coins/categories", c.baseURL+"/coins/categories", &out)
+	return out, status, body, err
+}
+
+// CoinCategories returns the category names /coins/{id} lists for a coin,
+// leaving out every other part of the coin.
+func (c *CGClient) CoinCategories(ctx context.Context, id string) ([]string, int, []byte, error) {
+	q := url.Values{}
+	for _, k := range []string{"localization", "tickers", "market_data", "community_data", "developer_data", "sparkline"} {
+		q.Set(k, "false")
+	}
+	var out struct {
+		Categories []string `json:"categories"`
+	}
+	full := fmt.Sprintf("%s/coins/%s?%s", c.baseURL, url.PathEscape(id), q.Encode())
+	status, body, err := c.getJSON(ctx, "coins/{id}", full, &out)
+	return out.Categories, status, body, err
+}
+
+// CategoryCoinIDs returns one page of the coins of a category from
+// /coins/markets.
+func (c *CGClient) CategoryCoinIDs(ctx context.Context, category, vs string, page, perPage int) ([]string, int, []byte, error) {
+	q := url.Values{}
+	q.Set("vs_currency", vs)
+	q.Set("category", category)
+	q.Set("per_page", strconv.Itoa(perPage))
+	q.Set("page", strconv.Itoa(page))
+	var out []struct {
+		ID string `json:"id"`
+	}
+	status, body, err := c.getJSON(ctx, "coins/markets", c.baseURL+"/coins/markets?"+q.Encode(), &out)
+	ids := make([]string, len(out))
+	for i, m := range out {
+		ids[i] = m.ID
+	}
+	return ids, status, body, err
+}
+
+// Exchange is one entry of /exchanges.
+type Exchange struct {
+	ID                string  `json:"id"`
+	Name              string  `json:"name"`
+	YearEstablished   int     `json:"year_established"`
+	Country           string  `json:"country"`
+	URL               string  `json:"url"`
+	TrustScore        int     `json:"trust_score"`
+	TrustScoreRank    int     `json:"trust_score_rank"`
+	TradeVolume24hBTC float64 `json:"trade_volume_24h_btc"`
+}
+
+// Ticker is one market of a coin from /coins/{id}/tickers. Converted prices
+// and volumes are keyed by btc, eth and usd.
+type Ticker struct {
+	Base   string `json:"base"`
+	Target string `json:"target"`
+	Market struct {
+		Name       string `json:"name"`
+		Identifier string `json:"identifier"`
+	} `json:"market"`
+	Last                   float64            `json:"last"`
+	Volume                 float64            `json:"volume"`
+	ConvertedLast          map[string]float64 `json:"converted_last"`
+	ConvertedVolume        map[string]float64 `json:"converted_volume"`
+	TrustScore             string             `json:"trust_score"`
+	BidAskSpreadPercentage float64            `json:"bid_ask_spread_percentage"`
+	LastTradedAt           time.Time          `json:"last_traded_at"`
+	IsAnomaly              bool               `json:"is_anomaly"`
+	IsStale                bool               `json:"is_stale"`
+}
+
+// Exchanges returns one page of /exchanges, ordered by trust score rank.
+func (c *CGClient) Exchanges(ctx context.Context, page, perPage int) ([]Exchange, int, []byte, error) {
+	q := url.Values{}
+	q.Set("per_page", strconv.Itoa(perPage))
+	q.Set("page", strconv.Itoa(page))
+	var out []Exchange
+	status, body, err := c.getJSON(ctx, "exchanges", c.baseURL+"/exchanges?"+q.Encode(), &out)
+	return out, status, body, err
+}
+
+// ExchangeVolumeChartRange returns the BTC trading volume of an exchange
+// between two unix times as [ms, volume] pairs (pro API, at most 31 days per
+// call). CoinGecko sends the volumes as strings.
+func (c *CGClient) ExchangeVolumeChartRange(ctx context.Context, id string, from, to int64) ([][]json.Number, int, []byte, error) {
+	q := url.Values{}
+	q.Set("from", strconv.FormatInt(from, 10))
+	q.Set("to", strconv.FormatInt(to, 10))
+	var out [][]json.Number
+	full := fmt.Sprintf("%s/exchanges/%s/volume_chart/range?%s", c.baseURL, url.PathEscape(id), q.Encode())
+	status, body, err := c.getJSON(ctx, "exchanges/volume_chart/range", full, &out)
+	return out, status, body, err
+}
+
+// CoinTickers returns one page of /coins/{id}/tickers; CoinGecko serves 100
+// tickers per page.
+func (c *CGClient) CoinTickers(ctx context.Context, id string, page int) ([]Ticker, int, []byte, error) {
+	q := url.Values{}
+	q.Set("page", strconv.Itoa(page))
+	var out struct {
+		Tickers []Ticker `json:"tickers"`
+	}
+	full := fmt.Sprintf("%s/coins/%s/tickers?%s", c.baseURL, url.PathEscape(id), q.Encode())
+	status, body, err := c.getJSON(ctx, "coins/tickers", full, &out)
+	return out.Tickers, status, body, err
+}
+
+// getJSON fetches fullURL and decodes the body into out.
+func (c *CGClient) getJSON(ctx context.Context, endpoint, fullURL string, out any) (int, []byte, error) {
+	status, body, err := c.getJSONRaw(ctx, endpoint, fullURL)
+	if err != nil {
+		return status, body, err
+	}
+	return status, body, json.Unmarshal(body, out)
+}
+
+func (c *CGClient) getJSONRaw(ctx context.Context, endpoint, fullURL string) (int, []byte, error) {
+	if err := c.breaker.wait(ctx); err != nil {
+		return 0, nil, err
+	}
+
+	waitStart := time.Now()
+	_, waitSpan := tracer.Start(ctx, "coingecko.limiter_wait")
 	if err := c.limiter.Wait(ctx); err != nil {
+		endSpan(waitSpan, err)
+		c.breaker.record(-1)
 		return 0, nil, err
 	}
+	waitSpan.End()
+	metricLimiterWait.Observe(time.Since(waitStart).Seconds())
+
+	ctx, span := tracer.Start(ctx, "coingecko.request", trace.WithAttributes(attribute.String("http.endpoint", endpoint)))
+	defer span.End()
 
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fullURL, nil)
 	if err != nil {
+		c.breaker.record(-1)
 		return 0, nil, err
 	}
 	req.Header.Set("Accept", "application/json")
@@ -104,15 +462,28 @@ func (c *CGClient) getJSONRaw(ctx context.Context, fullURL string) (int, []byte,
 		req.Header.Set(c.apiKeyHeader, c.apiKey)
 	}
 
+	start := time.Now()
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
+		span.SetStatus(codes.Error, err.Error())
+		metricCGRequests.WithLabelValues(endpoint, "0").Inc()
+		if ctx.Err() != nil {
+			c.breaker.record(-1)
+		} else {
+			c.breaker.record(0)
+		}
 		return 0, nil, err
 	}
 	defer resp.Body.Close()
 
 	body, _ := io.ReadAll(resp.Body)
+	metricCGRequestDuration.WithLabelValues(endpoint).Observe(time.Since(start).Seconds())
+	metricCGRequests.WithLabelValues(endpoint, strconv.Itoa(resp.StatusCode)).Inc()
+	c.breaker.record(resp.StatusCode)
+	span.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
 
 	if resp.StatusCode >= 400 {
+		span.SetStatus(codes.Error, http.StatusText(resp.StatusCode))
 		return resp.StatusCode, body, fmt.Errorf("http %d: %s", resp.StatusCode, truncate(body, 500))
 	}
 
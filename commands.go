@@ -0,0 +1,886 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func cmdRun(ctx context.Context, cfg Config, args []string) error {
+	if err := parseFlags(newFlagSet("run"), args); err != nil {
+		return err
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	a.serveHTTP(ctx)
+	a.serveGRPC(ctx)
+	go a.watchConfig(ctx)
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+	a.loadSymbols(ctx)
+
+	allCoins, activeCoins, universe, _ := a.loadUniverse(ctx)
+	lastRefresh := time.Now()
+
+	activeDetected, err := a.backfill(ctx, allCoins)
+	if err != nil && !errors.Is(err, errTasksFailed) {
+		return err
+	}
+
+	if len(universe.Delisted) > 0 {
+		topUpCoins(ctx, cfg, a.db, universe.Delisted, a.tasksCh, a.resultsCh)
+	}
+
+	if len(activeCoins) == 0 {
+		log.Warn("active coins list from API is empty; fallback to backfill-detected active coins")
+		activeCoins = coinFromIDMap(activeDetected)
+	}
+
+	return a.syncLoop(ctx, activeCoins, lastRefresh)
+}
+
+func cmdBackfill(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("backfill")
+	ids := fs.String("ids", "", "comma-separated coin ids (overrides COINGECKO_IDS)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *ids != "" {
+		cfg.CoinIDsFilter = parseCSVSet(*ids)
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+	a.loadSymbols(ctx)
+
+	allCoins, _, universe, err := a.loadUniverse(ctx)
+	if err != nil {
+		return fmt.Errorf("coins list: %w", err)
+	}
+
+	_, err = a.backfill(ctx, allCoins)
+	if err != nil && !errors.Is(err, errTasksFailed) {
+		return err
+	}
+	if len(universe.Delisted) > 0 {
+		topUpCoins(ctx, cfg, a.db, universe.Delisted, a.tasksCh, a.resultsCh)
+	}
+	a.refreshDerived(ctx)
+	return err
+}
+
+func cmdSync(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("sync")
+	once := fs.Bool("once", false, "run a single incremental round and exit")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if !*once {
+		a.serveHTTP(ctx)
+		a.serveGRPC(ctx)
+		go a.watchConfig(ctx)
+	}
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+	a.loadSymbols(ctx)
+
+	_, activeCoins, _, err := a.loadUniverse(ctx)
+	lastRefresh := time.Now()
+	if err != nil || len(activeCoins) == 0 {
+		log.Warn("coins list unavailable; using the stored active universe")
+		if activeCoins, err = a.storedActiveCoins(ctx); err != nil {
+			return fmt.Errorf("stored universe: %w", err)
+		}
+	}
+
+	if *once {
+		a.startWorkers(ctx)
+		a.refreshFX(ctx)
+		status.SetActiveCoins(len(activeCoins))
+		err := runIncrementalOnce(ctx, cfg, a.db, activeCoins, a.tasksCh, a.resultsCh)
+		a.refreshDerived(ctx)
+		return err
+	}
+	return a.syncLoop(ctx, activeCoins, lastRefresh)
+}
+
+func cmdGaps(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("gaps")
+	list := fs.Bool("list", false, "print the gaps instead of repairing them")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+
+	if *list {
+		gaps, err := findGaps(ctx, a.db, cfg.CHTable, cfg.CHEmptyDaysTable, cfg.CHQuarantineTable)
+		if err != nil {
+			return err
+		}
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tVS\tFROM\tTO\tDAYS")
+		for _, g := range gaps {
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\n", g.ID, g.VsCurrency, formatDate(g.From), formatDate(g.To), daysBetween(g.From, g.To)+1)
+		}
+		return tw.Flush()
+	}
+
+	a.loadSymbols(ctx)
+	a.startWorkers(ctx)
+	a.refreshFX(ctx)
+	err = RunGapRepair(ctx, cfg, a.db, a.tasksCh, a.resultsCh)
+	a.refreshDerived(ctx)
+	return err
+}
+
+func cmdRepair(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("repair")
+	id := fs.String("id", "", "coin id (required)")
+	fromStr := fs.String("from", "", "first day, YYYY-MM-DD (required)")
+	toStr := fs.String("to", "", "last day, YYYY-MM-DD (default yesterday)")
+	vs := fs.String("vs", cfg.VsCurrency, "vs currency")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	if *id == "" || *fromStr == "" {
+		return usagef("--id and --from are required")
+	}
+	from, err := time.Parse("2006-01-02", *fromStr)
+	if err != nil {
+		return usagef("bad --from: %v", err)
+	}
+	to := yesterdayUTC()
+	if *toStr != "" {
+		if to, err = time.Parse("2006-01-02", *toStr); err != nil {
+			return usagef("bad --to: %v", err)
+		}
+	}
+	if to.Before(from) {
+		return usagef("--to is before --from")
+	}
+	if cfg.DerivedVsCurrencies[strings.ToLower(*vs)] {
+		return usagef("--vs %s is derived from %s; use derive", *vs, cfg.VsCurrency)
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+	a.loadSymbols(ctx)
+	a.startWorkers(ctx)
+	a.refreshFX(ctx)
+
+	sym, ok := a.syms.SymbolAt(*id, to)
+	if !ok {
+		sym = strings.ToUpper(*id)
+	}
+
+	// Revision tasks re-fetch every day of the window and overwrite the
+	// ones whose values changed, auditing them like the revision window.
+	var pending []Task
+	for _, t := range backfillTasks(*id, sym, from, to) {
+		t.VsCurrency = strings.ToLower(*vs)
+		t.Phase = PhaseRevision
+		pending = append(pending, t)
+	}
+
+	log.WithFields(log.Fields{
+		"id":    *id,
+		"vs":    *vs,
+		"from":  formatDate(from),
+		"to":    formatDate(to),
+		"tasks": len(pending),
+	}).Info("repair started")
+
+	var (
+		inserted    = 0
+		revised     = 0
+		quarantined = 0
+		failed      = 0
+	)
+	run := startRun(ctx, cfg, a.db, "repair")
+	err = runTasks(ctx, pending, a.tasksCh, a.resultsCh, func(res TaskResult) []Task {
+		run.record(ctx, res)
+		if (res.Err != "" || len(res.MissingDates) > 0) && res.Task.Retry < cfg.MaxRetriesPerBlock {
+			rt := res.Task
+			rt.Retry++
+			return []Task{rt}
+		}
+		if res.Err != "" {
+			failed++
+			log.WithFields(log.Fields{
+				"id":   res.Task.CoinID,
+				"from": formatDate(res.Task.From),
+				"to":   formatDate(res.Task.To),
+			}).Warnf("repair task error: %s", res.Err)
+		}
+		inserted += res.Inserted
+		revised += res.Revised
+		quarantined += res.Quarantined
+		return nil
+	})
+	run.finish(ctx, err)
+	a.refreshDerived(ctx)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"insertedSum": inserted,
+		"revised":     revised,
+		"quarantined": quarantined,
+		"failed":      failed,
+	}).Info("repair finished")
+
+	if failed > 0 {
+		return fmt.Errorf("repair: %w: %d tasks", errTasksFailed, failed)
+	}
+	return nil
+}
+
+func cmdVerify(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("verify")
+	maxLag := fs.Int("max-lag", 1, "days the newest stored day may trail yesterday")
+	verbose := fs.Bool("v", false, "print every problem")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	gaps, err := findGaps(ctx, a.db, cfg.CHTable, cfg.CHEmptyDaysTable, cfg.CHQuarantineTable)
+	if err != nil {
+		return fmt.Errorf("gaps: %w", err)
+	}
+	gapDays := 0
+	for _, g := range gaps {
+		gapDays += daysBetween(g.From, g.To) + 1
+		if *verbose {
+			fmt.Printf("gap      %s %s %s..%s\n", g.ID, g.VsCurrency, formatDate(g.From), formatDate(g.To))
+		}
+	}
+	fmt.Printf("gaps: %d (%d days)\n", len(gaps), gapDays)
+
+	// Coverage: every coin with known bounds should be stored from
+	// max(first, START_DATE) up to its last discovered day.
+	bounds, err := getCoinBounds(ctx, a.db, cfg.CHBoundsTable)
+	if err != nil {
+		return fmt.Errorf("bounds: %w", err)
+	}
+	ranges, err := getDateRanges(ctx, a.db, cfg.CHTable, cfg.VsCurrency)
+	if err != nil {
+		return fmt.Errorf("date ranges: %w", err)
+	}
+	yday := yesterdayUTC()
+	ids := make([]string, 0, len(bounds))
+	for id := range bounds {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	incomplete := 0
+	for _, id := range ids {
+		b := bounds[id]
+		if !b.HasData || (cfg.CoinIDsFilter != nil && !cfg.CoinIDsFilter[id]) {
+			continue
+		}
+		from, to := b.First, b.Last
+		if from.Before(cfg.StartDate) {
+			from = cfg.StartDate
+		}
+		if to.After(yday) {
+			to = yday
+		}
+		if to.Before(from) {
+			continue
+		}
+
+		r, ok := ranges[id]
+		switch {
+		case !ok:
+			incomplete++
+			if *verbose {
+				fmt.Printf("coverage %s: nothing stored, expected %s..%s\n", id, formatDate(from), formatDate(to))
+			}
+		case r.Min.After(from) || r.Max.Before(to):
+			incomplete++
+			if *verbose {
+				fmt.Printf("coverage %s: stored %s..%s, expected %s..%s\n", id, formatDate(r.Min), formatDate(r.Max), formatDate(from), formatDate(to))
+			}
+		}
+	}
+	fmt.Printf("coverage: %d coins incomplete\n", incomplete)
+
+	latest, err := getLatestDates(ctx, a.db, cfg.CHTable)
+	if err != nil {
+		return fmt.Errorf("latest dates: %w", err)
+	}
+	lagging := 0
+	for _, vs := range sortedKeys(latest) {
+		lag := daysBetween(latest[vs], yday)
+		fmt.Printf("lag %s: %d days (newest %s)\n", vs, lag, formatDate(latest[vs]))
+		if lag > *maxLag {
+			lagging++
+		}
+	}
+
+	if len(gaps) > 0 || incomplete > 0 || lagging > 0 {
+		return fmt.Errorf("%w: %d gaps, %d incomplete coins, %d lagging currencies", errVerifyFailed, len(gaps), incomplete, lagging)
+	}
+	return nil
+}
+
+func cmdCoins(ctx context.Context, cfg Config, args []string) error {
+	if len(args) == 0 || args[0] != "list" {
+		return usagef("expected subcommand: list")
+	}
+	fs := newFlagSet("coins list")
+	activeOnly := fs.Bool("active", false, "only active coins")
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	stored, err := getUniverse(ctx, a.db, cfg.CHCoinsTable)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSYMBOL\tNAME\tACTIVE\tFIRST_SEEN")
+	for _, id := range sortedKeys(stored) {
+		c := stored[id]
+		if *activeOnly && !c.Active {
+			continue
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%t\t%s\n", c.ID, c.Symbol, c.Name, c.Active, formatDate(c.FirstSeen))
+	}
+	return tw.Flush()
+}
+
+func cmdStatus(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("status")
+	url := fs.String("url", "", "also print /status of a running instance, e.g. http://localhost:8080")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	runs, err := getLatestRuns(ctx, a.db, cfg.CHRunsTable)
+	if err != nil {
+		return fmt.Errorf("runs: %w", err)
+	}
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "KIND\tSTARTED\tFINISHED\tSTATUS\tTASKS\tINSERTED\tERRORS")
+	for _, r := range runs {
+		finished := "-"
+		if !r.FinishedAt.IsZero() {
+			finished = r.FinishedAt.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%d\t%d\t%d\n", r.Kind, r.StartedAt.Format(time.RFC3339), finished, r.Status, r.Tasks, r.Inserted, r.Errors)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	latest, err := getLatestDates(ctx, a.db, cfg.CHTable)
+	if err != nil {
+		return fmt.Errorf("latest dates: %w", err)
+	}
+	yday := yesterdayUTC()
+	for _, vs := range sortedKeys(latest) {
+		fmt.Printf("\nlag %s: %d days (newest %s)", vs, daysBetween(latest[vs], yday), formatDate(latest[vs]))
+	}
+	fmt.Println()
+
+	if *url == "" {
+		return nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(*url, "/")+"/status", nil)
+	if err != nil {
+		return usagef("bad --url: %v", err)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("live status: %w", err)
+	}
+	defer resp.Body.Close()
+	fmt.Println()
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+func cmdAnomalies(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("anomalies")
+	days := fs.Int("days", cfg.AnomalyWindowDays, "days to scan (overrides ANOMALY_WINDOW_DAYS)")
+	noAlert := fs.Bool("no-alert", false, "don't post anomalies to ANOMALY_WEBHOOK_URL; the next alerting scan posts them")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *days < anomalyMinReturns+1 {
+		return usagef("--days must be at least %d", anomalyMinReturns+1)
+	}
+	cfg.AnomalyWindowDays = *days
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+
+	found, err := RunAnomalyScan(ctx, cfg, a.db, !*noAlert)
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATE\tID\tKIND\tSCORE\tDETAIL")
+	for _, an := range found {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%.1f\t%s\n", formatDate(an.Date), an.ID, an.Kind, an.Score, an.Detail)
+	}
+	if ferr := tw.Flush(); err == nil {
+		err = ferr
+	}
+	return err
+}
+
+func cmdRanks(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("ranks")
+	fromStr := fs.String("from", formatDate(cfg.StartDate), "first day, YYYY-MM-DD")
+	toStr := fs.String("to", formatDate(yesterdayUTC()), "last day, YYYY-MM-DD")
+	vs := fs.String("vs", cfg.VsCurrency, "vs currency")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	from, err := time.Parse("2006-01-02", *fromStr)
+	if err != nil {
+		return usagef("bad --from: %v", err)
+	}
+	to, err := time.Parse("2006-01-02", *toStr)
+	if err != nil {
+		return usagef("bad --to: %v", err)
+	}
+	if to.Before(from) {
+		return usagef("--to is before --from")
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+
+	days := daysInclusive(from, to)
+	start := time.Now()
+	if err := refreshMarketRanks(ctx, cfg, a.db, strings.ToLower(*vs), days); err != nil {
+		return err
+	}
+	log.WithFields(log.Fields{
+		"vs":      *vs,
+		"from":    formatDate(from),
+		"to":      formatDate(to),
+		"days":    len(days),
+		"elapsed": time.Since(start).Round(time.Millisecond),
+	}).Info("market ranks rebuilt")
+	return nil
+}
+
+func cmdGlobal(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("global")
+	history := fs.Bool("history", false, "backfill the daily global totals from /global/market_cap_chart")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *history && !proAPI(cfg) {
+		return usagef("--history needs the pro API (COINGECKO_API_KEY with x-cg-pro-api-key)")
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+
+	if err := takeGlobalSnapshots(ctx, cfg, a.cg, a.db); err != nil {
+		return err
+	}
+	log.Info("global snapshot stored")
+	if *history {
+		n, err := backfillGlobalHistory(ctx, cfg, a.cg, a.db)
+		if err != nil {
+			return fmt.Errorf("global history: %w", err)
+		}
+		log.WithFields(log.Fields{"vs": cfg.VsCurrency, "days": n}).Info("global history stored")
+	}
+	return nil
+}
+
+func cmdCategories(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("categories")
+	members := fs.Bool("members", false, "also check the categories of every active coin on /coins/{id}")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+
+	byName, err := refreshCategoryMarkets(ctx, cfg, a.cg, a.db)
+	if err != nil {
+		return err
+	}
+	if *members {
+		if err := a.refreshMemberships(ctx, byName); err != nil {
+			return fmt.Errorf("memberships: %w", err)
+		}
+		a.refreshDerived(ctx)
+	}
+	return nil
+}
+
+func cmdExchanges(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("exchanges")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+	return a.syncExchanges(ctx)
+}
+
+func cmdDerive(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("derive")
+	ids := fs.String("ids", "", "comma-separated coin ids (default: every stored coin)")
+	fromStr := fs.String("from", formatDate(cfg.StartDate), "first day, YYYY-MM-DD")
+	toStr := fs.String("to", formatDate(yesterdayUTC()), "last day, YYYY-MM-DD")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if len(cfg.DerivedVsCurrencies) == 0 {
+		return usagef("DERIVED_VS_CURRENCIES is empty")
+	}
+	from, err := time.Parse("2006-01-02", *fromStr)
+	if err != nil {
+		return usagef("bad --from: %v", err)
+	}
+	to, err := time.Parse("2006-01-02", *toStr)
+	if err != nil {
+		return usagef("bad --to: %v", err)
+	}
+	if to.Before(from) {
+		return usagef("--to is before --from")
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+	a.refreshFX(ctx)
+
+	coins := parseCSVSet(*ids)
+	if coins == nil {
+		ranges, err := getDateRanges(ctx, a.db, cfg.CHTable, cfg.VsCurrency)
+		if err != nil {
+			return err
+		}
+		coins = make(map[string]bool, len(ranges))
+		for id := range ranges {
+			coins[id] = true
+		}
+	}
+
+	start := time.Now()
+	derived := 0
+	for _, id := range sortedKeys(coins) {
+		n, err := rederive(ctx, cfg, a.db, id, from, to)
+		if err != nil {
+			a.refreshDerived(ctx)
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		derived += n
+	}
+	a.refreshDerived(ctx)
+	log.WithFields(log.Fields{
+		"base":    cfg.VsCurrency,
+		"from":    formatDate(from),
+		"to":      formatDate(to),
+		"coins":   len(coins),
+		"derived": derived,
+		"elapsed": time.Since(start).Round(time.Millisecond),
+	}).Info("derived currencies rebuilt")
+	return nil
+}
+
+func cmdIndicators(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("indicators")
+	ids := fs.String("ids", "", "comma-separated coin ids (default: every stored coin)")
+	fromStr := fs.String("from", formatDate(cfg.StartDate), "first day to recompute, YYYY-MM-DD")
+	vsFlag := fs.String("vs", cfg.VsCurrency, "vs currency")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	from, err := time.Parse("2006-01-02", *fromStr)
+	if err != nil {
+		return usagef("bad --from: %v", err)
+	}
+	vs := strings.ToLower(*vsFlag)
+	if len(enabledIndicators(cfg)) == 0 {
+		return usagef("no indicators enabled in INDICATORS")
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+
+	coins := parseCSVSet(*ids)
+	if coins == nil {
+		ranges, err := getDateRanges(ctx, a.db, cfg.CHTable, vs)
+		if err != nil {
+			return err
+		}
+		coins = make(map[string]bool, len(ranges))
+		for id := range ranges {
+			coins[id] = true
+		}
+	}
+
+	start := time.Now()
+	values := 0
+	for _, id := range sortedKeys(coins) {
+		n, err := computeIndicators(ctx, cfg, a.db, id, vs, from)
+		if err != nil {
+			return fmt.Errorf("%s: %w", id, err)
+		}
+		values += n
+	}
+	log.WithFields(log.Fields{
+		"vs":      vs,
+		"from":    formatDate(from),
+		"coins":   len(coins),
+		"values":  values,
+		"elapsed": time.Since(start).Round(time.Millisecond),
+	}).Info("indicators recomputed")
+	return nil
+}
+
+func cmdMigrate(ctx context.Context, cfg Config, args []string) error {
+	if err := parseFlags(newFlagSet("migrate"), args); err != nil {
+		return err
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	if err := a.migrate(ctx); err != nil {
+		return err
+	}
+	log.Info("tables are up to date")
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cmdConfig runs without a valid config so that problems can be inspected;
+// loadErr is reported after the effective values are printed.
+func cmdConfig(cfg Config, loadErr error, args []string) error {
+	if len(args) == 0 || args[0] != "print" {
+		return usagef("expected subcommand: print")
+	}
+	fs := newFlagSet("config print")
+	format := fs.String("format", "yaml", "yaml or env")
+	if err := parseFlags(fs, args[1:]); err != nil {
+		return err
+	}
+
+	entries := cfg.Entries()
+	switch *format {
+	case "yaml":
+		tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		for _, e := range entries {
+			fmt.Fprintf(tw, "%s: %s\t# %s\n", e.Key, strconv.Quote(e.Value), e.Source)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	case "env":
+		for _, e := range entries {
+			fmt.Printf("%s=%s\n", strings.ToUpper(e.Key), e.Value)
+		}
+	default:
+		return usagef("unknown --format %q", *format)
+	}
+	return loadErr
+}
+
+func cmdPlan(ctx context.Context, cfg Config, args []string) error {
+	fs := newFlagSet("plan")
+	format := fs.String("format", "text", "text or json")
+	top := fs.Int("coins", 20, "coins to list in text output, by task count (0 = all)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *format != "text" && *format != "json" {
+		return usagef("unknown --format %q", *format)
+	}
+
+	a, err := openApp(ctx, cfg)
+	if err != nil {
+		return err
+	}
+	defer a.Close()
+
+	p, err := buildPlan(ctx, cfg, a.db, a.cg)
+	if err != nil {
+		return err
+	}
+
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(p)
+	}
+
+	fmt.Printf("universe: %d coins (%s), %d without known bounds\n\n", p.UniverseCoins, p.UniverseSource, p.UnknownBounds)
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PHASE\tCOINS\tTASKS\tDAYS\t")
+	for _, ph := range p.Phases {
+		note := ""
+		if ph.Estimated {
+			note = "(estimated)"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\n", ph.Phase, ph.Coins, ph.Tasks, ph.Days, note)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	coins := p.Coins
+	if *top > 0 && len(coins) > *top {
+		coins = coins[:*top]
+	}
+	if len(coins) > 0 {
+		fmt.Printf("\ntop %d of %d coins by tasks:\n", len(coins), len(p.Coins))
+		fmt.Fprintln(tw, "ID\tSYMBOL\tTASKS\tDAYS\tBY PHASE")
+		for _, c := range coins {
+			var parts []string
+			for _, ph := range p.Phases {
+				if n := c.Tasks[ph.Phase]; n > 0 {
+					parts = append(parts, fmt.Sprintf("%s=%d", ph.Phase, n))
+				}
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%d\t%d\t%s\n", c.ID, c.Symbol, c.Total, c.Days, strings.Join(parts, " "))
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("\ncalls: %d, credits: %d, duration at %.4g rps: %s\n", p.Calls, p.Credits, p.RPS, p.Duration)
+	return nil
+}
@@ -1,9 +1,19 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"net/url"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/BurntSushi/toml"
+	log "github.com/sirupsen/logrus"
+	"go.yaml.in/yaml/v3"
 )
 
 type Config struct {
@@ -16,84 +26,613 @@ type Config struct {
 	CGRPS          float64
 	CGBurst        int
 	CoinIDsFilter  map[string]bool
+	CategoryFilter map[string]bool
+
+	CGBreakerFailures int
+	CGBreakerCooldown time.Duration
+
+	CHHost                 string
+	CHPort                 string
+	CHUser                 string
+	CHPassword             string
+	CHDatabase             string
+	CHTable                string
+	CHBoundsTable          string
+	CHEmptyDaysTable       string
+	CHRevisionsTable       string
+	CHCoinsTable           string
+	CHUniverseTable        string
+	CHUniverseEventsTable  string
+	CHRunsTable            string
+	CHTaskResultsTable     string
+	CHQuarantineTable      string
+	CHAnomaliesTable       string
+	CHMarketRankTable      string
+	CHMarketTotalsTable    string
+	CHWeeklyTable          string
+	CHMonthlyTable         string
+	CHIndicatorsTable      string
+	CHFXTable              string
+	CHGlobalTable          string
+	CHGlobalDefiTable      string
+	CHGlobalHistoryTable   string
+	CHCategoriesTable      string
+	CHCategoryMarketTable  string
+	CHCategoryMembersTable string
+	CHCategoryCapTable     string
+	CHExchangesTable       string
+	CHExchangeVolumeTable  string
+	CHTickersTable         string
+	CHPendingDaysTable     string
+
+	Workers              int
+	StartDate            time.Time
+	DiscoveryProbeDays   int
+	DiscoveryMaxStepDays int
+	DiscoveryRecheck     time.Duration
+	MaxRetriesPerBlock   int
+	SyncEvery            time.Duration
+	HTTPAddr             string
+	GRPCAddr             string
+	StallTimeout         time.Duration
+	RevisionDays         int
+	GapScanEvery         time.Duration
+	CoinsRefreshEvery    time.Duration
+	LogLevel             string
+
+	TraceExporter    string
+	TraceSampleRatio float64
+
+	ConfigWatchEvery time.Duration
+
+	ValidationRules          map[string]bool
+	ValidationMaxJumpRatio   float64
+	ValidationMaxSupplyRatio float64
+	ValidationMaxVolumeRatio float64
 
-	CHHost     string
-	CHPort     string
-	CHUser     string
-	CHPassword string
-	CHDatabase string
-	CHTable    string
+	AnomalyScanEvery    time.Duration
+	AnomalyWindowDays   int
+	AnomalyZScore       float64
+	AnomalyFlatlineDays int
+	AnomalyRankTop      int
+	AnomalyRankJump     int
+	AnomalyWebhookURL   string
 
-	Workers            int
-	StartDate          time.Time
-	EmptyStopBlocks    int
-	MaxSearchBlocks    int
-	MaxRetriesPerBlock int
-	SyncEvery          time.Duration
-	LogLevel           string
+	Indicators map[string]bool
+
+	DerivedVsCurrencies map[string]bool
+	FXSource            string
+	FXMaxAgeDays        int
+
+	GlobalSnapshotEvery time.Duration
+
+	CategoryRefreshEvery    time.Duration
+	CategoryMembershipEvery time.Duration
+
+	ExchangeSyncEvery time.Duration
+	ExchangeIDs       map[string]bool
+	ExchangeTop       int
+	TickerIDs         map[string]bool
+
+	// sources records where each setting came from, keyed by env name;
+	// path and flags are kept so the config can be reloaded.
+	sources map[string]string
+	path    string
+	flags   map[string]string
 }
 
-func LoadConfig() Config {
-	cfg := Config{
-		CGBaseURL:      getenv("COINGECKO_BASE_URL", "https://pro-api.coingecko.com/api/v3"),
-		CGAPIKey:       getenv("COINGECKO_API_KEY", ""),
-		CGAPIKeyHeader: getenv("COINGECKO_API_KEY_HEADER", "x-cg-pro-api-key"),
-		VsCurrency:     getenv("COINGECKO_VS_CURRENCY", "usd"),
-		Interval:       getenv("COINGECKO_INTERVAL", "daily"),
-		RequestTimeout: mustDuration(getenv("COINGECKO_TIMEOUT", "30s")),
-		CGRPS:          mustFloat(getenv("COINGECKO_RPS", "6")),  // подстрой под свой план.
-		CGBurst:        mustInt(getenv("COINGECKO_BURST", "12")), // подстрой под свой план
+// setting binds one Config field to its env var. The config file key is the
+// env name in lower case and the CLI flag the same with dashes, e.g.
+// COINGECKO_RPS, coingecko_rps and --coingecko-rps.
+type setting struct {
+	env    string
+	def    string
+	secret bool
+	field  func(c *Config) any
+}
 
-		CHHost:     getenv("CLICKHOUSE_HOST", "localhost"),
-		CHPort:     getenv("CLICKHOUSE_PORT", "9000"),
-		CHUser:     getenv("CLICKHOUSE_USER", "clickhouse"),
-		CHPassword: getenv("CLICKHOUSE_PASSWORD", "clickhouse"),
-		CHDatabase: getenv("CLICKHOUSE_DATABASE", "default"),
-		CHTable:    getenv("CLICKHOUSE_TABLE", "coingecko_market_cap_daily"),
+var settings = []setting{
+	{env: "COINGECKO_BASE_URL", def: "https://pro-api.coingecko.com/api/v3", field: func(c *Config) any { return &c.CGBaseURL }},
+	{env: "COINGECKO_API_KEY", secret: true, field: func(c *Config) any { return &c.CGAPIKey }},
+	{env: "COINGECKO_API_KEY_HEADER", def: "x-cg-pro-api-key", field: func(c *Config) any { return &c.CGAPIKeyHeader }},
+	{env: "COINGECKO_VS_CURRENCY", def: "usd", field: func(c *Config) any { return &c.VsCurrency }},
+	{env: "COINGECKO_INTERVAL", def: "daily", field: func(c *Config) any { return &c.Interval }},
+	{env: "COINGECKO_TIMEOUT", def: "30s", field: func(c *Config) any { return &c.RequestTimeout }},
+	{env: "COINGECKO_RPS", def: "6", field: func(c *Config) any { return &c.CGRPS }},      // подстрой под свой план.
+	{env: "COINGECKO_BURST", def: "12", field: func(c *Config) any { return &c.CGBurst }}, // подстрой под свой план
+	{env: "COINGECKO_IDS", field: func(c *Config) any { return &c.CoinIDsFilter }},
+	{env: "COINGECKO_CATEGORIES", field: func(c *Config) any { return &c.CategoryFilter }}, // вместо COINGECKO_IDS, id категорий
+	{env: "COINGECKO_BREAKER_FAILURES", def: "10", field: func(c *Config) any { return &c.CGBreakerFailures }},
+	{env: "COINGECKO_BREAKER_COOLDOWN", def: "30s", field: func(c *Config) any { return &c.CGBreakerCooldown }},
 
-		Workers:            mustInt(getenv("WORKERS", "8")),
-		EmptyStopBlocks:    mustInt(getenv("EMPTY_STOP_BLOCKS", "2")),
-		MaxSearchBlocks:    mustInt(getenv("MAX_SEARCH_BLOCKS", "30")), // NEW
-		MaxRetriesPerBlock: mustInt(getenv("MAX_RETRIES_PER_BLOCK", "3")),
-		SyncEvery:          mustDuration(getenv("SYNC_EVERY", "6h")),
-		LogLevel:           getenv("LOG_LEVEL", "info"),
-	}
+	{env: "CLICKHOUSE_HOST", def: "localhost", field: func(c *Config) any { return &c.CHHost }},
+	{env: "CLICKHOUSE_PORT", def: "9000", field: func(c *Config) any { return &c.CHPort }},
+	{env: "CLICKHOUSE_USER", def: "clickhouse", field: func(c *Config) any { return &c.CHUser }},
+	{env: "CLICKHOUSE_PASSWORD", def: "clickhouse", secret: true, field: func(c *Config) any { return &c.CHPassword }},
+	{env: "CLICKHOUSE_DATABASE", def: "default", field: func(c *Config) any { return &c.CHDatabase }},
+	{env: "CLICKHOUSE_TABLE", def: "coingecko_market_cap_daily", field: func(c *Config) any { return &c.CHTable }},
+	{env: "CLICKHOUSE_BOUNDS_TABLE", def: "coingecko_coin_bounds", field: func(c *Config) any { return &c.CHBoundsTable }},
+	{env: "CLICKHOUSE_EMPTY_DAYS_TABLE", def: "coingecko_empty_days", field: func(c *Config) any { return &c.CHEmptyDaysTable }},
+	{env: "CLICKHOUSE_REVISIONS_TABLE", def: "coingecko_revisions", field: func(c *Config) any { return &c.CHRevisionsTable }},
+	{env: "CLICKHOUSE_COINS_TABLE", def: "coingecko_coins", field: func(c *Config) any { return &c.CHCoinsTable }},
+	{env: "CLICKHOUSE_UNIVERSE_TABLE", def: "coins_universe", field: func(c *Config) any { return &c.CHUniverseTable }},
+	{env: "CLICKHOUSE_UNIVERSE_EVENTS_TABLE", def: "coins_universe_events", field: func(c *Config) any { return &c.CHUniverseEventsTable }},
+	{env: "CLICKHOUSE_RUNS_TABLE", def: "etl_runs", field: func(c *Config) any { return &c.CHRunsTable }},
+	{env: "CLICKHOUSE_TASK_RESULTS_TABLE", def: "etl_task_results", field: func(c *Config) any { return &c.CHTaskResultsTable }},
+	{env: "CLICKHOUSE_QUARANTINE_TABLE", def: "coingecko_quarantine", field: func(c *Config) any { return &c.CHQuarantineTable }},
+	{env: "CLICKHOUSE_ANOMALIES_TABLE", def: "coingecko_anomalies", field: func(c *Config) any { return &c.CHAnomaliesTable }},
+	{env: "CLICKHOUSE_MARKET_RANK_TABLE", def: "coingecko_market_cap_rank", field: func(c *Config) any { return &c.CHMarketRankTable }},
+	{env: "CLICKHOUSE_MARKET_TOTALS_TABLE", def: "coingecko_market_totals", field: func(c *Config) any { return &c.CHMarketTotalsTable }},
+	{env: "CLICKHOUSE_WEEKLY_TABLE", def: "coingecko_market_cap_weekly", field: func(c *Config) any { return &c.CHWeeklyTable }},
+	{env: "CLICKHOUSE_MONTHLY_TABLE", def: "coingecko_market_cap_monthly", field: func(c *Config) any { return &c.CHMonthlyTable }},
+	{env: "CLICKHOUSE_INDICATORS_TABLE", def: "coingecko_indicators", field: func(c *Config) any { return &c.CHIndicatorsTable }},
+	{env: "CLICKHOUSE_FX_TABLE", def: "coingecko_fx_rates", field: func(c *Config) any { return &c.CHFXTable }},
+	{env: "CLICKHOUSE_GLOBAL_TABLE", def: "coingecko_global", field: func(c *Config) any { return &c.CHGlobalTable }},
+	{env: "CLICKHOUSE_GLOBAL_DEFI_TABLE", def: "coingecko_global_defi", field: func(c *Config) any { return &c.CHGlobalDefiTable }},
+	{env: "CLICKHOUSE_GLOBAL_HISTORY_TABLE", def: "coingecko_global_history", field: func(c *Config) any { return &c.CHGlobalHistoryTable }},
+	{env: "CLICKHOUSE_CATEGORIES_TABLE", def: "coingecko_categories", field: func(c *Config) any { return &c.CHCategoriesTable }},
+	{env: "CLICKHOUSE_CATEGORY_MARKET_TABLE", def: "coingecko_category_market", field: func(c *Config) any { return &c.CHCategoryMarketTable }},
+	{env: "CLICKHOUSE_CATEGORY_MEMBERSHIP_TABLE", def: "coingecko_coin_categories", field: func(c *Config) any { return &c.CHCategoryMembersTable }},
+	{env: "CLICKHOUSE_CATEGORY_MARKET_CAP_TABLE", def: "coingecko_category_market_cap", field: func(c *Config) any { return &c.CHCategoryCapTable }},
+	{env: "CLICKHOUSE_EXCHANGES_TABLE", def: "coingecko_exchanges", field: func(c *Config) any { return &c.CHExchangesTable }},
+	{env: "CLICKHOUSE_EXCHANGE_VOLUME_TABLE", def: "coingecko_exchange_volume_daily", field: func(c *Config) any { return &c.CHExchangeVolumeTable }},
+	{env: "CLICKHOUSE_TICKERS_TABLE", def: "coingecko_tickers", field: func(c *Config) any { return &c.CHTickersTable }},
+	{env: "CLICKHOUSE_PENDING_DAYS_TABLE", def: "coingecko_pending_days", field: func(c *Config) any { return &c.CHPendingDaysTable }}, // дни, ждущие пересчёта производных таблиц
+
+	{env: "WORKERS", def: "8", field: func(c *Config) any { return &c.Workers }},
+	{env: "START_DATE", def: "2018-01-01", field: func(c *Config) any { return &c.StartDate }},
+	{env: "DISCOVERY_PROBE_DAYS", def: "7", field: func(c *Config) any { return &c.DiscoveryProbeDays }},
+	{env: "DISCOVERY_MAX_STEP_DAYS", def: "365", field: func(c *Config) any { return &c.DiscoveryMaxStepDays }},
+	{env: "DISCOVERY_RECHECK_EVERY", def: "168h", field: func(c *Config) any { return &c.DiscoveryRecheck }}, // 0 - не перепроверять монеты без данных
+	{env: "MAX_RETRIES_PER_BLOCK", def: "3", field: func(c *Config) any { return &c.MaxRetriesPerBlock }},
+	{env: "SYNC_EVERY", def: "6h", field: func(c *Config) any { return &c.SyncEvery }},
+	{env: "HTTP_ADDR", def: ":8080", field: func(c *Config) any { return &c.HTTPAddr }},
+	{env: "GRPC_ADDR", def: ":9090", field: func(c *Config) any { return &c.GRPCAddr }},
+	{env: "HEALTH_STALL_TIMEOUT", def: "15m", field: func(c *Config) any { return &c.StallTimeout }},
+	{env: "REVISION_WINDOW_DAYS", def: "7", field: func(c *Config) any { return &c.RevisionDays }},
+	{env: "GAP_SCAN_EVERY", def: "24h", field: func(c *Config) any { return &c.GapScanEvery }},
+	{env: "COINS_REFRESH_EVERY", def: "24h", field: func(c *Config) any { return &c.CoinsRefreshEvery }},
+	{env: "LOG_LEVEL", def: "info", field: func(c *Config) any { return &c.LogLevel }},
+
+	{env: "TRACE_EXPORTER", def: "none", field: func(c *Config) any { return &c.TraceExporter }}, // none, stdout или otlp (OTEL_EXPORTER_OTLP_ENDPOINT)
+	{env: "TRACE_SAMPLE_RATIO", def: "1", field: func(c *Config) any { return &c.TraceSampleRatio }},
+
+	{env: "CONFIG_WATCH_EVERY", def: "10s", field: func(c *Config) any { return &c.ConfigWatchEvery }},
+
+	{env: "VALIDATION_RULES", def: strings.Join(validationRules, ","), field: func(c *Config) any { return &c.ValidationRules }}, // none отключает проверки
+	{env: "VALIDATION_MAX_JUMP_RATIO", def: "10", field: func(c *Config) any { return &c.ValidationMaxJumpRatio }},
+	{env: "VALIDATION_MAX_SUPPLY_RATIO", def: "2", field: func(c *Config) any { return &c.ValidationMaxSupplyRatio }},
+	{env: "VALIDATION_MAX_VOLUME_RATIO", def: "50", field: func(c *Config) any { return &c.ValidationMaxVolumeRatio }},
+
+	{env: "ANOMALY_SCAN_EVERY", def: "24h", field: func(c *Config) any { return &c.AnomalyScanEvery }},
+	{env: "ANOMALY_WINDOW_DAYS", def: "90", field: func(c *Config) any { return &c.AnomalyWindowDays }},
+	{env: "ANOMALY_ZSCORE", def: "6", field: func(c *Config) any { return &c.AnomalyZScore }},
+	{env: "ANOMALY_FLATLINE_DAYS", def: "5", field: func(c *Config) any { return &c.AnomalyFlatlineDays }},
+	{env: "ANOMALY_RANK_TOP", def: "200", field: func(c *Config) any { return &c.AnomalyRankTop }},
+	{env: "ANOMALY_RANK_JUMP", def: "25", field: func(c *Config) any { return &c.AnomalyRankJump }},
+	{env: "ANOMALY_WEBHOOK_URL", secret: true, field: func(c *Config) any { return &c.AnomalyWebhookURL }}, // пусто - без уведомлений
+
+	{env: "INDICATORS", def: strings.Join(indicatorNames(), ","), field: func(c *Config) any { return &c.Indicators }}, // none отключает расчёт
+
+	{env: "DERIVED_VS_CURRENCIES", field: func(c *Config) any { return &c.DerivedVsCurrencies }}, // считаются из COINGECKO_VS_CURRENCY по курсам
+	{env: "FX_SOURCE", def: fxExchangeRates, field: func(c *Config) any { return &c.FXSource }},  // exchange_rates или btc
+	{env: "FX_MAX_AGE_DAYS", def: "1", field: func(c *Config) any { return &c.FXMaxAgeDays }},
+
+	{env: "GLOBAL_SNAPSHOT_EVERY", def: "1h", field: func(c *Config) any { return &c.GlobalSnapshotEvery }}, // 0 отключает
+
+	{env: "CATEGORY_REFRESH_EVERY", def: "24h", field: func(c *Config) any { return &c.CategoryRefreshEvery }},     // 0 отключает
+	{env: "CATEGORY_MEMBERSHIP_EVERY", def: "0", field: func(c *Config) any { return &c.CategoryMembershipEvery }}, // 0 отключает; запрос /coins/{id} на каждую монету
+
+	{env: "EXCHANGE_SYNC_EVERY", def: "24h", field: func(c *Config) any { return &c.ExchangeSyncEvery }}, // 0 отключает
+	{env: "EXCHANGE_IDS", field: func(c *Config) any { return &c.ExchangeIDs }},                          // пусто - EXCHANGE_TOP по trust score
+	{env: "EXCHANGE_TOP", def: "20", field: func(c *Config) any { return &c.ExchangeTop }},
+	{env: "TICKER_IDS", field: func(c *Config) any { return &c.TickerIDs }}, // монеты, чьи тикеры сохраняются
+}
+
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+func (s setting) key() string {
+	return strings.ToLower(s.env)
+}
 
-	cfg.StartDate = mustParseDate(getenv("START_DATE", "2018-01-01"))
+func (s setting) flagName() string {
+	return strings.ReplaceAll(s.key(), "_", "-")
+}
 
-	cfg.CoinIDsFilter = parseCSVSet(os.Getenv("COINGECKO_IDS"))
+// ConfigError lists every problem found while loading the configuration.
+type ConfigError struct {
+	Problems []string
+}
 
-	return cfg
+func (e *ConfigError) Error() string {
+	return "invalid configuration:\n  - " + strings.Join(e.Problems, "\n  - ")
 }
 
-func getenv(k, def string) string {
-	v := os.Getenv(k)
-	if v == "" {
-		return def
+func (e *ConfigError) addf(format string, args ...any) {
+	e.Problems = append(e.Problems, fmt.Sprintf(format, args...))
+}
+
+// registerConfigFlags adds a flag for every setting to fs. Only flags given
+// on the command line end up in the returned map.
+func registerConfigFlags(fs *flag.FlagSet) map[string]string {
+	set := make(map[string]string)
+	for _, s := range settings {
+		fs.Func(s.flagName(), "overrides "+s.env, func(v string) error {
+			set[s.env] = v
+			return nil
+		})
 	}
-	return v
+	return set
 }
 
-func mustInt(s string) int {
-	n, err := strconv.Atoi(s)
-	if err != nil {
-		panic("bad int: " + s)
+// LoadConfig builds the configuration from defaults, the optional config
+// file (YAML or TOML), env vars and CLI flags, each overriding the previous
+// one, and validates the result.
+func LoadConfig(path string, flags map[string]string) (Config, error) {
+	raw := make(map[string]string, len(settings))
+	sources := make(map[string]string, len(settings))
+	for _, s := range settings {
+		raw[s.env], sources[s.env] = s.def, sourceDefault
+	}
+
+	errs := &ConfigError{}
+
+	if path != "" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			return Config{}, err
+		}
+		known := make(map[string]string, len(settings))
+		for _, s := range settings {
+			known[s.key()] = s.env
+		}
+		for _, k := range sortedKeys(file) {
+			env, ok := known[strings.ToLower(k)]
+			if !ok {
+				errs.addf("%s: unknown key %q", path, k)
+				continue
+			}
+			raw[env], sources[env] = file[k], sourceFile
+		}
+	}
+
+	for _, s := range settings {
+		if v := os.Getenv(s.env); v != "" {
+			raw[s.env], sources[s.env] = v, sourceEnv
+		}
+		if v, ok := flags[s.env]; ok {
+			raw[s.env], sources[s.env] = v, sourceFlag
+		}
+	}
+
+	cfg := Config{sources: sources, path: path, flags: flags}
+	unparsed := make(map[string]bool)
+	for _, s := range settings {
+		if err := parseSetting(s.field(&cfg), raw[s.env]); err != nil {
+			errs.addf("%s (%s): %v", s.env, sources[s.env], err)
+			unparsed[s.env] = true
+		}
+	}
+
+	// Rules about a value that failed to parse would only repeat the error.
+	invalid := &ConfigError{}
+	validateConfig(cfg, invalid)
+	for _, p := range invalid.Problems {
+		env, _, _ := strings.Cut(p, ":")
+		if !unparsed[env] {
+			errs.Problems = append(errs.Problems, p)
+		}
+	}
+	if len(errs.Problems) > 0 {
+		return cfg, errs
 	}
-	return n
+	return cfg, nil
 }
 
-func mustFloat(s string) float64 {
-	f, err := strconv.ParseFloat(s, 64)
+// readConfigFile reads a flat key/value file; nested tables are not used.
+func readConfigFile(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		panic("bad float: " + s)
+		return nil, fmt.Errorf("config file: %w", err)
 	}
-	return f
-}
 
-func mustDuration(s string) time.Duration {
-	d, err := time.ParseDuration(s)
+	var doc map[string]any
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &doc)
+	case ".toml":
+		err = toml.Unmarshal(b, &doc)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension %q (want .yaml, .yml or .toml)", path, ext)
+	}
 	if err != nil {
-		panic("bad duration: " + s)
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	out := make(map[string]string, len(doc))
+	for k, v := range doc {
+		out[k] = fileValue(v)
+	}
+	return out, nil
+}
+
+func fileValue(v any) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case time.Time:
+		return formatDate(x)
+	case []any:
+		parts := make([]string, len(x))
+		for i, e := range x {
+			parts[i] = fileValue(e)
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func parseSetting(field any, v string) error {
+	v = strings.TrimSpace(v)
+	switch p := field.(type) {
+	case *string:
+		*p = v
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("not an integer: %q", v)
+		}
+		*p = n
+	case *float64:
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("not a number: %q", v)
+		}
+		*p = f
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("not a duration: %q", v)
+		}
+		*p = d
+	case *time.Time:
+		t, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			return fmt.Errorf("not a date (YYYY-MM-DD): %q", v)
+		}
+		*p = t.UTC()
+	case *map[string]bool:
+		*p = parseCSVSet(v)
+	default:
+		return fmt.Errorf("unsupported setting type %T", field)
+	}
+	return nil
+}
+
+func formatSetting(field any) string {
+	switch p := field.(type) {
+	case *string:
+		return *p
+	case *int:
+		return strconv.Itoa(*p)
+	case *float64:
+		return strconv.FormatFloat(*p, 'g', -1, 64)
+	case *time.Duration:
+		return p.String()
+	case *time.Time:
+		return formatDate(*p)
+	case *map[string]bool:
+		return strings.Join(sortedKeys(*p), ",")
+	default:
+		return fmt.Sprint(field)
+	}
+}
+
+// vsCurrencies are the quote currencies CoinGecko accepts as vs_currency.
+var vsCurrencies = parseCSVSet("btc,eth,ltc,bch,bnb,eos,xrp,xlm,link,dot,yfi,sol,usd,aed,ars,aud,bdt,bhd,bmd,brl,cad,chf,clp,cny,czk,dkk,eur,gbp,gel,hkd,huf,idr,ils,inr,jpy,krw,kwd,lkr,mmk,mxn,myr,ngn,nok,nzd,php,pkr,pln,rub,sar,sek,sgd,thb,try,twd,uah,vef,vnd,zar,xdr,xag,xau,bits,sats")
+
+var identRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+func validateConfig(cfg Config, errs *ConfigError) {
+	if u, err := url.Parse(cfg.CGBaseURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.addf("COINGECKO_BASE_URL: must be an http(s) URL, got %q", cfg.CGBaseURL)
+	}
+	if !vsCurrencies[strings.ToLower(cfg.VsCurrency)] {
+		errs.addf("COINGECKO_VS_CURRENCY: unsupported currency %q", cfg.VsCurrency)
+	}
+	switch cfg.Interval {
+	case "", "daily":
+	default:
+		errs.addf("COINGECKO_INTERVAL: must be empty or daily, got %q", cfg.Interval)
+	}
+	if cfg.RequestTimeout <= 0 {
+		errs.addf("COINGECKO_TIMEOUT: must be > 0")
+	}
+	if cfg.CGRPS <= 0 {
+		errs.addf("COINGECKO_RPS: must be > 0, got %v", cfg.CGRPS)
+	}
+	if cfg.CGBurst < 1 {
+		errs.addf("COINGECKO_BURST: must be at least 1, got %d", cfg.CGBurst)
+	}
+	if cfg.CGBreakerFailures < 0 {
+		errs.addf("COINGECKO_BREAKER_FAILURES: must be >= 0 (0 disables the breaker)")
+	}
+	if cfg.CGBreakerCooldown < 0 {
+		errs.addf("COINGECKO_BREAKER_COOLDOWN: must be >= 0")
+	}
+
+	if cfg.CHHost == "" {
+		errs.addf("CLICKHOUSE_HOST: must not be empty")
+	}
+	if p, err := strconv.Atoi(cfg.CHPort); err != nil || p < 1 || p > 65535 {
+		errs.addf("CLICKHOUSE_PORT: must be a port number, got %q", cfg.CHPort)
+	}
+	for _, s := range settings {
+		isName := strings.HasPrefix(s.env, "CLICKHOUSE_") && (strings.HasSuffix(s.env, "_TABLE") || s.env == "CLICKHOUSE_DATABASE")
+		if v := formatSetting(s.field(&cfg)); isName && !identRe.MatchString(v) {
+			errs.addf("%s: not a valid identifier: %q", s.env, v)
+		}
+	}
+
+	if len(cfg.CategoryFilter) > 0 && cfg.CoinIDsFilter != nil {
+		errs.addf("COINGECKO_CATEGORIES: can't be combined with COINGECKO_IDS")
+	}
+	if cfg.Workers < 1 {
+		errs.addf("WORKERS: must be at least 1, got %d", cfg.Workers)
+	}
+	if !cfg.StartDate.Before(yesterdayUTC()) {
+		errs.addf("START_DATE: must be before yesterday (%s), got %s", formatDate(yesterdayUTC()), formatDate(cfg.StartDate))
+	}
+	if cfg.DiscoveryProbeDays < 1 {
+		errs.addf("DISCOVERY_PROBE_DAYS: must be at least 1")
+	}
+	if cfg.DiscoveryMaxStepDays < cfg.DiscoveryProbeDays {
+		errs.addf("DISCOVERY_MAX_STEP_DAYS: must be >= DISCOVERY_PROBE_DAYS")
+	}
+	if cfg.MaxRetriesPerBlock < 0 {
+		errs.addf("MAX_RETRIES_PER_BLOCK: must be >= 0")
+	}
+	if cfg.SyncEvery <= 0 {
+		errs.addf("SYNC_EVERY: must be > 0")
+	}
+	if cfg.RevisionDays < 0 {
+		errs.addf("REVISION_WINDOW_DAYS: must be >= 0")
+	}
+	for _, d := range []struct {
+		env string
+		v   time.Duration
+	}{
+		{"HEALTH_STALL_TIMEOUT", cfg.StallTimeout},
+		{"DISCOVERY_RECHECK_EVERY", cfg.DiscoveryRecheck},
+		{"GAP_SCAN_EVERY", cfg.GapScanEvery},
+		{"GLOBAL_SNAPSHOT_EVERY", cfg.GlobalSnapshotEvery},
+		{"CATEGORY_REFRESH_EVERY", cfg.CategoryRefreshEvery},
+		{"CATEGORY_MEMBERSHIP_EVERY", cfg.CategoryMembershipEvery},
+		{"EXCHANGE_SYNC_EVERY", cfg.ExchangeSyncEvery},
+		{"COINS_REFRESH_EVERY", cfg.CoinsRefreshEvery},
+		{"ANOMALY_SCAN_EVERY", cfg.AnomalyScanEvery},
+	} {
+		if d.v < 0 {
+			errs.addf("%s: must be >= 0 (0 disables it)", d.env)
+		}
+	}
+	if _, err := log.ParseLevel(cfg.LogLevel); err != nil {
+		errs.addf("LOG_LEVEL: %v", err)
+	}
+
+	switch strings.ToLower(cfg.TraceExporter) {
+	case "", "none", "stdout", "otlp":
+	default:
+		errs.addf("TRACE_EXPORTER: must be none, stdout or otlp, got %q", cfg.TraceExporter)
+	}
+	if cfg.ConfigWatchEvery < 0 {
+		errs.addf("CONFIG_WATCH_EVERY: must be >= 0 (0 disables watching the file)")
+	}
+	if cfg.TraceSampleRatio < 0 || cfg.TraceSampleRatio > 1 {
+		errs.addf("TRACE_SAMPLE_RATIO: must be within [0, 1], got %v", cfg.TraceSampleRatio)
+	}
+
+	known := parseCSVSet(strings.Join(validationRules, ",") + ",none")
+	for _, r := range sortedKeys(cfg.ValidationRules) {
+		if !known[r] {
+			errs.addf("VALIDATION_RULES: unknown rule %q (want none or any of %s)", r, strings.Join(validationRules, ", "))
+		}
+	}
+	if cfg.ValidationRules["none"] && len(cfg.ValidationRules) > 1 {
+		errs.addf("VALIDATION_RULES: none can't be combined with other rules")
+	}
+	for _, r := range []struct {
+		env string
+		v   float64
+	}{
+		{"VALIDATION_MAX_JUMP_RATIO", cfg.ValidationMaxJumpRatio},
+		{"VALIDATION_MAX_SUPPLY_RATIO", cfg.ValidationMaxSupplyRatio},
+		{"VALIDATION_MAX_VOLUME_RATIO", cfg.ValidationMaxVolumeRatio},
+	} {
+		if r.v <= 1 {
+			errs.addf("%s: must be > 1, got %v", r.env, r.v)
+		}
+	}
+
+	if cfg.AnomalyWindowDays < anomalyMinReturns+1 {
+		errs.addf("ANOMALY_WINDOW_DAYS: must be at least %d, got %d", anomalyMinReturns+1, cfg.AnomalyWindowDays)
+	}
+	if cfg.AnomalyZScore <= 0 {
+		errs.addf("ANOMALY_ZSCORE: must be > 0, got %v", cfg.AnomalyZScore)
+	}
+	if cfg.AnomalyFlatlineDays < 2 {
+		errs.addf("ANOMALY_FLATLINE_DAYS: must be at least 2, got %d", cfg.AnomalyFlatlineDays)
+	}
+	if cfg.AnomalyRankTop < 1 {
+		errs.addf("ANOMALY_RANK_TOP: must be at least 1, got %d", cfg.AnomalyRankTop)
+	}
+	if cfg.AnomalyRankJump < 1 {
+		errs.addf("ANOMALY_RANK_JUMP: must be at least 1, got %d", cfg.AnomalyRankJump)
+	}
+	if cfg.AnomalyWebhookURL != "" {
+		if u, err := url.Parse(cfg.AnomalyWebhookURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.addf("ANOMALY_WEBHOOK_URL: must be an http(s) URL")
+		}
+	}
+
+	known = parseCSVSet(strings.Join(indicatorNames(), ",") + ",none")
+	for _, ind := range sortedKeys(cfg.Indicators) {
+		if !known[ind] {
+			errs.addf("INDICATORS: unknown indicator %q (want none or any of %s)", ind, strings.Join(indicatorNames(), ", "))
+		}
+	}
+	if cfg.Indicators["none"] && len(cfg.Indicators) > 1 {
+		errs.addf("INDICATORS: none can't be combined with other indicators")
+	}
+
+	for _, vs := range sortedKeys(cfg.DerivedVsCurrencies) {
+		switch {
+		case !vsCurrencies[vs]:
+			errs.addf("DERIVED_VS_CURRENCIES: unsupported currency %q", vs)
+		case vs == strings.ToLower(cfg.VsCurrency):
+			errs.addf("DERIVED_VS_CURRENCIES: %q is COINGECKO_VS_CURRENCY, which is fetched", vs)
+		}
+	}
+	if cfg.FXSource != fxExchangeRates && cfg.FXSource != fxBTC {
+		errs.addf("FX_SOURCE: must be %s or %s, got %q", fxExchangeRates, fxBTC, cfg.FXSource)
+	}
+	if cfg.FXMaxAgeDays < 0 {
+		errs.addf("FX_MAX_AGE_DAYS: must be >= 0, got %d", cfg.FXMaxAgeDays)
+	}
+	if cfg.ExchangeTop < 0 {
+		errs.addf("EXCHANGE_TOP: must be >= 0, got %d", cfg.ExchangeTop)
+	}
+}
+
+// ConfigEntry is one line of `config print`.
+type ConfigEntry struct {
+	Key    string
+	Value  string
+	Source string
+}
+
+// Entries returns the effective settings in declaration order, with secrets
+// masked.
+func (c Config) Entries() []ConfigEntry {
+	out := make([]ConfigEntry, 0, len(settings))
+	for _, s := range settings {
+		v := formatSetting(s.field(&c))
+		if s.secret && v != "" {
+			v = "********"
+		}
+		src := c.sources[s.env]
+		if src == "" {
+			src = sourceDefault
+		}
+		out = append(out, ConfigEntry{Key: s.key(), Value: v, Source: src})
 	}
-	return d
+	return out
 }
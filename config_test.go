@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// clearConfigEnv unsets every setting for the test; LoadConfig ignores
+// empty variables.
+func clearConfigEnv(t *testing.T) {
+	t.Helper()
+	for _, s := range settings {
+		t.Setenv(s.env, "")
+	}
+}
+
+func writeConfigFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name       string
+		file       string
+		env        string
+		flag       string
+		want       int
+		wantSource string
+	}{
+		{name: "default", want: 8, wantSource: sourceDefault},
+		{name: "file", file: "3", want: 3, wantSource: sourceFile},
+		{name: "env over file", file: "3", env: "4", want: 4, wantSource: sourceEnv},
+		{name: "flag over env", file: "3", env: "4", flag: "5", want: 5, wantSource: sourceFlag},
+		{name: "flag over file", file: "3", flag: "5", want: 5, wantSource: sourceFlag},
+	}
+	for _, tt := range tests {
+		for _, ext := range []string{".yaml", ".toml"} {
+			t.Run(tt.name+ext, func(t *testing.T) {
+				clearConfigEnv(t)
+				var path string
+				if tt.file != "" {
+					content := "workers: " + tt.file + "\n"
+					if ext == ".toml" {
+						content = "workers = " + tt.file + "\n"
+					}
+					path = writeConfigFile(t, "config"+ext, content)
+				}
+				if tt.env != "" {
+					t.Setenv("WORKERS", tt.env)
+				}
+				flags := map[string]string{}
+				if tt.flag != "" {
+					flags["WORKERS"] = tt.flag
+				}
+
+				cfg, err := LoadConfig(path, flags)
+				if err != nil {
+					t.Fatalf("LoadConfig: %v", err)
+				}
+				if cfg.Workers != tt.want {
+					t.Fatalf("Workers = %d, want %d", cfg.Workers, tt.want)
+				}
+				for _, e := range cfg.Entries() {
+					if e.Key == "workers" && e.Source != tt.wantSource {
+						t.Fatalf("workers source = %s, want %s", e.Source, tt.wantSource)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestLoadConfigValidation(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     string // yaml
+		env      map[string]string
+		flags    map[string]string
+		problems []string // prefixes, in order
+	}{
+		{
+			name: "defaults",
+		},
+		{
+			name:     "unknown file key",
+			file:     "workerz: 3\n",
+			problems: []string{"config.yaml: unknown key \"workerz\""},
+		},
+		{
+			name:     "unparsable value names its source",
+			env:      map[string]string{"WORKERS": "many"},
+			problems: []string{"WORKERS (env): "},
+		},
+		{
+			name:     "unparsable value is not validated again",
+			flags:    map[string]string{"COINGECKO_RPS": "fast"},
+			problems: []string{"COINGECKO_RPS (flag): "},
+		},
+		{
+			name:     "invalid value",
+			flags:    map[string]string{"COINGECKO_RPS": "0"},
+			problems: []string{"COINGECKO_RPS: must be > 0"},
+		},
+		{
+			name: "every problem is reported",
+			file: "clickhouse_table: bad-name\n",
+			env:  map[string]string{"COINGECKO_VS_CURRENCY": "xyz"},
+			problems: []string{
+				"COINGECKO_VS_CURRENCY: unsupported currency",
+				"CLICKHOUSE_TABLE: not a valid identifier",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearConfigEnv(t)
+			var path string
+			if tt.file != "" {
+				path = writeConfigFile(t, "config.yaml", tt.file)
+			}
+			for k, v := range tt.env {
+				t.Setenv(k, v)
+			}
+
+			_, err := LoadConfig(path, tt.flags)
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Fatalf("LoadConfig: %v", err)
+				}
+				return
+			}
+			var cerr *ConfigError
+			if !errors.As(err, &cerr) {
+				t.Fatalf("err = %v, want a ConfigError", err)
+			}
+			if len(cerr.Problems) != len(tt.problems) {
+				t.Fatalf("problems = %q, want %d", cerr.Problems, len(tt.problems))
+			}
+			for i, p := range cerr.Problems {
+				// The file name is part of the message; compare from it on.
+				if j := strings.Index(p, "config.yaml"); j > 0 {
+					p = p[j:]
+				}
+				if !strings.HasPrefix(p, tt.problems[i]) {
+					t.Errorf("problem %d = %q, want prefix %q", i, p, tt.problems[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileErrors(t *testing.T) {
+	clearConfigEnv(t)
+	for _, tt := range []struct {
+		name, file, content string
+	}{
+		{"unsupported extension", "config.json", `{"workers": 3}`},
+		{"malformed", "config.yaml", "workers: [3\n"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConfig(writeConfigFile(t, tt.file, tt.content), nil)
+			var cerr *ConfigError
+			if err == nil || errors.As(err, &cerr) {
+				t.Fatalf("err = %v, want a config file error", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// CoinBounds is the discovered range of days for which CoinGecko has data for a coin.
+type CoinBounds struct {
+	ID      string
+	Symbol  string
+	HasData bool
+	First   time.Time
+	Last    time.Time
+	Probes  int
+
+	// CheckedAt is when the bounds were last probed.
+	CheckedAt time.Time
+}
+
+type searchStage int
+
+const (
+	stageAnchor searchStage = iota
+	stageLast
+	stageFirst
+	stageDone
+)
+
+// boundsSearch finds the first and last day with data for one coin.
+//
+// Probes are narrow windows of probeDays. The anchor stage walks back from
+// yesterday with exponentially growing gaps (capped at maxStep) until a probe
+// hits data. The last and first trading days are then located by binary search
+// between a known data day and a known empty day. Data is assumed contiguous
+// between the two bounds; holes inside are repaired by later fill tasks.
+type boundsSearch struct {
+	coin       Coin
+	startLimit time.Time
+	probeDays  int
+	maxStep    int
+
+	stage searchStage
+	gap   int
+
+	cursor     time.Time
+	newerEmpty time.Time
+
+	lastData  time.Time
+	lastEmpty time.Time
+
+	firstData  time.Time
+	firstEmpty time.Time
+
+	bounds CoinBounds
+}
+
+func newBoundsSearch(c Coin, startLimit, yday time.Time, probeDays, maxStep int) *boundsSearch {
+	if probeDays < 1 {
+		probeDays = 1
+	}
+	if maxStep < probeDays {
+		maxStep = probeDays
+	}
+	return &boundsSearch{
+		coin:       c,
+		startLimit: startLimit,
+		probeDays:  probeDays,
+		maxStep:    maxStep,
+		stage:      stageAnchor,
+		cursor:     yday,
+		bounds:     CoinBounds{ID: c.ID, Symbol: c.Symbol},
+	}
+}
+
+// newTailSearch re-probes only the last day of known bounds, by binary
+// search between the known last day and yday.
+func newTailSearch(b CoinBounds, startLimit, yday time.Time, probeDays, maxStep int) *boundsSearch {
+	s := newBoundsSearch(Coin{ID: b.ID, Symbol: b.Symbol}, startLimit, yday, probeDays, maxStep)
+	s.stage = stageLast
+	s.lastData = b.Last
+	s.lastEmpty = yday.AddDate(0, 0, 1)
+	s.bounds = b
+	s.bounds.Last = time.Time{}
+	s.resolve()
+	return s
+}
+
+// boundsReusable reports whether stored bounds can be used without probing:
+// bounds reaching the last days always, the others until they are
+// DISCOVERY_RECHECK_EVERY old.
+func boundsReusable(cfg Config, b CoinBounds, yday time.Time) bool {
+	if b.HasData && !b.Last.Before(yday.AddDate(0, 0, -2)) {
+		return true
+	}
+	return cfg.DiscoveryRecheck == 0 || time.Since(b.CheckedAt) < cfg.DiscoveryRecheck
+}
+
+func (s *boundsSearch) nextGap() int {
+	g := s.gap
+	switch {
+	case s.gap == 0:
+		s.gap = s.probeDays
+	case s.gap*2 > s.maxStep:
+		s.gap = s.maxStep
+	default:
+		s.gap *= 2
+	}
+	return g
+}
+
+// olderEnd returns the end of the next probe window older than day,
+// never starting the window before startLimit.
+func (s *boundsSearch) olderEnd(day time.Time) time.Time {
+	end := day.AddDate(0, 0, -1-s.nextGap())
+	if end.AddDate(0, 0, -(s.probeDays - 1)).Before(s.startLimit) {
+		end = s.startLimit.AddDate(0, 0, s.probeDays-1)
+		if !end.Before(day) {
+			end = day.AddDate(0, 0, -1)
+		}
+	}
+	return end
+}
+
+func (s *boundsSearch) task() (Task, bool) {
+	var from, to time.Time
+
+	switch s.stage {
+	case stageAnchor:
+		to = s.cursor
+		from = to.AddDate(0, 0, -(s.probeDays - 1))
+
+	case stageLast:
+		to = s.lastData.AddDate(0, 0, daysBetween(s.lastData, s.lastEmpty)/2)
+		from = to.AddDate(0, 0, -(s.probeDays - 1))
+		if !from.After(s.lastData) {
+			from = s.lastData.AddDate(0, 0, 1)
+		}
+
+	case stageFirst:
+		if s.firstEmpty.IsZero() {
+			to = s.olderEnd(s.firstData)
+			from = to.AddDate(0, 0, -(s.probeDays - 1))
+		} else {
+			from = s.firstEmpty.AddDate(0, 0, daysBetween(s.firstEmpty, s.firstData)/2)
+			to = from.AddDate(0, 0, s.probeDays-1)
+			if !to.Before(s.firstData) {
+				to = s.firstData.AddDate(0, 0, -1)
+			}
+		}
+
+	default:
+		return Task{}, false
+	}
+
+	if from.Before(s.startLimit) {
+		from = s.startLimit
+	}
+
+	return Task{
+		CoinID: s.coin.ID,
+		Symbol: s.coin.Symbol,
+		From:   from,
+		To:     to,
+		Phase:  PhaseDiscovery,
+	}, true
+}
+
+func (s *boundsSearch) observe(res TaskResult) {
+	s.bounds.Probes++
+	t := res.Task
+	hasData := res.APIDays > 0
+	atStart := !t.From.After(s.startLimit)
+
+	switch s.stage {
+	case stageAnchor:
+		if !hasData {
+			if atStart {
+				s.stage = stageDone
+				return
+			}
+			s.newerEmpty = t.From
+			s.cursor = s.olderEnd(t.From)
+			return
+		}
+
+		s.bounds.HasData = true
+
+		if s.newerEmpty.IsZero() || res.DataTo.Before(t.To) {
+			s.bounds.Last = res.DataTo
+		} else {
+			s.lastData = t.To
+			s.lastEmpty = s.newerEmpty
+		}
+
+		if res.DataFrom.After(t.From) || atStart {
+			s.bounds.First = res.DataFrom
+		} else {
+			s.firstData = t.From
+			s.gap = 0
+		}
+
+	case stageLast:
+		switch {
+		case !hasData:
+			s.lastEmpty = t.From
+		case res.DataTo.Before(t.To):
+			s.bounds.Last = res.DataTo
+		default:
+			s.lastData = t.To
+		}
+
+	case stageFirst:
+		switch {
+		case !hasData:
+			s.firstEmpty = t.To
+		case res.DataFrom.After(t.From) || atStart:
+			s.bounds.First = res.DataFrom
+		default:
+			s.firstData = t.From
+		}
+	}
+
+	s.resolve()
+}
+
+// resolve closes binary searches whose known-data and known-empty days have
+// become adjacent and picks the stage for the next probe.
+func (s *boundsSearch) resolve() {
+	if s.bounds.Last.IsZero() && daysBetween(s.lastData, s.lastEmpty) <= 1 {
+		s.bounds.Last = s.lastData
+	}
+	if s.bounds.First.IsZero() && !s.firstEmpty.IsZero() && daysBetween(s.firstEmpty, s.firstData) <= 1 {
+		s.bounds.First = s.firstData
+	}
+
+	switch {
+	case s.bounds.Last.IsZero():
+		s.stage = stageLast
+	case s.bounds.First.IsZero():
+		s.stage = stageFirst
+	default:
+		s.stage = stageDone
+	}
+}
+
+func RunDiscovery(ctx context.Context, cfg Config, db *sql.DB, coins []Coin, tasks chan<- Task, results <-chan TaskResult) (map[string]CoinBounds, error) {
+	status.SetPhase(string(PhaseDiscovery))
+	yday := yesterdayUTC()
+
+	known, err := getCoinBounds(ctx, db, cfg.CHBoundsTable)
+	if err != nil {
+		return nil, err
+	}
+	// Incremental sync moves the last day past the probed one.
+	stored, err := getDateRanges(ctx, db, cfg.CHTable, cfg.VsCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make(map[string]CoinBounds, len(coins))
+	searches := make(map[string]*boundsSearch)
+	pending := make([]Task, 0)
+
+	for _, c := range coins {
+		id := strings.TrimSpace(c.ID)
+		sym := strings.ToUpper(strings.TrimSpace(c.Symbol))
+		if id == "" || sym == "" {
+			continue
+		}
+		if cfg.CoinIDsFilter != nil && !cfg.CoinIDsFilter[id] {
+			continue
+		}
+		b, ok := known[id]
+		if r, has := stored[id]; ok && b.HasData && has && r.Max.After(b.Last) {
+			b.Last = r.Max
+		}
+		if ok && boundsReusable(cfg, b, yday) {
+			out[id] = b
+			continue
+		}
+
+		var s *boundsSearch
+		if ok && b.HasData {
+			s = newTailSearch(b, cfg.StartDate, yday, cfg.DiscoveryProbeDays, cfg.DiscoveryMaxStepDays)
+		} else {
+			s = newBoundsSearch(Coin{ID: id, Symbol: sym, Name: c.Name}, cfg.StartDate, yday, cfg.DiscoveryProbeDays, cfg.DiscoveryMaxStepDays)
+		}
+		t, ok := s.task()
+		if !ok {
+			out[id] = s.bounds
+			continue
+		}
+		searches[id] = s
+		pending = append(pending, t)
+		status.SetCoinState(id, CoinSearching)
+	}
+
+	if len(searches) == 0 {
+		log.WithField("known", len(out)).Info("discovery: all coin bounds already known")
+		return out, nil
+	}
+
+	log.WithFields(log.Fields{
+		"coins":      len(searches),
+		"known":      len(out),
+		"probe_days": cfg.DiscoveryProbeDays,
+		"max_step":   cfg.DiscoveryMaxStepDays,
+	}).Info("discovery started")
+
+	const flushEvery = 500
+
+	var (
+		batch    []CoinBounds
+		probes   = 0
+		failed   = 0
+		skipped  = 0
+		resolved = 0
+		noData   = 0
+	)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := insertCoinBounds(ctx, db, cfg.CHBoundsTable, batch); err != nil {
+			log.Warnf("discovery: save bounds failed: %v", err)
+		}
+		batch = batch[:0]
+	}
+
+	run := startRun(ctx, cfg, db, string(PhaseDiscovery))
+	err = runTasks(ctx, pending, tasks, results, func(res TaskResult) []Task {
+		run.record(ctx, res)
+		probes++
+		s := searches[res.Task.CoinID]
+		if s == nil {
+			return nil
+		}
+
+		if res.Err != "" {
+			failed++
+			if res.Task.Retry < cfg.MaxRetriesPerBlock {
+				rt := res.Task
+				rt.Retry++
+				return []Task{rt}
+			}
+			log.WithFields(log.Fields{
+				"id":   res.Task.CoinID,
+				"from": formatDate(res.Task.From),
+				"to":   formatDate(res.Task.To),
+			}).Warnf("discovery probe failed; coin skipped: %s", res.Err)
+			delete(searches, res.Task.CoinID)
+			status.SetCoinState(res.Task.CoinID, CoinFailed)
+			skipped++
+			return nil
+		}
+
+		s.observe(res)
+		if t, ok := s.task(); ok {
+			return []Task{t}
+		}
+
+		b := s.bounds
+		out[b.ID] = b
+		batch = append(batch, b)
+		resolved++
+		if b.HasData {
+			status.SetCoinState(b.ID, CoinDiscovered)
+		} else {
+			noData++
+			status.SetCoinState(b.ID, CoinNoData)
+		}
+		delete(searches, b.ID)
+
+		log.WithFields(log.Fields{
+			"id":       b.ID,
+			"symbol":   b.Symbol,
+			"has_data": b.HasData,
+			"first":    formatDate(b.First),
+			"last":     formatDate(b.Last),
+			"probes":   b.Probes,
+		}).Debug("coin bounds discovered")
+
+		if len(batch) >= flushEvery {
+			flush()
+		}
+		if resolved%progressEvery == 0 {
+			log.WithFields(log.Fields{
+				"resolved":  resolved,
+				"searching": len(searches),
+				"probes":    probes,
+				"errors":    failed,
+			}).Info("discovery progress")
+		}
+		return nil
+	})
+	run.finish(ctx, err)
+	flush()
+	if err != nil {
+		return out, err
+	}
+
+	log.WithFields(log.Fields{
+		"resolved": resolved,
+		"no_data":  noData,
+		"probes":   probes,
+		"errors":   failed,
+		"skipped":  skipped,
+	}).Info("discovery finished")
+
+	if skipped > 0 {
+		return out, fmt.Errorf("discovery: %w: %d coins skipped", errTasksFailed, skipped)
+	}
+	return out, nil
+}
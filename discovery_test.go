@@ -0,0 +1,162 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// probeResult answers a discovery probe as CoinGecko would for a coin with
+// data on every day of [first, last]; hasData false means no data at all.
+func probeResult(t Task, hasData bool, first, last time.Time) TaskResult {
+	res := TaskResult{Task: t, Empty: true}
+	if !hasData {
+		return res
+	}
+	from, to := t.From, t.To
+	if from.Before(first) {
+		from = first
+	}
+	if to.After(last) {
+		to = last
+	}
+	if to.Before(from) {
+		return res
+	}
+	res.Empty = false
+	res.APIDays = daysBetween(from, to) + 1
+	res.DataFrom, res.DataTo = from, to
+	return res
+}
+
+// runSearch drives s against a coin with data on [first, last] and fails
+// when it doesn't finish within limit probes.
+func runSearch(t *testing.T, s *boundsSearch, hasData bool, first, last time.Time, limit int) CoinBounds {
+	t.Helper()
+	for i := 0; i < limit; i++ {
+		task, ok := s.task()
+		if !ok {
+			return s.bounds
+		}
+		if task.To.Before(task.From) {
+			t.Fatalf("probe %d: empty window %s..%s", i, formatDate(task.From), formatDate(task.To))
+		}
+		if task.From.Before(s.startLimit) {
+			t.Fatalf("probe %d: window %s starts before the start limit", i, formatDate(task.From))
+		}
+		s.observe(probeResult(task, hasData, first, last))
+	}
+	t.Fatalf("search not finished after %d probes", limit)
+	return CoinBounds{}
+}
+
+func TestBoundsSearch(t *testing.T) {
+	start := mustParseDate("2018-01-01")
+	yday := mustParseDate("2024-06-30")
+
+	tests := []struct {
+		name        string
+		hasData     bool
+		first, last string
+		probeDays   int
+		maxStep     int
+	}{
+		{name: "trading until yesterday", hasData: true, first: "2020-03-15", last: "2024-06-30", probeDays: 7, maxStep: 365},
+		{name: "delisted", hasData: true, first: "2019-05-02", last: "2022-11-09", probeDays: 7, maxStep: 365},
+		{name: "data from start limit", hasData: true, first: "2018-01-01", last: "2024-06-30", probeDays: 7, maxStep: 365},
+		{name: "listed before start limit", hasData: true, first: "2015-08-07", last: "2023-01-01", probeDays: 7, maxStep: 365},
+		// Probes skip up to maxStep days, so only dense probing is sure to
+		// hit a coin that traded for a single day.
+		{name: "single day", hasData: true, first: "2024-06-03", last: "2024-06-03", probeDays: 1, maxStep: 1},
+		{name: "last day just before probe", hasData: true, first: "2024-01-01", last: "2024-06-23", probeDays: 7, maxStep: 365},
+		{name: "one day probes", hasData: true, first: "2022-07-19", last: "2023-12-31", probeDays: 1, maxStep: 64},
+		{name: "wide probes", hasData: true, first: "2018-02-10", last: "2018-03-01", probeDays: 30, maxStep: 30},
+		{name: "no data", hasData: false, probeDays: 7, maxStep: 365},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var first, last time.Time
+			if tt.hasData {
+				first, last = mustParseDate(tt.first), mustParseDate(tt.last)
+			}
+			s := newBoundsSearch(Coin{ID: "x", Symbol: "X"}, start, yday, tt.probeDays, tt.maxStep)
+			b := runSearch(t, s, tt.hasData, first, last, 200)
+
+			if b.HasData != tt.hasData {
+				t.Fatalf("HasData = %v, want %v", b.HasData, tt.hasData)
+			}
+			if !tt.hasData {
+				return
+			}
+			wantFirst := first
+			if wantFirst.Before(start) {
+				wantFirst = start
+			}
+			if !b.First.Equal(wantFirst) || !b.Last.Equal(last) {
+				t.Fatalf("bounds = %s..%s, want %s..%s", formatDate(b.First), formatDate(b.Last), formatDate(wantFirst), formatDate(last))
+			}
+			if b.Probes == 0 {
+				t.Fatal("Probes not counted")
+			}
+		})
+	}
+}
+
+func TestTailSearch(t *testing.T) {
+	start := mustParseDate("2018-01-01")
+	yday := mustParseDate("2024-06-30")
+	first := mustParseDate("2020-01-01")
+
+	tests := []struct {
+		name      string
+		known     string
+		last      string
+		maxProbes int
+	}{
+		{name: "still trading", known: "2024-05-01", last: "2024-06-30", maxProbes: 8},
+		{name: "delisted since", known: "2024-05-01", last: "2024-05-20", maxProbes: 8},
+		{name: "no new data", known: "2024-05-01", last: "2024-05-01", maxProbes: 8},
+		{name: "already current", known: "2024-06-30", last: "2024-06-30", maxProbes: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			known := CoinBounds{ID: "x", Symbol: "X", HasData: true, First: first, Last: mustParseDate(tt.known), Probes: 5}
+			s := newTailSearch(known, start, yday, 7, 365)
+			b := runSearch(t, s, true, first, mustParseDate(tt.last), 50)
+
+			if !b.First.Equal(first) || !b.Last.Equal(mustParseDate(tt.last)) {
+				t.Fatalf("bounds = %s..%s, want %s..%s", formatDate(b.First), formatDate(b.Last), formatDate(first), tt.last)
+			}
+			if probes := b.Probes - known.Probes; probes > tt.maxProbes {
+				t.Fatalf("%d probes, want at most %d", probes, tt.maxProbes)
+			}
+		})
+	}
+}
+
+func TestBoundsReusable(t *testing.T) {
+	yday := mustParseDate("2024-06-30")
+	cfg := Config{DiscoveryRecheck: 24 * time.Hour}
+	recent := time.Now().Add(-time.Hour)
+	old := time.Now().Add(-48 * time.Hour)
+
+	tests := []struct {
+		name    string
+		cfg     Config
+		b       CoinBounds
+		reusing bool
+	}{
+		{"current bounds", cfg, CoinBounds{HasData: true, Last: yday.AddDate(0, 0, -2), CheckedAt: old}, true},
+		{"stale last, recently checked", cfg, CoinBounds{HasData: true, Last: yday.AddDate(0, 0, -3), CheckedAt: recent}, true},
+		{"stale last, due", cfg, CoinBounds{HasData: true, Last: yday.AddDate(0, 0, -3), CheckedAt: old}, false},
+		{"no data, recently checked", cfg, CoinBounds{CheckedAt: recent}, true},
+		{"no data, due", cfg, CoinBounds{CheckedAt: old}, false},
+		{"re-checks disabled", Config{}, CoinBounds{CheckedAt: old}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := boundsReusable(tt.cfg, tt.b, yday); got != tt.reusing {
+				t.Fatalf("boundsReusable = %v, want %v", got, tt.reusing)
+			}
+		})
+	}
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// exchangePageSize is the /exchanges page size.
+	exchangePageSize = 250
+	// exchangeWindowDays is the longest range /exchanges/{id}/volume_chart/range
+	// serves in one call.
+	exchangeWindowDays = 31
+	// tickerPageSize is the fixed page size of /coins/{id}/tickers.
+	tickerPageSize = 100
+	// tickerMaxPages caps the tickers stored per coin; pages come in trust
+	// score order, so the tail is mostly thin markets.
+	tickerMaxPages = 10
+)
+
+// refreshExchanges stores the whole /exchanges list.
+func refreshExchanges(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB) (int, error) {
+	n := 0
+	for page := 1; ; page++ {
+		exs, _, body, err := cg.Exchanges(ctx, page, exchangePageSize)
+		if err != nil {
+			return n, fmt.Errorf("exchanges page %d: %w; body=%s", page, err, truncate(body, 300))
+		}
+		if err := insertExchanges(ctx, db, cfg.CHExchangesTable, exs); err != nil {
+			return n, err
+		}
+		n += len(exs)
+		if len(exs) < exchangePageSize {
+			return n, nil
+		}
+	}
+}
+
+// volumeExchanges returns EXCHANGE_IDS or else the EXCHANGE_TOP stored
+// exchanges by trust score rank.
+func volumeExchanges(ctx context.Context, cfg Config, db *sql.DB) ([]string, error) {
+	if len(cfg.ExchangeIDs) > 0 {
+		return sortedKeys(cfg.ExchangeIDs), nil
+	}
+	ids, err := getExchangeIDs(ctx, db, cfg.CHExchangesTable)
+	if err != nil {
+		return nil, err
+	}
+	return ids[:min(len(ids), cfg.ExchangeTop)], nil
+}
+
+// exchangeVolumeWindow is the volume task of exchange id covering up to
+// exchangeWindowDays days that end on end, not before startLimit.
+func exchangeVolumeWindow(id string, end, startLimit time.Time) (Task, bool) {
+	end = dateOnlyUTC(end)
+	if end.Before(startLimit) {
+		return Task{}, false
+	}
+	start := end.AddDate(0, 0, -(exchangeWindowDays - 1))
+	if start.Before(startLimit) {
+		start = startLimit
+	}
+	return Task{
+		CoinID:     id,
+		VsCurrency: "btc",
+		From:       start,
+		To:         end,
+		Phase:      PhaseExchangeVolume,
+	}, true
+}
+
+// planExchangeVolume returns the tasks that bring the stored volume of ids up
+// to yday, and the window before the first stored day of each exchange that
+// starts walking its history back. An exchange without stored volume starts
+// from yday; one whose history begins after START_DATE costs one empty call
+// per sync.
+func planExchangeVolume(cfg Config, ids []string, stored map[string]dateRange, yday time.Time) (tasks []Task, older map[string]Task) {
+	older = make(map[string]Task)
+	for _, id := range ids {
+		end := yday
+		if r, ok := stored[id]; ok {
+			for from := r.Max.AddDate(0, 0, 1); !from.After(yday); {
+				to := from.AddDate(0, 0, exchangeWindowDays-1)
+				if to.After(yday) {
+					to = yday
+				}
+				tasks = append(tasks, Task{CoinID: id, VsCurrency: "btc", From: from, To: to, Phase: PhaseExchangeVolume})
+				from = to.AddDate(0, 0, 1)
+			}
+			end = r.Min.AddDate(0, 0, -1)
+		}
+		if t, ok := exchangeVolumeWindow(id, end, cfg.StartDate); ok {
+			tasks = append(tasks, t)
+			older[id] = t
+		}
+	}
+	return tasks, older
+}
+
+// RunExchangeVolume fetches the daily volume of the scheduled exchanges
+// missing since their last stored day and walks their history back a window
+// at a time, newest first, until a window comes back empty or START_DATE is
+// reached.
+func RunExchangeVolume(ctx context.Context, cfg Config, db *sql.DB, ids []string, tasks chan<- Task, results <-chan TaskResult) error {
+	status.SetPhase(string(PhaseExchangeVolume))
+
+	stored, err := getExchangeVolumeRanges(ctx, db, cfg.CHExchangeVolumeTable)
+	if err != nil {
+		return err
+	}
+	pending, older := planExchangeVolume(cfg, ids, stored, yesterdayUTC())
+	if len(pending) == 0 {
+		log.Info("exchange volume: nothing to do")
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"exchanges": len(ids),
+		"tasks":     len(pending),
+	}).Info("exchange volume started")
+
+	var sumInserted, sumErrors int
+	run := startRun(ctx, cfg, db, string(PhaseExchangeVolume))
+	err = runTasks(ctx, pending, tasks, results, func(res TaskResult) []Task {
+		run.record(ctx, res)
+		sumInserted += res.Inserted
+		if res.Err != "" {
+			sumErrors++
+			log.WithFields(log.Fields{
+				"exchange": res.Task.CoinID,
+				"from":     formatDate(res.Task.From),
+				"to":       formatDate(res.Task.To),
+			}).Warnf("exchange volume task error: %s", res.Err)
+			return nil
+		}
+
+		id := res.Task.CoinID
+		if o, ok := older[id]; !ok || !o.From.Equal(res.Task.From) {
+			return nil
+		}
+		delete(older, id)
+		if res.Empty {
+			return nil
+		}
+		next, ok := exchangeVolumeWindow(id, res.Task.From.AddDate(0, 0, -1), cfg.StartDate)
+		if !ok {
+			return nil
+		}
+		older[id] = next
+		return []Task{next}
+	})
+	run.finish(ctx, err)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"exchanges":   len(ids),
+		"insertedSum": sumInserted,
+		"errors":      sumErrors,
+	}).Info("exchange volume finished")
+
+	if sumErrors > 0 {
+		return fmt.Errorf("exchange volume: %w: %d tasks", errTasksFailed, sumErrors)
+	}
+	return nil
+}
+
+// handleExchangeVolumeTask fetches the volume of one exchange window and
+// stores the last point of every day in it.
+func handleExchangeVolumeTask(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB, t Task) TaskResult {
+	fromStr, toStr := formatDate(t.From), formatDate(t.To)
+	res := TaskResult{Task: t, Empty: true}
+
+	var (
+		pairs [][]json.Number
+		body  []byte
+		err   error
+	)
+	for attempt := 0; attempt <= cfg.MaxRetriesPerBlock; attempt++ {
+		pairs, res.HTTPStatus, body, err = cg.ExchangeVolumeChartRange(ctx, t.CoinID, t.From.Unix(), t.To.AddDate(0, 0, 1).Unix()-1)
+		if err == nil || !isRetryableStatus(res.HTTPStatus) {
+			break
+		}
+		logHTTPError(t.CoinID, fromStr, toStr, res.HTTPStatus, body, err)
+		if attempt < cfg.MaxRetriesPerBlock {
+			metricRetries.WithLabelValues("http", string(t.Phase)).Inc()
+		}
+		time.Sleep(backoffSleep(attempt))
+	}
+	if err != nil {
+		res.Err = fmt.Sprintf("%v; body=%s", err, truncate(body, 300))
+		return res
+	}
+
+	byDay := make(map[string]ExchangeVolumePoint)
+	for _, row := range pairs {
+		if len(row) < 2 {
+			continue
+		}
+		ms, err1 := row[0].Float64()
+		v, err2 := row[1].Float64()
+		if err := errors.Join(err1, err2); err != nil {
+			continue
+		}
+		ts := time.UnixMilli(int64(ms)).UTC()
+		if d := dateOnlyUTC(ts); d.Before(t.From) || d.After(t.To) {
+			continue
+		}
+		day := formatDate(ts)
+		if p, ok := byDay[day]; !ok || ts.After(p.Timestamp) {
+			byDay[day] = ExchangeVolumePoint{ExchangeID: t.CoinID, Timestamp: ts, VolumeBTC: v}
+		}
+	}
+
+	pts := make([]ExchangeVolumePoint, 0, len(byDay))
+	for _, day := range sortedKeys(byDay) {
+		pts = append(pts, byDay[day])
+	}
+	res.APIDays = len(pts)
+	res.Empty = len(pts) == 0
+	if res.Empty {
+		return res
+	}
+	res.DataFrom = dateOnlyUTC(pts[0].Timestamp)
+	res.DataTo = dateOnlyUTC(pts[len(pts)-1].Timestamp)
+
+	if err := insertExchangeVolume(ctx, db, cfg.CHExchangeVolumeTable, pts); err != nil {
+		res.Err = fmt.Sprintf("insert: %v", err)
+		return res
+	}
+	res.Inserted = len(pts)
+	metricRowsInserted.WithLabelValues(string(t.Phase)).Add(float64(len(pts)))
+	return res
+}
+
+// snapshotTickers stores today's tickers of every coin in TICKER_IDS. A coin
+// failing doesn't keep the others from being stored.
+func snapshotTickers(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB) (int, error) {
+	today := dateOnlyUTC(time.Now())
+	var (
+		errs  []error
+		total int
+	)
+	for _, id := range sortedKeys(cfg.TickerIDs) {
+		var tickers []Ticker
+		for page := 1; page <= tickerMaxPages; page++ {
+			ts, _, body, err := cg.CoinTickers(ctx, id, page)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s page %d: %w; body=%s", id, page, err, truncate(body, 300)))
+				break
+			}
+			tickers = append(tickers, ts...)
+			if len(ts) < tickerPageSize {
+				break
+			}
+		}
+		if err := insertTickers(ctx, db, cfg.CHTickersTable, id, today, tickers); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", id, err))
+			continue
+		}
+		total += len(tickers)
+	}
+	metricRowsInserted.WithLabelValues("tickers").Add(float64(total))
+	return total, errors.Join(errs...)
+}
+
+// syncExchanges refreshes the exchange list, brings the exchange volume up
+// to date (pro API only) and snapshots the tickers.
+func (a *app) syncExchanges(ctx context.Context) error {
+	a.startWorkers(ctx)
+	cfg := a.config()
+	var errs []error
+
+	n, err := refreshExchanges(ctx, cfg, a.cg, a.db)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		log.WithField("exchanges", n).Info("exchanges stored")
+	}
+
+	if proAPI(cfg) {
+		ids, err := volumeExchanges(ctx, cfg, a.db)
+		if err == nil {
+			err = RunExchangeVolume(ctx, cfg, a.db, ids, a.tasksCh, a.resultsCh)
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	} else {
+		log.Debug("exchange volume needs the pro API; skipped")
+	}
+
+	if len(cfg.TickerIDs) > 0 {
+		n, err := snapshotTickers(ctx, cfg, a.cg, a.db)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("tickers: %w", err))
+		}
+		log.WithFields(log.Fields{"coins": len(cfg.TickerIDs), "tickers": n}).Info("tickers stored")
+	}
+	return errors.Join(errs...)
+}
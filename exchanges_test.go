@@ -0,0 +1,86 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestPlanExchangeVolume(t *testing.T) {
+	cfg := Config{StartDate: mustParseDate("2024-01-01")}
+	yday := mustParseDate("2024-03-31")
+	stored := func(first, last string) dateRange {
+		return dateRange{Min: mustParseDate(first), Max: mustParseDate(last)}
+	}
+
+	tests := []struct {
+		name   string
+		ids    []string
+		stored map[string]dateRange
+		tasks  []string          // id:from:to
+		older  map[string]string // id -> from:to
+	}{
+		{
+			name:  "nothing stored",
+			ids:   []string{"a"},
+			tasks: []string{"a:2024-03-01:2024-03-31"},
+			older: map[string]string{"a": "2024-03-01:2024-03-31"},
+		},
+		{
+			name:   "complete",
+			ids:    []string{"a"},
+			stored: map[string]dateRange{"a": stored("2024-01-01", "2024-03-31")},
+		},
+		{
+			name:   "missing days in windows",
+			ids:    []string{"a"},
+			stored: map[string]dateRange{"a": stored("2024-01-01", "2024-02-15")},
+			tasks:  []string{"a:2024-02-16:2024-03-17", "a:2024-03-18:2024-03-31"},
+		},
+		{
+			name:   "older history",
+			ids:    []string{"a"},
+			stored: map[string]dateRange{"a": stored("2024-02-10", "2024-03-31")},
+			tasks:  []string{"a:2024-01-10:2024-02-09"},
+			older:  map[string]string{"a": "2024-01-10:2024-02-09"},
+		},
+		{
+			name:   "older window ends at start date",
+			ids:    []string{"a"},
+			stored: map[string]dateRange{"a": stored("2024-01-20", "2024-03-30")},
+			tasks:  []string{"a:2024-03-31:2024-03-31", "a:2024-01-01:2024-01-19"},
+			older:  map[string]string{"a": "2024-01-01:2024-01-19"},
+		},
+		{
+			name:   "every exchange",
+			ids:    []string{"a", "b"},
+			stored: map[string]dateRange{"a": stored("2024-01-01", "2024-03-30")},
+			tasks:  []string{"a:2024-03-31:2024-03-31", "b:2024-03-01:2024-03-31"},
+			older:  map[string]string{"b": "2024-03-01:2024-03-31"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tasks, older := planExchangeVolume(cfg, tt.ids, tt.stored, yday)
+			var gotTasks []string
+			for _, tk := range tasks {
+				if tk.VsCurrency != "btc" || tk.Phase != PhaseExchangeVolume {
+					t.Errorf("task %+v", tk)
+				}
+				gotTasks = append(gotTasks, tk.CoinID+":"+formatDate(tk.From)+":"+formatDate(tk.To))
+			}
+			if !reflect.DeepEqual(gotTasks, tt.tasks) {
+				t.Fatalf("tasks = %v, want %v", gotTasks, tt.tasks)
+			}
+			gotOlder := make(map[string]string)
+			for id, tk := range older {
+				gotOlder[id] = formatDate(tk.From) + ":" + formatDate(tk.To)
+			}
+			if tt.older == nil {
+				tt.older = map[string]string{}
+			}
+			if !reflect.DeepEqual(gotOlder, tt.older) {
+				t.Fatalf("older = %v, want %v", gotOlder, tt.older)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// feedBufferSize is how many recent events are kept for resuming clients.
+	feedBufferSize = 10000
+	// feedSubscriberBuffer is how far a subscriber may fall behind before it
+	// is dropped; it can resume from its last cursor.
+	feedSubscriberBuffer = 1024
+	feedHeartbeat        = 15 * time.Second
+)
+
+// FeedEvent is one newly stored daily point. Seq is the resume cursor; it
+// grows across restarts because it starts from the process start time.
+type FeedEvent struct {
+	Seq        uint64    `json:"seq"`
+	Phase      TaskPhase `json:"phase"`
+	Date       string    `json:"date"`
+	ID         string    `json:"id"`
+	Symbol     string    `json:"symbol"`
+	VsCurrency string    `json:"vs_currency"`
+	Timestamp  time.Time `json:"timestamp"`
+	Price      float64   `json:"price"`
+	MarketCap  float64   `json:"market_cap"`
+	Volume     float64   `json:"volume"`
+}
+
+// feed fans out the points inserted by incremental and revision tasks to the
+// live feed endpoints.
+var feed = newFeedHub(feedBufferSize)
+
+type feedHub struct {
+	mu   sync.Mutex
+	seq  uint64
+	size int
+	buf  []FeedEvent
+	subs map[*feedSub]struct{}
+}
+
+type feedSub struct {
+	ids map[string]bool
+	vs  map[string]bool
+	ch  chan FeedEvent
+	// dropped is closed when the subscriber fell too far behind.
+	dropped chan struct{}
+}
+
+func newFeedHub(size int) *feedHub {
+	return &feedHub{
+		seq:  uint64(time.Now().UnixMicro()),
+		size: size,
+		subs: make(map[*feedSub]struct{}),
+	}
+}
+
+func (s *feedSub) match(ev FeedEvent) bool {
+	return (s.ids == nil || s.ids[ev.ID]) && (s.vs == nil || s.vs[ev.VsCurrency])
+}
+
+// Publish assigns cursors to the points and delivers them to every matching
+// subscriber without blocking.
+func (h *feedHub) Publish(phase TaskPhase, pts []DailyPoint) {
+	if len(pts) == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, p := range pts {
+		h.seq++
+		ev := FeedEvent{
+			Seq:        h.seq,
+			Phase:      phase,
+			Date:       formatDate(p.Timestamp),
+			ID:         p.ID,
+			Symbol:     p.Symbol,
+			VsCurrency: p.VsCurrency,
+			Timestamp:  p.Timestamp,
+			Price:      p.Price,
+			MarketCap:  p.MarketCap,
+			Volume:     p.Volume,
+		}
+		h.buf = append(h.buf, ev)
+		for sub := range h.subs {
+			if !sub.match(ev) {
+				continue
+			}
+			select {
+			case sub.ch <- ev:
+			default:
+				close(sub.dropped)
+				delete(h.subs, sub)
+			}
+		}
+	}
+	if over := len(h.buf) - h.size; over > 0 {
+		h.buf = append(h.buf[:0:0], h.buf[over:]...)
+	}
+	metricFeedEvents.Add(float64(len(pts)))
+	metricFeedSubscribers.Set(float64(len(h.subs)))
+}
+
+// Subscribe registers a subscriber and returns the buffered events after
+// cursor. gap reports that events after cursor are no longer buffered, so
+// the client has to reload from storage.
+func (h *feedHub) Subscribe(ids, vs map[string]bool, cursor uint64, resume bool) (sub *feedSub, backlog []FeedEvent, gap bool) {
+	sub = &feedSub{ids: ids, vs: vs, ch: make(chan FeedEvent, feedSubscriberBuffer), dropped: make(chan struct{})}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if resume {
+		oldest := h.seq + 1
+		if len(h.buf) > 0 {
+			oldest = h.buf[0].Seq
+		}
+		gap = cursor+1 < oldest
+		for _, ev := range h.buf {
+			if ev.Seq > cursor && sub.match(ev) {
+				backlog = append(backlog, ev)
+			}
+		}
+	}
+	h.subs[sub] = struct{}{}
+	metricFeedSubscribers.Set(float64(len(h.subs)))
+	return sub, backlog, gap
+}
+
+func (h *feedHub) Unsubscribe(sub *feedSub) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.subs, sub)
+	metricFeedSubscribers.Set(float64(len(h.subs)))
+}
+
+// feedRequest is the subscription of a feed request: ?ids=a,b&vs=usd and the
+// cursor from ?cursor= or, for SSE, the Last-Event-ID header.
+func feedRequest(r *http.Request) (ids, vs map[string]bool, cursor uint64, resume bool, err error) {
+	q := r.URL.Query()
+	ids = parseCSVSet(q.Get("ids"))
+	vs = parseCSVSet(strings.ToLower(q.Get("vs")))
+	c := q.Get("cursor")
+	if c == "" {
+		c = r.Header.Get("Last-Event-ID")
+	}
+	if c != "" {
+		cursor, err = strconv.ParseUint(c, 10, 64)
+		if err != nil {
+			return nil, nil, 0, false, fmt.Errorf("bad cursor: %q", c)
+		}
+		resume = true
+	}
+	return ids, vs, cursor, resume, nil
+}
+
+// handleFeedSSE streams events as Server-Sent Events with the cursor as the
+// event id, so EventSource resumes on reconnect by itself.
+func handleFeedSSE(w http.ResponseWriter, r *http.Request) {
+	ids, vs, cursor, resume, err := feedRequest(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apiError(w, http.StatusInternalServerError, fmt.Errorf("streaming unsupported"))
+		return
+	}
+
+	sub, backlog, gap := feed.Subscribe(ids, vs, cursor, resume)
+	defer feed.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(ev FeedEvent) error {
+		b, _ := json.Marshal(ev)
+		_, err := fmt.Fprintf(w, "id: %d\nevent: point\ndata: %s\n\n", ev.Seq, b)
+		return err
+	}
+	if gap {
+		fmt.Fprintf(w, "event: reset\ndata: {\"cursor\":%d}\n\n", cursor)
+	}
+	for _, ev := range backlog {
+		if send(ev) != nil {
+			return
+		}
+	}
+	flusher.Flush()
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-sub.dropped:
+			fmt.Fprint(w, "event: dropped\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		case ev := <-sub.ch:
+			if send(ev) != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
+
+// feedMessage is what the WebSocket feed sends: point events, and reset or
+// dropped notices that tell the client to reload or reconnect.
+type feedMessage struct {
+	Type   string     `json:"type"`
+	Event  *FeedEvent `json:"event,omitempty"`
+	Cursor uint64     `json:"cursor,omitempty"`
+}
+
+var feedUpgrader = websocket.Upgrader{
+	// The feed is read-only market data, so browser dashboards on any
+	// origin may subscribe.
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+func handleFeedWS(w http.ResponseWriter, r *http.Request) {
+	ids, vs, cursor, resume, err := feedRequest(r)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, err)
+		return
+	}
+	conn, err := feedUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Debugf("feed: websocket upgrade: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	sub, backlog, gap := feed.Subscribe(ids, vs, cursor, resume)
+	defer feed.Unsubscribe(sub)
+
+	// Incoming messages are ignored; reading notices when the client leaves.
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(m feedMessage) error {
+		_ = conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+		return conn.WriteJSON(m)
+	}
+	if gap && send(feedMessage{Type: "reset", Cursor: cursor}) != nil {
+		return
+	}
+	for _, ev := range backlog {
+		if send(feedMessage{Type: "point", Event: &ev}) != nil {
+			return
+		}
+	}
+
+	heartbeat := time.NewTicker(feedHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-closed:
+			return
+		case <-sub.dropped:
+			_ = send(feedMessage{Type: "dropped"})
+			return
+		case <-heartbeat.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)) != nil {
+				return
+			}
+		case ev := <-sub.ch:
+			if send(feedMessage{Type: "point", Event: &ev}) != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// FX sources for the derived currencies.
+const (
+	fxExchangeRates = "exchange_rates" // /exchange_rates, today's rates only
+	fxBTC           = "btc"            // the bitcoin series in every currency
+)
+
+// provenanceAPI marks points fetched from CoinGecko; derived points carry
+// "fx:" and the FX source.
+const provenanceAPI = "api"
+
+// FXRate is how many units of VsCurrency one unit of the base currency
+// (COINGECKO_VS_CURRENCY) was worth on Date.
+type FXRate struct {
+	Date       time.Time
+	VsCurrency string
+	Rate       float64
+	Source     string
+}
+
+// fxRates holds the stored FX rates the workers derive points with.
+var fxRates = &fxBook{}
+
+type fxBook struct {
+	mu    sync.RWMutex
+	rates map[string]map[time.Time]float64
+}
+
+func (b *fxBook) Set(rates []FXRate) {
+	m := make(map[string]map[time.Time]float64)
+	for _, r := range rates {
+		if m[r.VsCurrency] == nil {
+			m[r.VsCurrency] = make(map[time.Time]float64)
+		}
+		m[r.VsCurrency][dateOnlyUTC(r.Date)] = r.Rate
+	}
+	b.mu.Lock()
+	b.rates = m
+	b.mu.Unlock()
+}
+
+// Rate returns the rate of vs on day, or the nearest one at most maxAge days
+// away, preferring the earlier day on a tie.
+func (b *fxBook) Rate(vs string, day time.Time, maxAge int) (float64, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	byDay := b.rates[vs]
+	day = dateOnlyUTC(day)
+	for d := 0; d <= maxAge; d++ {
+		if r, ok := byDay[day.AddDate(0, 0, -d)]; ok {
+			return r, true
+		}
+		if r, ok := byDay[day.AddDate(0, 0, d)]; ok {
+			return r, true
+		}
+	}
+	return 0, false
+}
+
+// derivePoints converts points in the base currency into every derived
+// currency. Points without a usable rate are left out and counted.
+func derivePoints(cfg Config, pts []DailyPoint) (derived []DailyPoint, noRate int) {
+	for _, vs := range sortedKeys(cfg.DerivedVsCurrencies) {
+		for _, p := range pts {
+			rate, ok := fxRates.Rate(vs, p.Timestamp, cfg.FXMaxAgeDays)
+			if !ok {
+				noRate++
+				continue
+			}
+			d := p
+			d.VsCurrency = vs
+			d.Price *= rate
+			d.MarketCap *= rate
+			d.Volume *= rate
+			d.Provenance = "fx:" + cfg.FXSource
+			derived = append(derived, d)
+		}
+	}
+	return derived, noRate
+}
+
+// storeDerived stores the points derived from pts, the base currency points
+// a task inserted, and then deletes the derived points they replace on the
+// revised days.
+func storeDerived(ctx context.Context, cfg Config, db *sql.DB, t Task, pts []DailyPoint, revisedDays []string) ([]DailyPoint, error) {
+	derived, noRate := derivePoints(cfg, pts)
+	if noRate > 0 {
+		metricFXMissing.Add(float64(noRate))
+	}
+	insertStart := time.Now()
+	inserted, err := insertDailyPoints(ctx, db, cfg.CHTable, derived)
+	if err != nil {
+		return nil, err
+	}
+	metricRowsInserted.WithLabelValues("derived").Add(float64(inserted))
+	for _, vs := range sortedKeys(cfg.DerivedVsCurrencies) {
+		if err := deleteSuperseded(ctx, db, cfg.CHTable, t.CoinID, vs, revisedDays, insertStart); err != nil {
+			return derived, err
+		}
+	}
+	return derived, nil
+}
+
+// deriveInsertBatch is the maximum number of derived points per insert.
+const deriveInsertBatch = 5000
+
+// rederive replaces the derived points of one coin between from and to with
+// points derived from the stored base currency points. The old points are
+// deleted only once all new ones are stored.
+func rederive(ctx context.Context, cfg Config, db *sql.DB, id string, from, to time.Time) (int, error) {
+	var pts []DailyPoint
+	err := streamSeries(ctx, db, cfg.CHTable, id, cfg.VsCurrency, from, to, func(p DailyPoint) error {
+		pts = append(pts, p)
+		return nil
+	})
+	if err != nil || len(pts) == 0 {
+		return 0, err
+	}
+
+	derived, noRate := derivePoints(cfg, pts)
+	if noRate > 0 {
+		metricFXMissing.Add(float64(noRate))
+	}
+	insertStart := time.Now()
+	for rest := derived; len(rest) > 0; {
+		batch := rest[:min(len(rest), deriveInsertBatch)]
+		inserted, err := insertDailyPoints(ctx, db, cfg.CHTable, batch)
+		if err != nil {
+			return 0, err
+		}
+		metricRowsInserted.WithLabelValues("derived").Add(float64(inserted))
+		rest = rest[len(batch):]
+	}
+
+	days := make([]string, len(pts))
+	revised := make([]time.Time, len(pts))
+	for i, p := range pts {
+		days[i] = formatDate(p.Timestamp)
+		revised[i] = p.Timestamp
+	}
+	for _, vs := range sortedKeys(cfg.DerivedVsCurrencies) {
+		if err := deleteSuperseded(ctx, db, cfg.CHTable, id, vs, days, insertStart); err != nil {
+			return 0, err
+		}
+		revisedCoinDays.Add(coinKey(id, vs), revised...)
+	}
+
+	changedDays.AddPoints(derived)
+	indicatorDays.AddCoinPoints(derived)
+	return len(derived), nil
+}
+
+// refreshFX fetches the rates missing since the last call, stores them and
+// reloads the rates the workers use. Without derived currencies it does
+// nothing.
+func (a *app) refreshFX(ctx context.Context) {
+	cfg := a.config()
+	if len(cfg.DerivedVsCurrencies) == 0 {
+		return
+	}
+
+	var (
+		fetched []FXRate
+		err     error
+	)
+	switch cfg.FXSource {
+	case fxExchangeRates:
+		fetched, err = fetchExchangeRates(ctx, cfg, a.cg)
+	case fxBTC:
+		fetched, err = a.fetchBTCRates(ctx, cfg)
+	}
+	if ierr := insertFXRates(ctx, a.db, cfg.CHFXTable, cfg.VsCurrency, fetched); err == nil {
+		err = ierr
+	}
+	if err != nil && ctx.Err() == nil {
+		log.WithField("source", cfg.FXSource).Warnf("fx rates refresh failed: %v", err)
+	}
+
+	stored, err := getFXRates(ctx, a.db, cfg.CHFXTable, cfg.VsCurrency)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Warnf("load fx rates: %v", err)
+		}
+		return
+	}
+	fxRates.Set(stored)
+	log.WithFields(log.Fields{
+		"source":  cfg.FXSource,
+		"fetched": len(fetched),
+		"stored":  len(stored),
+	}).Info("fx rates loaded")
+}
+
+// fetchExchangeRates converts today's /exchange_rates, which are quoted
+// against BTC, into rates against the base currency.
+func fetchExchangeRates(ctx context.Context, cfg Config, cg *CGClient) ([]FXRate, error) {
+	btc, _, body, err := cg.ExchangeRates(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w; body=%s", err, truncate(body, 300))
+	}
+	base := btc[cfg.VsCurrency]
+	if base <= 0 {
+		return nil, fmt.Errorf("no %s rate in /exchange_rates", cfg.VsCurrency)
+	}
+
+	today := dateOnlyUTC(time.Now())
+	var out []FXRate
+	for _, vs := range sortedKeys(cfg.DerivedVsCurrencies) {
+		if r, ok := btc[vs]; ok && r > 0 {
+			out = append(out, FXRate{Date: today, VsCurrency: vs, Rate: r / base, Source: fxExchangeRates})
+		}
+	}
+	return out, nil
+}
+
+// fetchBTCRates divides the daily bitcoin price in every derived currency by
+// the one in the base currency, for the days after the last stored rate.
+func (a *app) fetchBTCRates(ctx context.Context, cfg Config) ([]FXRate, error) {
+	latest, err := getLatestFXDates(ctx, a.db, cfg.CHFXTable, cfg.VsCurrency)
+	if err != nil {
+		return nil, err
+	}
+	yday := yesterdayUTC()
+	from := yday
+	for vs := range cfg.DerivedVsCurrencies {
+		d, ok := latest[vs]
+		if !ok {
+			from = cfg.StartDate
+			break
+		}
+		if next := d.AddDate(0, 0, 1); next.Before(from) {
+			from = next
+		}
+	}
+	if from.After(yday) {
+		return nil, nil
+	}
+
+	prices := func(vs string) (map[string]*dailyAgg, error) {
+		resp, _, body, err := fetchRange(ctx, cfg, a.cg, Task{CoinID: "bitcoin", VsCurrency: vs, From: from, To: yday, Phase: PhaseIncremental})
+		if err != nil {
+			return nil, fmt.Errorf("bitcoin in %s: %w; body=%s", vs, err, truncate(body, 300))
+		}
+		return aggregateDaily(resp), nil
+	}
+	base, err := prices(cfg.VsCurrency)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []FXRate
+	for _, vs := range sortedKeys(cfg.DerivedVsCurrencies) {
+		quoted, err := prices(vs)
+		if err != nil {
+			return out, err
+		}
+		days := make([]string, 0, len(quoted))
+		for day := range quoted {
+			days = append(days, day)
+		}
+		sort.Strings(days)
+		for _, day := range days {
+			b, ok := base[day]
+			if !ok || b.p <= 0 || quoted[day].p <= 0 {
+				continue
+			}
+			out = append(out, FXRate{Date: mustParseDate(day), VsCurrency: vs, Rate: quoted[day].p / b.p, Source: fxBTC})
+		}
+	}
+	return out, nil
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type Gap struct {
+	ID         string
+	Symbol     string
+	VsCurrency string
+	From       time.Time
+	To         time.Time
+}
+
+func BuildGapTasks(cfg Config, gaps []Gap) []Task {
+	var tasks []Task
+	for _, g := range gaps {
+		if cfg.CoinIDsFilter != nil && !cfg.CoinIDsFilter[g.ID] {
+			continue
+		}
+		// Derived currencies are never fetched; `derive` fills them.
+		if cfg.DerivedVsCurrencies[g.VsCurrency] {
+			continue
+		}
+		sym := strings.ToUpper(strings.TrimSpace(g.Symbol))
+		if sym == "" {
+			sym = strings.ToUpper(g.ID)
+		}
+		for _, t := range backfillTasks(g.ID, sym, g.From, g.To) {
+			t.VsCurrency = g.VsCurrency
+			t.Phase = PhaseGapFill
+			tasks = append(tasks, t)
+		}
+	}
+	return tasks
+}
+
+func RunGapRepair(ctx context.Context, cfg Config, db *sql.DB, tasks chan<- Task, results <-chan TaskResult) error {
+	status.SetPhase(string(PhaseGapFill))
+
+	gaps, err := findGaps(ctx, db, cfg.CHTable, cfg.CHEmptyDaysTable, cfg.CHQuarantineTable)
+	if err != nil {
+		return err
+	}
+
+	pending := BuildGapTasks(cfg, gaps)
+	if len(pending) == 0 {
+		log.Info("gap repair: no gaps found")
+		return nil
+	}
+
+	gapDays := 0
+	for _, t := range pending {
+		gapDays += daysBetween(t.From, t.To) + 1
+	}
+
+	log.WithFields(log.Fields{
+		"gaps":  len(gaps),
+		"days":  gapDays,
+		"tasks": len(pending),
+	}).Info("gap repair started")
+
+	var (
+		sumInserted    = 0
+		sumEmpty       = 0
+		sumQuarantined = 0
+		sumErrors      = 0
+		sumRetried     = 0
+		failed         = 0
+	)
+
+	run := startRun(ctx, cfg, db, string(PhaseGapFill))
+	err = runTasks(ctx, pending, tasks, results, func(res TaskResult) []Task {
+		run.record(ctx, res)
+		if res.Err != "" {
+			sumErrors++
+			log.WithFields(log.Fields{
+				"id":     res.Task.CoinID,
+				"symbol": res.Task.Symbol,
+				"vs":     res.Task.VsCurrency,
+				"from":   formatDate(res.Task.From),
+				"to":     formatDate(res.Task.To),
+			}).Warnf("gap task error: %s", res.Err)
+		}
+
+		if (res.Err != "" || len(res.MissingDates) > 0) && res.Task.Retry < cfg.MaxRetriesPerBlock {
+			rt := res.Task
+			rt.Retry++
+			sumRetried++
+			return []Task{rt}
+		}
+		if res.Err != "" {
+			failed++
+		}
+
+		sumInserted += res.Inserted
+		sumEmpty += res.EmptyDays
+		sumQuarantined += res.Quarantined
+		return nil
+	})
+	run.finish(ctx, err)
+	if err != nil {
+		return err
+	}
+
+	log.WithFields(log.Fields{
+		"gaps":        len(gaps),
+		"insertedSum": sumInserted,
+		"emptyDays":   sumEmpty,
+		"quarantined": sumQuarantined,
+		"errors":      sumErrors,
+		"retried":     sumRetried,
+		"failed":      failed,
+	}).Info("gap repair finished")
+
+	if failed > 0 {
+		return fmt.Errorf("gap repair: %w: %d tasks", errTasksFailed, failed)
+	}
+	return nil
+}
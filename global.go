@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// globalHistoryEvery is how often the daily global history is topped up.
+const globalHistoryEvery = 24 * time.Hour
+
+// GlobalSnapshot is one /global response. The maps are keyed by currency,
+// MarketCapPercentage by coin symbol.
+type GlobalSnapshot struct {
+	SnapshotAt             time.Time
+	UpdatedAt              time.Time
+	ActiveCryptocurrencies int
+	Markets                int
+	TotalMarketCap         map[string]float64
+	TotalVolume            map[string]float64
+	MarketCapPercentage    map[string]float64
+	MarketCapChange24hUSD  float64
+}
+
+// GlobalDefiSnapshot is one /global/decentralized_finance_defi response.
+type GlobalDefiSnapshot struct {
+	SnapshotAt           time.Time
+	DefiMarketCap        float64
+	EthMarketCap         float64
+	DefiToEthRatio       float64
+	TradingVolume24h     float64
+	DefiDominance        float64
+	TopCoinName          string
+	TopCoinDefiDominance float64
+}
+
+// GlobalPoint is the total market cap and volume of one day.
+type GlobalPoint struct {
+	VsCurrency string
+	Timestamp  time.Time
+	MarketCap  float64
+	Volume     float64
+}
+
+// proAPI reports whether cfg talks to the pro API, which alone serves the
+// global history.
+func proAPI(cfg Config) bool {
+	return cfg.CGAPIKeyHeader == "x-cg-pro-api-key" && cfg.CGAPIKey != ""
+}
+
+// takeGlobalSnapshots stores the current /global and
+// /global/decentralized_finance_defi responses. One failing doesn't keep the
+// other from being stored.
+func takeGlobalSnapshots(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB) error {
+	now := time.Now().UTC()
+	var errs []error
+
+	g, _, body, err := cg.Global(ctx)
+	if err == nil {
+		err = insertGlobalSnapshot(ctx, db, cfg.CHGlobalTable, GlobalSnapshot{
+			SnapshotAt:             now,
+			UpdatedAt:              time.Unix(g.Data.UpdatedAt, 0).UTC(),
+			ActiveCryptocurrencies: g.Data.ActiveCryptocurrencies,
+			Markets:                g.Data.Markets,
+			TotalMarketCap:         g.Data.TotalMarketCap,
+			TotalVolume:            g.Data.TotalVolume,
+			MarketCapPercentage:    g.Data.MarketCapPercentage,
+			MarketCapChange24hUSD:  g.Data.MarketCapChangePercentage24hUSD,
+		})
+	} else {
+		err = fmt.Errorf("%w; body=%s", err, truncate(body, 300))
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("global: %w", err))
+	}
+
+	d, _, body, err := cg.GlobalDefi(ctx)
+	if err == nil {
+		err = insertGlobalDefiSnapshot(ctx, db, cfg.CHGlobalDefiTable, GlobalDefiSnapshot{
+			SnapshotAt:           now,
+			DefiMarketCap:        jsonFloat(d.Data.DefiMarketCap),
+			EthMarketCap:         jsonFloat(d.Data.EthMarketCap),
+			DefiToEthRatio:       jsonFloat(d.Data.DefiToEthRatio),
+			TradingVolume24h:     jsonFloat(d.Data.TradingVolume24h),
+			DefiDominance:        jsonFloat(d.Data.DefiDominance),
+			TopCoinName:          d.Data.TopCoinName,
+			TopCoinDefiDominance: d.Data.TopCoinDefiDominance,
+		})
+	} else {
+		err = fmt.Errorf("%w; body=%s", err, truncate(body, 300))
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("global defi: %w", err))
+	}
+
+	if len(errs) == 0 {
+		metricGlobalSnapshots.Inc()
+	}
+	return errors.Join(errs...)
+}
+
+// backfillGlobalHistory stores the daily global totals in the base currency
+// from the day after the last stored one (all of them the first time) up to
+// yesterday.
+func backfillGlobalHistory(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB) (int, error) {
+	vs := cfg.VsCurrency
+	last, ok, err := getGlobalHistoryMax(ctx, db, cfg.CHGlobalHistoryTable, vs)
+	if err != nil {
+		return 0, err
+	}
+	yday := yesterdayUTC()
+	days := "max"
+	if ok {
+		if !last.Before(yday) {
+			return 0, nil
+		}
+		days = strconv.Itoa(daysBetween(last, yday) + 1)
+	}
+
+	resp, _, body, err := cg.GlobalMarketCapChart(ctx, vs, days)
+	if err != nil {
+		return 0, fmt.Errorf("%w; body=%s", err, truncate(body, 300))
+	}
+
+	// The chart has the same shape as a coin's market chart, so it is
+	// reduced to the last point of every day the same way.
+	byDay := aggregateDaily(MarketChartRangeResp{
+		MarketCaps:   resp.MarketCapChart.MarketCap,
+		TotalVolumes: resp.MarketCapChart.Volume,
+	})
+	var pts []GlobalPoint
+	for _, day := range sortedKeys(byDay) {
+		d := mustParseDate(day)
+		if d.After(yday) || ok && !d.After(last) {
+			continue
+		}
+		a := byDay[day]
+		pts = append(pts, GlobalPoint{VsCurrency: vs, Timestamp: a.ts, MarketCap: a.mc, Volume: a.v})
+	}
+	return len(pts), insertGlobalHistory(ctx, db, cfg.CHGlobalHistoryTable, pts)
+}
+
+// globalLoop snapshots the global market every GLOBAL_SNAPSHOT_EVERY and, on
+// the pro API, tops up the global history daily, until ctx is cancelled.
+func (a *app) globalLoop(ctx context.Context) {
+	cfg := a.config()
+	if cfg.GlobalSnapshotEvery <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(cfg.GlobalSnapshotEvery)
+	defer ticker.Stop()
+
+	var lastHistory time.Time
+	for {
+		cfg = a.config()
+		if err := takeGlobalSnapshots(ctx, cfg, a.cg, a.db); err != nil && ctx.Err() == nil {
+			log.Warnf("global snapshot failed: %v", err)
+		}
+		if proAPI(cfg) && time.Since(lastHistory) >= globalHistoryEvery {
+			n, err := backfillGlobalHistory(ctx, cfg, a.cg, a.db)
+			if err != nil {
+				if ctx.Err() == nil {
+					log.Warnf("global history backfill failed: %v", err)
+				}
+			} else {
+				lastHistory = time.Now()
+				if n > 0 {
+					log.WithField("days", n).Info("global history stored")
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// jsonFloat parses a number CoinGecko sent as a string; malformed ones are 0.
+func jsonFloat(n json.Number) float64 {
+	f, _ := n.Float64()
+	return f
+}
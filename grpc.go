@@ -0,0 +1,325 @@
+package main
+
+//go:generate protoc -I proto --go_out=marketpb --go_opt=paths=source_relative --go-grpc_out=marketpb --go-grpc_opt=paths=source_relative marketdata.proto
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"testProject/marketpb"
+)
+
+// marketDataServer implements the MarketData gRPC service on the same
+// queries as the HTTP read API.
+type marketDataServer struct {
+	marketpb.UnimplementedMarketDataServer
+
+	cfg Config
+	db  *sql.DB
+}
+
+func newGRPCServer(cfg Config, db *sql.DB) *grpc.Server {
+	srv := grpc.NewServer()
+	marketpb.RegisterMarketDataServer(srv, &marketDataServer{cfg: cfg, db: db})
+	return srv
+}
+
+func (s *marketDataServer) GetSeries(ctx context.Context, req *marketpb.GetSeriesRequest) (*marketpb.GetSeriesResponse, error) {
+	page, err := grpcPage(req.GetLimit(), req.GetOffset())
+	if err != nil {
+		return nil, err
+	}
+	vs, from, to, err := s.seriesWindow(req.GetId(), req.GetVsCurrency(), req.GetFrom(), req.GetTo())
+	if err != nil {
+		return nil, err
+	}
+
+	ru, rolled := rollupFor(req.GetResolution())
+	if !rolled && req.GetResolution() != "" && req.GetResolution() != "day" {
+		return nil, grpcstatus.Errorf(codes.InvalidArgument, "resolution must be day, week or month, got %q", req.GetResolution())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, apiQueryTimeout)
+	defer cancel()
+	if rolled {
+		rps, err := queryRollupSeries(ctx, s.db, ru.table(s.cfg), req.GetId(), vs, ru.start(from), to, page.limit+1, page.offset)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		resp := &marketpb.GetSeriesResponse{NextOffset: nextOffset(page, len(rps))}
+		for _, p := range rps[:min(len(rps), page.limit)] {
+			resp.Rollups = append(resp.Rollups, rollupProto(p))
+		}
+		return resp, nil
+	}
+	pts, err := querySeries(ctx, s.db, s.cfg.CHTable, req.GetId(), vs, from, to, page.limit+1, page.offset)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &marketpb.GetSeriesResponse{NextOffset: nextOffset(page, len(pts))}
+	for _, p := range pts[:min(len(pts), page.limit)] {
+		resp.Points = append(resp.Points, pointProto(p))
+	}
+	return resp, nil
+}
+
+func (s *marketDataServer) StreamSeries(req *marketpb.StreamSeriesRequest, stream grpc.ServerStreamingServer[marketpb.DailyPoint]) error {
+	vs, from, to, err := s.seriesWindow(req.GetId(), req.GetVsCurrency(), req.GetFrom(), req.GetTo())
+	if err != nil {
+		return err
+	}
+
+	sent := 0
+	err = streamSeries(stream.Context(), s.db, s.cfg.CHTable, req.GetId(), vs, from, to, func(p DailyPoint) error {
+		sent++
+		return stream.Send(pointProto(p))
+	})
+	log.WithFields(log.Fields{
+		"id":     req.GetId(),
+		"vs":     vs,
+		"points": sent,
+	}).Debug("grpc: series streamed")
+	if err != nil {
+		return grpcError(err)
+	}
+	return nil
+}
+
+func (s *marketDataServer) GetSnapshot(ctx context.Context, req *marketpb.GetSnapshotRequest) (*marketpb.GetSnapshotResponse, error) {
+	page, err := grpcPage(req.GetLimit(), req.GetOffset())
+	if err != nil {
+		return nil, err
+	}
+	vs, err := resolveVs(s.cfg, req.GetVsCurrency())
+	if err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, err.Error())
+	}
+	day, err := parseDay(req.GetDate(), yesterdayUTC())
+	if err != nil {
+		return nil, grpcstatus.Errorf(codes.InvalidArgument, "bad date: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, apiQueryTimeout)
+	defer cancel()
+	pts, err := querySnapshot(ctx, s.db, s.cfg.CHTable, vs, day, page.limit+1, page.offset)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	resp := &marketpb.GetSnapshotResponse{Date: formatDate(day), NextOffset: nextOffset(page, len(pts))}
+	for i, p := range pts[:min(len(pts), page.limit)] {
+		resp.Points = append(resp.Points, &marketpb.RankedPoint{Rank: int32(page.offset + i + 1), Point: pointProto(p)})
+	}
+	return resp, nil
+}
+
+func (s *marketDataServer) ListCoins(ctx context.Context, req *marketpb.ListCoinsRequest) (*marketpb.ListCoinsResponse, error) {
+	page, err := grpcPage(req.GetLimit(), req.GetOffset())
+	if err != nil {
+		return nil, err
+	}
+	vs, err := resolveVs(s.cfg, req.GetVsCurrency())
+	if err != nil {
+		return nil, grpcstatus.Error(codes.InvalidArgument, err.Error())
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, apiQueryTimeout)
+	defer cancel()
+	coins, err := getUniverse(ctx, s.db, s.cfg.CHCoinsTable)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	ranges, err := getDateRanges(ctx, s.db, s.cfg.CHTable, vs)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	var ids []string
+	for _, id := range sortedKeys(coins) {
+		if req.Active == nil || coins[id].Active == req.GetActive() {
+			ids = append(ids, id)
+		}
+	}
+
+	resp := &marketpb.ListCoinsResponse{NextOffset: nextOffset(page, len(ids)-page.offset)}
+	for _, id := range ids[min(page.offset, len(ids)):min(page.offset+page.limit, len(ids))] {
+		c := coins[id]
+		pc := &marketpb.Coin{Id: c.ID, Symbol: c.Symbol, Name: c.Name, Active: c.Active}
+		if rg, ok := ranges[id]; ok {
+			pc.FirstDate, pc.LastDate = formatDate(rg.Min), formatDate(rg.Max)
+		}
+		resp.Coins = append(resp.Coins, pc)
+	}
+	return resp, nil
+}
+
+func (s *marketDataServer) GetIngestionStatus(ctx context.Context, _ *marketpb.GetIngestionStatusRequest) (*marketpb.IngestionStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, apiQueryTimeout)
+	defer cancel()
+	runs, err := getLatestRuns(ctx, s.db, s.cfg.CHRunsTable)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+	latest, err := getLatestDates(ctx, s.db, s.cfg.CHTable)
+	if err != nil {
+		return nil, grpcError(err)
+	}
+
+	snap := status.Snapshot(false)
+	resp := &marketpb.IngestionStatus{
+		Phase:       snap.Phase,
+		Round:       int32(snap.Round),
+		ActiveCoins: int32(snap.ActiveCoins),
+		Heartbeat:   timestamppb.New(snap.Heartbeat),
+	}
+	for _, r := range runs {
+		pr := &marketpb.Run{
+			Kind:      r.Kind,
+			Status:    r.Status,
+			StartedAt: timestamppb.New(r.StartedAt),
+			Tasks:     int32(r.Tasks),
+			Inserted:  int64(r.Inserted),
+			Errors:    int32(r.Errors),
+			Error:     r.Error,
+		}
+		if !r.FinishedAt.IsZero() {
+			pr.FinishedAt = timestamppb.New(r.FinishedAt)
+		}
+		resp.LatestRuns = append(resp.LatestRuns, pr)
+	}
+	yday := yesterdayUTC()
+	for _, vs := range sortedKeys(latest) {
+		resp.Lag = append(resp.Lag, &marketpb.SyncLag{
+			VsCurrency: vs,
+			LatestDate: formatDate(latest[vs]),
+			LagDays:    int32(daysBetween(latest[vs], yday)),
+		})
+	}
+	return resp, nil
+}
+
+// seriesWindow validates the coin, currency and window of a series request.
+func (s *marketDataServer) seriesWindow(id, vs, fromStr, toStr string) (string, time.Time, time.Time, error) {
+	if id == "" {
+		return "", time.Time{}, time.Time{}, grpcstatus.Error(codes.InvalidArgument, "id is required")
+	}
+	vs, err := resolveVs(s.cfg, vs)
+	if err != nil {
+		return "", time.Time{}, time.Time{}, grpcstatus.Error(codes.InvalidArgument, err.Error())
+	}
+	from, err := parseDay(fromStr, s.cfg.StartDate)
+	if err != nil {
+		return "", time.Time{}, time.Time{}, grpcstatus.Errorf(codes.InvalidArgument, "bad from: %v", err)
+	}
+	to, err := parseDay(toStr, yesterdayUTC())
+	if err != nil {
+		return "", time.Time{}, time.Time{}, grpcstatus.Errorf(codes.InvalidArgument, "bad to: %v", err)
+	}
+	if to.Before(from) {
+		return "", time.Time{}, time.Time{}, grpcstatus.Error(codes.InvalidArgument, "to is before from")
+	}
+	return vs, from, to, nil
+}
+
+func grpcPage(limit, offset int32) (apiPage, error) {
+	page := apiPage{limit: apiDefaultLimit, offset: int(offset)}
+	if limit != 0 {
+		page.limit = int(limit)
+	}
+	if page.limit < 1 || page.limit > apiMaxLimit {
+		return page, grpcstatus.Errorf(codes.InvalidArgument, "limit must be within [1, %d], got %d", apiMaxLimit, limit)
+	}
+	if page.offset < 0 {
+		return page, grpcstatus.Errorf(codes.InvalidArgument, "offset must be >= 0, got %d", offset)
+	}
+	return page, nil
+}
+
+// nextOffset is set when more than a page of rows is left from the offset.
+func nextOffset(page apiPage, left int) *int32 {
+	if left <= page.limit {
+		return nil
+	}
+	n := int32(page.offset + page.limit)
+	return &n
+}
+
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return grpcstatus.Error(codes.Canceled, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return grpcstatus.Error(codes.DeadlineExceeded, err.Error())
+	case grpcstatus.Code(err) != codes.Unknown:
+		return err
+	default:
+		log.Warnf("grpc: %v", err)
+		return grpcstatus.Error(codes.Internal, err.Error())
+	}
+}
+
+func pointProto(p DailyPoint) *marketpb.DailyPoint {
+	return &marketpb.DailyPoint{
+		Date:       formatDate(p.Timestamp),
+		Id:         p.ID,
+		Symbol:     p.Symbol,
+		VsCurrency: p.VsCurrency,
+		Timestamp:  timestamppb.New(p.Timestamp),
+		Price:      p.Price,
+		MarketCap:  p.MarketCap,
+		Volume:     p.Volume,
+	}
+}
+
+func rollupProto(p RollupPoint) *marketpb.RollupPoint {
+	return &marketpb.RollupPoint{
+		Date:       formatDate(p.Period),
+		FirstPrice: p.FirstPrice,
+		LastPrice:  p.LastPrice,
+		MinPrice:   p.MinPrice,
+		MaxPrice:   p.MaxPrice,
+		AvgPrice:   p.AvgPrice,
+		MarketCap:  p.MarketCap,
+		Volume:     p.Volume,
+		Days:       int32(p.Days),
+	}
+}
+
+// serveGRPC runs the gRPC server until ctx is cancelled; open streams get a
+// few seconds to finish.
+func serveGRPC(ctx context.Context, addr string, srv *grpc.Server) {
+	lis, err := net.Listen("tcp", addr)
+	if err != nil {
+		log.Errorf("grpc server: %v", err)
+		return
+	}
+
+	go func() {
+		<-ctx.Done()
+		done := make(chan struct{})
+		go func() {
+			srv.GracefulStop()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			srv.Stop()
+		}
+	}()
+
+	log.WithField("addr", addr).Info("grpc server listening")
+	if err := srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		log.Errorf("grpc server: %v", err)
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+// pingCacheTTL bounds how often /readyz spends a CoinGecko request.
+const pingCacheTTL = 30 * time.Second
+
+func newHTTPMux(cfg Config, db *sql.DB, cg *CGClient) *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.Handle("GET /metrics", promhttp.Handler())
+	mux.HandleFunc("GET /healthz", handleHealthz(cfg))
+	mux.HandleFunc("GET /readyz", handleReadyz(db, cg))
+	mux.HandleFunc("GET /status", handleStatus)
+	registerAPI(mux, cfg, db)
+	return mux
+}
+
+// handleHealthz fails when the scheduler has gone StallTimeout without
+// dispatching or finishing a task outside of the idle wait.
+func handleHealthz(cfg Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if status.Stalled(cfg.StallTimeout) {
+			writeJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "stalled"})
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+type readyCheck struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+func handleReadyz(db *sql.DB, cg *CGClient) http.HandlerFunc {
+	var (
+		mu      sync.Mutex
+		pingAt  time.Time
+		pingErr error
+	)
+	pingCG := func(ctx context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(pingAt) < pingCacheTTL {
+			return pingErr
+		}
+		_, pingErr = cg.Ping(ctx)
+		pingAt = time.Now()
+		return pingErr
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+		defer cancel()
+
+		checks := map[string]readyCheck{}
+		check := func(name string, err error) {
+			if err != nil {
+				checks[name] = readyCheck{Error: err.Error()}
+				return
+			}
+			checks[name] = readyCheck{OK: true}
+		}
+
+		check("clickhouse", db.PingContext(ctx))
+		if state := cg.CircuitState(); state == circuitOpen {
+			check("coingecko_circuit", errors.New("circuit "+state))
+			check("coingecko", errCircuitOpen)
+		} else {
+			check("coingecko_circuit", nil)
+			check("coingecko", pingCG(ctx))
+		}
+
+		code := http.StatusOK
+		for _, c := range checks {
+			if !c.OK {
+				code = http.StatusServiceUnavailable
+			}
+		}
+		writeJSON(w, code, map[string]any{
+			"ready":  code == http.StatusOK,
+			"checks": checks,
+		})
+	}
+}
+
+// handleStatus reports scheduler progress; ?coins=true adds per-coin states.
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, status.Snapshot(r.URL.Query().Get("coins") == "true"))
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Debugf("http: write response: %v", err)
+	}
+}
+
+// serveHTTP runs the HTTP server until ctx is cancelled.
+func serveHTTP(ctx context.Context, addr string, h http.Handler) {
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.WithField("addr", addr).Info("http server listening")
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Errorf("http server: %v", err)
+	}
+}
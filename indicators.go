@@ -0,0 +1,313 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// indicatorInsertBatch is the maximum number of values per insert.
+const indicatorInsertBatch = 20000
+
+// Indicator computes one value per day from the daily closes of a coin.
+// closes holds one entry per calendar day, NaN for days without a point.
+type Indicator interface {
+	// Name is the key of the indicator in INDICATORS and the indicators
+	// table.
+	Name() string
+	// Lookback is how many days before a day its value depends on.
+	Lookback() int
+	// Compute returns one value per close, NaN where it is undefined.
+	Compute(closes []float64) []float64
+}
+
+// indicators are all known indicators; INDICATORS picks the computed ones.
+var indicators = []Indicator{
+	returnIndicator{1},
+	returnIndicator{7},
+	returnIndicator{30},
+	volatilityIndicator{30},
+	smaIndicator{7},
+	smaIndicator{30},
+	smaIndicator{200},
+	emaIndicator{12},
+	emaIndicator{26},
+	drawdownIndicator{365},
+}
+
+func indicatorNames() []string {
+	names := make([]string, len(indicators))
+	for i, ind := range indicators {
+		names[i] = ind.Name()
+	}
+	return names
+}
+
+func enabledIndicators(cfg Config) []Indicator {
+	var out []Indicator
+	for _, ind := range indicators {
+		if cfg.Indicators[ind.Name()] {
+			out = append(out, ind)
+		}
+	}
+	return out
+}
+
+type IndicatorValue struct {
+	Date       time.Time
+	ID         string
+	VsCurrency string
+	Indicator  string
+	Value      float64
+}
+
+func nanSeries(n int) []float64 {
+	out := make([]float64, n)
+	for i := range out {
+		out[i] = math.NaN()
+	}
+	return out
+}
+
+// returnIndicator is the simple return over days.
+type returnIndicator struct{ days int }
+
+func (r returnIndicator) Name() string  { return fmt.Sprintf("return_%dd", r.days) }
+func (r returnIndicator) Lookback() int { return r.days }
+
+func (r returnIndicator) Compute(closes []float64) []float64 {
+	out := nanSeries(len(closes))
+	for i := r.days; i < len(closes); i++ {
+		if prev := closes[i-r.days]; prev > 0 {
+			out[i] = closes[i]/prev - 1
+		}
+	}
+	return out
+}
+
+// volatilityIndicator is the annualized standard deviation of the daily log
+// returns over days; every return of the window has to be known.
+type volatilityIndicator struct{ days int }
+
+func (v volatilityIndicator) Name() string  { return fmt.Sprintf("volatility_%dd", v.days) }
+func (v volatilityIndicator) Lookback() int { return v.days }
+
+func (v volatilityIndicator) Compute(closes []float64) []float64 {
+	rets := nanSeries(len(closes))
+	for i := 1; i < len(closes); i++ {
+		if closes[i-1] > 0 && closes[i] > 0 {
+			rets[i] = math.Log(closes[i] / closes[i-1])
+		}
+	}
+
+	out := nanSeries(len(closes))
+	var sum, sumSq float64
+	valid := 0
+	for i, r := range rets {
+		if !math.IsNaN(r) {
+			sum += r
+			sumSq += r * r
+			valid++
+		}
+		if j := i - v.days; j >= 0 && !math.IsNaN(rets[j]) {
+			sum -= rets[j]
+			sumSq -= rets[j] * rets[j]
+			valid--
+		}
+		if i >= v.days && valid == v.days {
+			n := float64(v.days)
+			variance := (sumSq - sum*sum/n) / (n - 1)
+			out[i] = math.Sqrt(math.Max(variance, 0) * 365)
+		}
+	}
+	return out
+}
+
+// smaIndicator is the simple moving average over days; every close of the
+// window has to be known.
+type smaIndicator struct{ days int }
+
+func (s smaIndicator) Name() string  { return fmt.Sprintf("sma_%d", s.days) }
+func (s smaIndicator) Lookback() int { return s.days - 1 }
+
+func (s smaIndicator) Compute(closes []float64) []float64 {
+	out := nanSeries(len(closes))
+	var sum float64
+	valid := 0
+	for i, c := range closes {
+		if !math.IsNaN(c) {
+			sum += c
+			valid++
+		}
+		if j := i - s.days; j >= 0 && !math.IsNaN(closes[j]) {
+			sum -= closes[j]
+			valid--
+		}
+		if valid == s.days {
+			out[i] = sum / float64(s.days)
+		}
+	}
+	return out
+}
+
+// emaIndicator is the exponential moving average with alpha 2/(days+1),
+// seeded with the first close. Its lookback is a warm-up long enough for the
+// seed to no longer matter, so recomputing a tail gives the same values as
+// the full history.
+type emaIndicator struct{ days int }
+
+func (e emaIndicator) Name() string  { return fmt.Sprintf("ema_%d", e.days) }
+func (e emaIndicator) Lookback() int { return 10 * e.days }
+
+func (e emaIndicator) Compute(closes []float64) []float64 {
+	out := nanSeries(len(closes))
+	alpha := 2 / float64(e.days+1)
+	var ema float64
+	seen := 0
+	for i, c := range closes {
+		if math.IsNaN(c) {
+			continue
+		}
+		if seen == 0 {
+			ema = c
+		} else {
+			ema += alpha * (c - ema)
+		}
+		seen++
+		if seen >= e.days {
+			out[i] = ema
+		}
+	}
+	return out
+}
+
+// drawdownIndicator is the distance of the close below the highest close of
+// the last days, from 0 at a new high down to -1.
+type drawdownIndicator struct{ days int }
+
+func (d drawdownIndicator) Name() string  { return fmt.Sprintf("drawdown_%dd", d.days) }
+func (d drawdownIndicator) Lookback() int { return d.days - 1 }
+
+func (d drawdownIndicator) Compute(closes []float64) []float64 {
+	out := nanSeries(len(closes))
+	var window []int // indexes of decreasing closes, the window max first
+	for i, c := range closes {
+		if len(window) > 0 && window[0] <= i-d.days {
+			window = window[1:]
+		}
+		if math.IsNaN(c) {
+			continue
+		}
+		for len(window) > 0 && closes[window[len(window)-1]] <= c {
+			window = window[:len(window)-1]
+		}
+		window = append(window, i)
+		if hi := closes[window[0]]; hi > 0 {
+			out[i] = c/hi - 1
+		}
+	}
+	return out
+}
+
+// indicatorDays collects per coin and currency the range of days stored
+// since the indicators were last computed; they are recomputed from its
+// first day.
+var indicatorDays = &dayTracker{name: "indicators", ranged: true}
+
+// computeIndicators recomputes the enabled indicators of one coin from day
+// from on, reading as much history before it as the indicators look back.
+func computeIndicators(ctx context.Context, cfg Config, db *sql.DB, id, vs string, from time.Time) (int, error) {
+	inds := enabledIndicators(cfg)
+	if len(inds) == 0 {
+		return 0, nil
+	}
+	lookback := 0
+	for _, ind := range inds {
+		lookback = max(lookback, ind.Lookback())
+	}
+
+	var pts []DailyPoint
+	err := streamSeries(ctx, db, cfg.CHTable, id, vs, from.AddDate(0, 0, -lookback), dateOnlyUTC(time.Now()), func(p DailyPoint) error {
+		pts = append(pts, p)
+		return nil
+	})
+	if err != nil || len(pts) == 0 {
+		return 0, err
+	}
+
+	first := dateOnlyUTC(pts[0].Timestamp)
+	closes := nanSeries(daysBetween(first, dateOnlyUTC(pts[len(pts)-1].Timestamp)) + 1)
+	for _, p := range pts {
+		closes[daysBetween(first, dateOnlyUTC(p.Timestamp))] = p.Price
+	}
+	start := max(daysBetween(first, dateOnlyUTC(from)), 0)
+	if start >= len(closes) {
+		return 0, nil
+	}
+
+	var values []IndicatorValue
+	for _, ind := range inds {
+		for i, v := range ind.Compute(closes)[start:] {
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				continue
+			}
+			values = append(values, IndicatorValue{
+				Date:       first.AddDate(0, 0, start+i),
+				ID:         id,
+				VsCurrency: vs,
+				Indicator:  ind.Name(),
+				Value:      v,
+			})
+		}
+	}
+
+	n := len(values)
+	for len(values) > 0 {
+		batch := values[:min(len(values), indicatorInsertBatch)]
+		if err := insertIndicators(ctx, db, cfg.CHIndicatorsTable, batch); err != nil {
+			return 0, err
+		}
+		metricIndicatorValues.Add(float64(len(batch)))
+		values = values[len(batch):]
+	}
+	return n, nil
+}
+
+// refreshIndicators recomputes the indicators of every coin from its first
+// newly stored day.
+func (a *app) refreshIndicators(ctx context.Context) {
+	cfg := a.config()
+	pending, takenAt := indicatorDays.Take()
+	defer indicatorDays.Clear(ctx, takenAt)
+	if len(pending) == 0 || len(enabledIndicators(cfg)) == 0 {
+		return
+	}
+
+	start := time.Now()
+	coins, values, failed := 0, 0, 0
+	for key, days := range pending {
+		id, vs, _ := strings.Cut(key, "|")
+		n, err := computeIndicators(ctx, cfg, a.db, id, vs, days[0])
+		if err != nil {
+			indicatorDays.Add(key, days[0])
+			failed++
+			if ctx.Err() == nil {
+				log.WithFields(log.Fields{"id": id, "vs": vs}).Warnf("indicator refresh failed: %v", err)
+			}
+			continue
+		}
+		coins++
+		values += n
+	}
+	log.WithFields(log.Fields{
+		"coins":   coins,
+		"values":  values,
+		"failed":  failed,
+		"elapsed": time.Since(start).Round(time.Millisecond),
+	}).Info("indicators refreshed")
+}
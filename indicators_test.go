@@ -0,0 +1,101 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+// closeEnough reports whether a and b are both NaN or differ by at most a
+// millionth of their size.
+func closeEnough(a, b float64) bool {
+	if math.IsNaN(a) || math.IsNaN(b) {
+		return math.IsNaN(a) && math.IsNaN(b)
+	}
+	return math.Abs(a-b) <= 1e-6*math.Max(1, math.Abs(b))
+}
+
+func TestIndicatorCompute(t *testing.T) {
+	nan := math.NaN()
+	tests := []struct {
+		name   string
+		ind    Indicator
+		closes []float64
+		want   []float64
+	}{
+		{
+			name:   "volatility",
+			ind:    volatilityIndicator{2},
+			closes: []float64{1, 2, 4, 2},
+			want:   []float64{nan, nan, 0, math.Ln2 * math.Sqrt(730)},
+		},
+		{
+			name:   "volatility needs every return of the window",
+			ind:    volatilityIndicator{2},
+			closes: []float64{1, 2, nan, 4, 8, 16},
+			want:   []float64{nan, nan, nan, nan, nan, 0},
+		},
+		{
+			name:   "ema",
+			ind:    emaIndicator{2},
+			closes: []float64{3, 6, 9},
+			want:   []float64{nan, 5, 23.0 / 3},
+		},
+		{
+			name:   "ema skips missing days",
+			ind:    emaIndicator{2},
+			closes: []float64{3, nan, 6, nan},
+			want:   []float64{nan, nan, 5, nan},
+		},
+		{
+			name:   "drawdown",
+			ind:    drawdownIndicator{3},
+			closes: []float64{10, 5, 8, 4, 2},
+			want:   []float64{0, -0.5, -0.2, -0.5, -0.75},
+		},
+		{
+			name:   "drawdown high leaves the window during a gap",
+			ind:    drawdownIndicator{3},
+			closes: []float64{10, nan, nan, 4, 5},
+			want:   []float64{0, nan, nan, 0, 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.ind.Compute(tt.closes)
+			if len(got) != len(tt.want) {
+				t.Fatalf("values = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if !closeEnough(got[i], tt.want[i]) {
+					t.Fatalf("values = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+// TestIndicatorTailRecompute checks that computing from Lookback days before
+// a day on, as computeIndicators does, gives the values of the full history.
+func TestIndicatorTailRecompute(t *testing.T) {
+	closes := make([]float64, 3000)
+	for i := range closes {
+		closes[i] = 100 * math.Exp(0.1*math.Sin(float64(i)/7)+0.0005*float64(i))
+		if i%97 == 0 {
+			closes[i] = math.NaN()
+		}
+	}
+	const from = 2000
+
+	for _, ind := range indicators {
+		t.Run(ind.Name(), func(t *testing.T) {
+			full := ind.Compute(closes)
+			start := from - ind.Lookback()
+			tail := ind.Compute(closes[start:])
+			for i := from; i < len(closes); i++ {
+				if !closeEnough(tail[i-start], full[i]) {
+					t.Fatalf("day %d: tail = %g, full = %g", i, tail[i-start], full[i])
+				}
+			}
+		})
+	}
+}
@@ -3,109 +3,33 @@ package main
 import (
 	"context"
 	"database/sql"
+	"fmt"
 	"os"
-	"os/signal"
 	"sort"
 	"strings"
-	"syscall"
 	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 func main() {
-	cfg := LoadConfig()
-
-	lvl, err := log.ParseLevel(cfg.LogLevel)
-	if err != nil {
-		lvl = log.InfoLevel
-	}
-	log.SetLevel(lvl)
-	log.SetFormatter(&log.TextFormatter{FullTimestamp: true})
-
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	go func() {
-		ch := make(chan os.Signal, 2)
-		signal.Notify(ch, syscall.SIGINT, syscall.SIGTERM)
-		<-ch
-		log.Warn("shutdown signal received")
-		cancel()
-	}()
-
-	cg := NewCGClient(cfg)
-
-	db, err := openClickHouse(ctx, cfg)
-	if err != nil {
-		log.Fatalf("clickhouse connect: %v", err)
-	}
-	defer db.Close()
-
-	if err := createTable(ctx, db, cfg.CHTable); err != nil {
-		log.Fatalf("create table: %v", err)
-	}
-
-	allCoins, activeCoinsAPI := fetchCoinsLists(ctx, cg, cfg)
-	log.WithFields(log.Fields{
-		"coins_total":  len(allCoins),
-		"active_total": len(activeCoinsAPI),
-	}).Info("coins list loaded")
-
-	tasksCh := make(chan Task, cfg.Workers*2)
-	resultsCh := make(chan TaskResult, cfg.Workers*4)
-
-	for i := 0; i < cfg.Workers; i++ {
-		go worker(ctx, i, cfg, cg, db, tasksCh, resultsCh)
-	}
-
-	activeDetected, err := RunBackfill(ctx, cfg, db, allCoins, tasksCh, resultsCh)
-	if err != nil {
-		log.Fatalf("backfill failed: %v", err)
-	}
-
-	activeCoins := activeCoinsAPI
-	if len(activeCoins) == 0 {
-
-		log.Warn("active coins list from API is empty; fallback to backfill-detected active coins")
-		activeCoins = coinFromIDMap(activeDetected)
-	}
-
-	log.WithField("active_coins_for_incremental", len(activeCoins)).Info("incremental target set")
-
-	ticker := time.NewTicker(cfg.SyncEvery)
-	defer ticker.Stop()
-
-	for {
-		select {
-		case <-ctx.Done():
-			log.Info("exit")
-			return
-		default:
-		}
-
-		runIncrementalOnce(ctx, cfg, db, activeCoins, tasksCh, resultsCh)
-
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-		}
-	}
+	os.Exit(runCLI(os.Args[1:]))
 }
 
-func fetchCoinsLists(ctx context.Context, cg *CGClient, cfg Config) (all []Coin, active []Coin) {
+func fetchCoinsLists(ctx context.Context, cg *CGClient, cfg Config) (all []Coin, active []Coin, err error) {
 
-	act, stA, bA, err := cg.CoinsList(ctx, "")
-	if err != nil {
-		log.WithField("status", stA).Warnf("coins/list active(default) failed: %v; body=%s", err, truncate(bA, 300))
+	act, stA, bA, errA := cg.CoinsList(ctx, "")
+	if errA != nil {
+		log.WithField("status", stA).Warnf("coins/list active(default) failed: %v; body=%s", errA, truncate(bA, 300))
 		act = nil
+		err = errA
 	}
 
-	inact, stI, bI, err := cg.CoinsList(ctx, "inactive")
-	if err != nil {
-		log.WithField("status", stI).Warnf("coins/list inactive failed: %v; body=%s", err, truncate(bI, 300))
+	inact, stI, bI, errI := cg.CoinsList(ctx, "inactive")
+	if errI != nil {
+		log.WithField("status", stI).Warnf("coins/list inactive failed: %v; body=%s", errI, truncate(bI, 300))
 		inact = nil
+		err = errI
 	}
 
 	activeByID := make(map[string]Coin, len(act))
@@ -159,7 +83,7 @@ func fetchCoinsLists(ctx context.Context, cg *CGClient, cfg Config) (all []Coin,
 		active = append(active, c)
 	}
 
-	return all, active
+	return all, active, err
 }
 
 func runIncrementalOnce(
@@ -169,10 +93,12 @@ func runIncrementalOnce(
 	activeCoins []Coin,
 	tasksCh chan<- Task,
 	resultsCh <-chan TaskResult,
-) {
+) error {
+	status.SetPhase(string(PhaseIncremental))
+
 	if len(activeCoins) == 0 {
 		log.Warn("incremental: no active coins")
-		return
+		return nil
 	}
 
 	maxDates := make(map[string]time.Time, len(activeCoins))
@@ -181,7 +107,7 @@ func runIncrementalOnce(
 		if id == "" {
 			continue
 		}
-		md, ok, err := getMaxDate(ctx, db, cfg.CHTable, id)
+		md, ok, err := getMaxDate(ctx, db, cfg.CHTable, id, cfg.VsCurrency)
 		if err != nil {
 			log.WithField("id", id).Warnf("max date query failed: %v", err)
 			continue
@@ -192,70 +118,75 @@ func runIncrementalOnce(
 	}
 
 	tasks := BuildIncrementalTasks(cfg, activeCoins, maxDates)
-	if len(tasks) == 0 {
+	revisions := BuildRevisionTasks(cfg, activeCoins, maxDates)
+	if len(tasks) == 0 && len(revisions) == 0 {
 		log.Info("incremental: nothing to do")
-		return
+		metricLastIncremental.SetToCurrentTime()
+		status.IncrementalDone(time.Now())
+		return nil
 	}
 
 	log.WithFields(log.Fields{
-		"tasks":  len(tasks),
-		"active": len(activeCoins),
+		"tasks":     len(tasks),
+		"revisions": len(revisions),
+		"active":    len(activeCoins),
 	}).Info("incremental started")
 
-	pending := append([]Task{}, tasks...)
-	inFlight := 0
-
-	for len(pending) > 0 || inFlight > 0 {
-		select {
-		case <-ctx.Done():
-			return
-
-		case res := <-resultsCh:
-			inFlight--
-			if res.Err != "" {
-				log.WithFields(log.Fields{
-					"id":     res.Task.CoinID,
-					"symbol": res.Task.Symbol,
-					"from":   formatDate(res.Task.From),
-					"to":     formatDate(res.Task.To),
-				}).Warnf("incremental task error: %s", res.Err)
-
-				if res.Task.Retry < cfg.MaxRetriesPerBlock {
-					rt := res.Task
-					rt.Retry++
-					pending = append([]Task{rt}, pending...)
-				}
-				continue
-			}
-
-			if len(res.MissingDates) > 0 && res.Task.Retry < cfg.MaxRetriesPerBlock {
+	sumRevised := 0
+	sumQuarantined := 0
+	failed := 0
+	run := startRun(ctx, cfg, db, string(PhaseIncremental))
+	err := runTasks(ctx, append(tasks, revisions...), tasksCh, resultsCh, func(res TaskResult) []Task {
+		run.record(ctx, res)
+		sumRevised += res.Revised
+		sumQuarantined += res.Quarantined
+		if res.Err != "" {
+			log.WithFields(log.Fields{
+				"id":     res.Task.CoinID,
+				"symbol": res.Task.Symbol,
+				"from":   formatDate(res.Task.From),
+				"to":     formatDate(res.Task.To),
+			}).Warnf("incremental task error: %s", res.Err)
+
+			if res.Task.Retry < cfg.MaxRetriesPerBlock {
 				rt := res.Task
 				rt.Retry++
-				pending = append([]Task{rt}, pending...)
-				continue
-			}
-
-		default:
-			var outCh chan<- Task
-			var next Task
-			if len(pending) > 0 {
-				outCh = tasksCh
-				next = pending[0]
+				return []Task{rt}
 			}
+			failed++
+			return nil
+		}
 
-			select {
-			case <-ctx.Done():
-				return
-			case outCh <- next:
-				pending = pending[1:]
-				inFlight++
-			default:
-				time.Sleep(10 * time.Millisecond)
-			}
+		if len(res.MissingDates) > 0 && res.Task.Retry < cfg.MaxRetriesPerBlock {
+			rt := res.Task
+			rt.Retry++
+			return []Task{rt}
 		}
+		return nil
+	})
+	run.finish(ctx, err)
+	if err != nil {
+		return err
 	}
 
-	log.Info("incremental finished")
+	if failed == 0 {
+		metricLastIncremental.SetToCurrentTime()
+		status.IncrementalDone(time.Now())
+	}
+	if err := updateSyncLag(ctx, db, cfg.CHTable); err != nil {
+		log.Warnf("sync lag query failed: %v", err)
+	}
+
+	log.WithFields(log.Fields{
+		"revised_days":     sumRevised,
+		"quarantined_days": sumQuarantined,
+		"failed":           failed,
+	}).Info("incremental finished")
+
+	if failed > 0 {
+		return fmt.Errorf("incremental: %w: %d tasks", errTasksFailed, failed)
+	}
+	return nil
 }
 
 func coinFromIDMap(m map[string]Coin) []Coin {
@@ -0,0 +1,1327 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.8
+// 	protoc        v5.29.3
+// source: marketdata.proto
+
+package marketpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type DailyPoint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Date          string                 `protobuf:"bytes,1,opt,name=date,proto3" json:"date,omitempty"` // YYYY-MM-DD
+	Id            string                 `protobuf:"bytes,2,opt,name=id,proto3" json:"id,omitempty"`
+	Symbol        string                 `protobuf:"bytes,3,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	VsCurrency    string                 `protobuf:"bytes,4,opt,name=vs_currency,json=vsCurrency,proto3" json:"vs_currency,omitempty"`
+	Timestamp     *timestamppb.Timestamp `protobuf:"bytes,5,opt,name=timestamp,proto3" json:"timestamp,omitempty"`
+	Price         float64                `protobuf:"fixed64,6,opt,name=price,proto3" json:"price,omitempty"`
+	MarketCap     float64                `protobuf:"fixed64,7,opt,name=market_cap,json=marketCap,proto3" json:"market_cap,omitempty"`
+	Volume        float64                `protobuf:"fixed64,8,opt,name=volume,proto3" json:"volume,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *DailyPoint) Reset() {
+	*x = DailyPoint{}
+	mi := &file_marketdata_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *DailyPoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DailyPoint) ProtoMessage() {}
+
+func (x *DailyPoint) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DailyPoint.ProtoReflect.Descriptor instead.
+func (*DailyPoint) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *DailyPoint) GetDate() string {
+	if x != nil {
+		return x.Date
+	}
+	return ""
+}
+
+func (x *DailyPoint) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *DailyPoint) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *DailyPoint) GetVsCurrency() string {
+	if x != nil {
+		return x.VsCurrency
+	}
+	return ""
+}
+
+func (x *DailyPoint) GetTimestamp() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Timestamp
+	}
+	return nil
+}
+
+func (x *DailyPoint) GetPrice() float64 {
+	if x != nil {
+		return x.Price
+	}
+	return 0
+}
+
+func (x *DailyPoint) GetMarketCap() float64 {
+	if x != nil {
+		return x.MarketCap
+	}
+	return 0
+}
+
+func (x *DailyPoint) GetVolume() float64 {
+	if x != nil {
+		return x.Volume
+	}
+	return 0
+}
+
+type GetSeriesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	VsCurrency    string                 `protobuf:"bytes,2,opt,name=vs_currency,json=vsCurrency,proto3" json:"vs_currency,omitempty"` // default: COINGECKO_VS_CURRENCY
+	From          string                 `protobuf:"bytes,3,opt,name=from,proto3" json:"from,omitempty"`                               // YYYY-MM-DD, default: START_DATE
+	To            string                 `protobuf:"bytes,4,opt,name=to,proto3" json:"to,omitempty"`                                   // YYYY-MM-DD, default: yesterday
+	Limit         int32                  `protobuf:"varint,5,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset        int32                  `protobuf:"varint,6,opt,name=offset,proto3" json:"offset,omitempty"`
+	Resolution    string                 `protobuf:"bytes,7,opt,name=resolution,proto3" json:"resolution,omitempty"` // day, week or month, default: day
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSeriesRequest) Reset() {
+	*x = GetSeriesRequest{}
+	mi := &file_marketdata_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSeriesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSeriesRequest) ProtoMessage() {}
+
+func (x *GetSeriesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSeriesRequest.ProtoReflect.Descriptor instead.
+func (*GetSeriesRequest) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *GetSeriesRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *GetSeriesRequest) GetVsCurrency() string {
+	if x != nil {
+		return x.VsCurrency
+	}
+	return ""
+}
+
+func (x *GetSeriesRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *GetSeriesRequest) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+func (x *GetSeriesRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *GetSeriesRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+func (x *GetSeriesRequest) GetResolution() string {
+	if x != nil {
+		return x.Resolution
+	}
+	return ""
+}
+
+// RollupPoint is one week or month of a coin; date is the first day of the
+// period.
+type RollupPoint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Date          string                 `protobuf:"bytes,1,opt,name=date,proto3" json:"date,omitempty"`
+	FirstPrice    float64                `protobuf:"fixed64,2,opt,name=first_price,json=firstPrice,proto3" json:"first_price,omitempty"`
+	LastPrice     float64                `protobuf:"fixed64,3,opt,name=last_price,json=lastPrice,proto3" json:"last_price,omitempty"`
+	MinPrice      float64                `protobuf:"fixed64,4,opt,name=min_price,json=minPrice,proto3" json:"min_price,omitempty"`
+	MaxPrice      float64                `protobuf:"fixed64,5,opt,name=max_price,json=maxPrice,proto3" json:"max_price,omitempty"`
+	AvgPrice      float64                `protobuf:"fixed64,6,opt,name=avg_price,json=avgPrice,proto3" json:"avg_price,omitempty"`
+	MarketCap     float64                `protobuf:"fixed64,7,opt,name=market_cap,json=marketCap,proto3" json:"market_cap,omitempty"` // last of the period
+	Volume        float64                `protobuf:"fixed64,8,opt,name=volume,proto3" json:"volume,omitempty"`                        // sum over the period
+	Days          int32                  `protobuf:"varint,9,opt,name=days,proto3" json:"days,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RollupPoint) Reset() {
+	*x = RollupPoint{}
+	mi := &file_marketdata_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RollupPoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RollupPoint) ProtoMessage() {}
+
+func (x *RollupPoint) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RollupPoint.ProtoReflect.Descriptor instead.
+func (*RollupPoint) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *RollupPoint) GetDate() string {
+	if x != nil {
+		return x.Date
+	}
+	return ""
+}
+
+func (x *RollupPoint) GetFirstPrice() float64 {
+	if x != nil {
+		return x.FirstPrice
+	}
+	return 0
+}
+
+func (x *RollupPoint) GetLastPrice() float64 {
+	if x != nil {
+		return x.LastPrice
+	}
+	return 0
+}
+
+func (x *RollupPoint) GetMinPrice() float64 {
+	if x != nil {
+		return x.MinPrice
+	}
+	return 0
+}
+
+func (x *RollupPoint) GetMaxPrice() float64 {
+	if x != nil {
+		return x.MaxPrice
+	}
+	return 0
+}
+
+func (x *RollupPoint) GetAvgPrice() float64 {
+	if x != nil {
+		return x.AvgPrice
+	}
+	return 0
+}
+
+func (x *RollupPoint) GetMarketCap() float64 {
+	if x != nil {
+		return x.MarketCap
+	}
+	return 0
+}
+
+func (x *RollupPoint) GetVolume() float64 {
+	if x != nil {
+		return x.Volume
+	}
+	return 0
+}
+
+func (x *RollupPoint) GetDays() int32 {
+	if x != nil {
+		return x.Days
+	}
+	return 0
+}
+
+type GetSeriesResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Points        []*DailyPoint          `protobuf:"bytes,1,rep,name=points,proto3" json:"points,omitempty"` // resolution day
+	NextOffset    *int32                 `protobuf:"varint,2,opt,name=next_offset,json=nextOffset,proto3,oneof" json:"next_offset,omitempty"`
+	Rollups       []*RollupPoint         `protobuf:"bytes,3,rep,name=rollups,proto3" json:"rollups,omitempty"` // resolution week or month
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSeriesResponse) Reset() {
+	*x = GetSeriesResponse{}
+	mi := &file_marketdata_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSeriesResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSeriesResponse) ProtoMessage() {}
+
+func (x *GetSeriesResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSeriesResponse.ProtoReflect.Descriptor instead.
+func (*GetSeriesResponse) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *GetSeriesResponse) GetPoints() []*DailyPoint {
+	if x != nil {
+		return x.Points
+	}
+	return nil
+}
+
+func (x *GetSeriesResponse) GetNextOffset() int32 {
+	if x != nil && x.NextOffset != nil {
+		return *x.NextOffset
+	}
+	return 0
+}
+
+func (x *GetSeriesResponse) GetRollups() []*RollupPoint {
+	if x != nil {
+		return x.Rollups
+	}
+	return nil
+}
+
+type StreamSeriesRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	VsCurrency    string                 `protobuf:"bytes,2,opt,name=vs_currency,json=vsCurrency,proto3" json:"vs_currency,omitempty"`
+	From          string                 `protobuf:"bytes,3,opt,name=from,proto3" json:"from,omitempty"`
+	To            string                 `protobuf:"bytes,4,opt,name=to,proto3" json:"to,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamSeriesRequest) Reset() {
+	*x = StreamSeriesRequest{}
+	mi := &file_marketdata_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamSeriesRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamSeriesRequest) ProtoMessage() {}
+
+func (x *StreamSeriesRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamSeriesRequest.ProtoReflect.Descriptor instead.
+func (*StreamSeriesRequest) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *StreamSeriesRequest) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *StreamSeriesRequest) GetVsCurrency() string {
+	if x != nil {
+		return x.VsCurrency
+	}
+	return ""
+}
+
+func (x *StreamSeriesRequest) GetFrom() string {
+	if x != nil {
+		return x.From
+	}
+	return ""
+}
+
+func (x *StreamSeriesRequest) GetTo() string {
+	if x != nil {
+		return x.To
+	}
+	return ""
+}
+
+type GetSnapshotRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Date          string                 `protobuf:"bytes,1,opt,name=date,proto3" json:"date,omitempty"` // YYYY-MM-DD, default: yesterday
+	VsCurrency    string                 `protobuf:"bytes,2,opt,name=vs_currency,json=vsCurrency,proto3" json:"vs_currency,omitempty"`
+	Limit         int32                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset        int32                  `protobuf:"varint,4,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSnapshotRequest) Reset() {
+	*x = GetSnapshotRequest{}
+	mi := &file_marketdata_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSnapshotRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSnapshotRequest) ProtoMessage() {}
+
+func (x *GetSnapshotRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSnapshotRequest.ProtoReflect.Descriptor instead.
+func (*GetSnapshotRequest) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *GetSnapshotRequest) GetDate() string {
+	if x != nil {
+		return x.Date
+	}
+	return ""
+}
+
+func (x *GetSnapshotRequest) GetVsCurrency() string {
+	if x != nil {
+		return x.VsCurrency
+	}
+	return ""
+}
+
+func (x *GetSnapshotRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *GetSnapshotRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type RankedPoint struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Rank          int32                  `protobuf:"varint,1,opt,name=rank,proto3" json:"rank,omitempty"`
+	Point         *DailyPoint            `protobuf:"bytes,2,opt,name=point,proto3" json:"point,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *RankedPoint) Reset() {
+	*x = RankedPoint{}
+	mi := &file_marketdata_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *RankedPoint) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RankedPoint) ProtoMessage() {}
+
+func (x *RankedPoint) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RankedPoint.ProtoReflect.Descriptor instead.
+func (*RankedPoint) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *RankedPoint) GetRank() int32 {
+	if x != nil {
+		return x.Rank
+	}
+	return 0
+}
+
+func (x *RankedPoint) GetPoint() *DailyPoint {
+	if x != nil {
+		return x.Point
+	}
+	return nil
+}
+
+type GetSnapshotResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Date          string                 `protobuf:"bytes,1,opt,name=date,proto3" json:"date,omitempty"`
+	Points        []*RankedPoint         `protobuf:"bytes,2,rep,name=points,proto3" json:"points,omitempty"`
+	NextOffset    *int32                 `protobuf:"varint,3,opt,name=next_offset,json=nextOffset,proto3,oneof" json:"next_offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetSnapshotResponse) Reset() {
+	*x = GetSnapshotResponse{}
+	mi := &file_marketdata_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetSnapshotResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetSnapshotResponse) ProtoMessage() {}
+
+func (x *GetSnapshotResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetSnapshotResponse.ProtoReflect.Descriptor instead.
+func (*GetSnapshotResponse) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{7}
+}
+
+func (x *GetSnapshotResponse) GetDate() string {
+	if x != nil {
+		return x.Date
+	}
+	return ""
+}
+
+func (x *GetSnapshotResponse) GetPoints() []*RankedPoint {
+	if x != nil {
+		return x.Points
+	}
+	return nil
+}
+
+func (x *GetSnapshotResponse) GetNextOffset() int32 {
+	if x != nil && x.NextOffset != nil {
+		return *x.NextOffset
+	}
+	return 0
+}
+
+type ListCoinsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Active        *bool                  `protobuf:"varint,1,opt,name=active,proto3,oneof" json:"active,omitempty"`
+	VsCurrency    string                 `protobuf:"bytes,2,opt,name=vs_currency,json=vsCurrency,proto3" json:"vs_currency,omitempty"` // currency of the stored date ranges
+	Limit         int32                  `protobuf:"varint,3,opt,name=limit,proto3" json:"limit,omitempty"`
+	Offset        int32                  `protobuf:"varint,4,opt,name=offset,proto3" json:"offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListCoinsRequest) Reset() {
+	*x = ListCoinsRequest{}
+	mi := &file_marketdata_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListCoinsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListCoinsRequest) ProtoMessage() {}
+
+func (x *ListCoinsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListCoinsRequest.ProtoReflect.Descriptor instead.
+func (*ListCoinsRequest) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *ListCoinsRequest) GetActive() bool {
+	if x != nil && x.Active != nil {
+		return *x.Active
+	}
+	return false
+}
+
+func (x *ListCoinsRequest) GetVsCurrency() string {
+	if x != nil {
+		return x.VsCurrency
+	}
+	return ""
+}
+
+func (x *ListCoinsRequest) GetLimit() int32 {
+	if x != nil {
+		return x.Limit
+	}
+	return 0
+}
+
+func (x *ListCoinsRequest) GetOffset() int32 {
+	if x != nil {
+		return x.Offset
+	}
+	return 0
+}
+
+type Coin struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Id            string                 `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Symbol        string                 `protobuf:"bytes,2,opt,name=symbol,proto3" json:"symbol,omitempty"`
+	Name          string                 `protobuf:"bytes,3,opt,name=name,proto3" json:"name,omitempty"`
+	Active        bool                   `protobuf:"varint,4,opt,name=active,proto3" json:"active,omitempty"`
+	FirstDate     string                 `protobuf:"bytes,5,opt,name=first_date,json=firstDate,proto3" json:"first_date,omitempty"` // empty when nothing is stored
+	LastDate      string                 `protobuf:"bytes,6,opt,name=last_date,json=lastDate,proto3" json:"last_date,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Coin) Reset() {
+	*x = Coin{}
+	mi := &file_marketdata_proto_msgTypes[9]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Coin) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Coin) ProtoMessage() {}
+
+func (x *Coin) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[9]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Coin.ProtoReflect.Descriptor instead.
+func (*Coin) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{9}
+}
+
+func (x *Coin) GetId() string {
+	if x != nil {
+		return x.Id
+	}
+	return ""
+}
+
+func (x *Coin) GetSymbol() string {
+	if x != nil {
+		return x.Symbol
+	}
+	return ""
+}
+
+func (x *Coin) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *Coin) GetActive() bool {
+	if x != nil {
+		return x.Active
+	}
+	return false
+}
+
+func (x *Coin) GetFirstDate() string {
+	if x != nil {
+		return x.FirstDate
+	}
+	return ""
+}
+
+func (x *Coin) GetLastDate() string {
+	if x != nil {
+		return x.LastDate
+	}
+	return ""
+}
+
+type ListCoinsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Coins         []*Coin                `protobuf:"bytes,1,rep,name=coins,proto3" json:"coins,omitempty"`
+	NextOffset    *int32                 `protobuf:"varint,2,opt,name=next_offset,json=nextOffset,proto3,oneof" json:"next_offset,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *ListCoinsResponse) Reset() {
+	*x = ListCoinsResponse{}
+	mi := &file_marketdata_proto_msgTypes[10]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *ListCoinsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ListCoinsResponse) ProtoMessage() {}
+
+func (x *ListCoinsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[10]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ListCoinsResponse.ProtoReflect.Descriptor instead.
+func (*ListCoinsResponse) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{10}
+}
+
+func (x *ListCoinsResponse) GetCoins() []*Coin {
+	if x != nil {
+		return x.Coins
+	}
+	return nil
+}
+
+func (x *ListCoinsResponse) GetNextOffset() int32 {
+	if x != nil && x.NextOffset != nil {
+		return *x.NextOffset
+	}
+	return 0
+}
+
+type GetIngestionStatusRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetIngestionStatusRequest) Reset() {
+	*x = GetIngestionStatusRequest{}
+	mi := &file_marketdata_proto_msgTypes[11]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetIngestionStatusRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetIngestionStatusRequest) ProtoMessage() {}
+
+func (x *GetIngestionStatusRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[11]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetIngestionStatusRequest.ProtoReflect.Descriptor instead.
+func (*GetIngestionStatusRequest) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{11}
+}
+
+type Run struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Kind          string                 `protobuf:"bytes,1,opt,name=kind,proto3" json:"kind,omitempty"`
+	Status        string                 `protobuf:"bytes,2,opt,name=status,proto3" json:"status,omitempty"`
+	StartedAt     *timestamppb.Timestamp `protobuf:"bytes,3,opt,name=started_at,json=startedAt,proto3" json:"started_at,omitempty"`
+	FinishedAt    *timestamppb.Timestamp `protobuf:"bytes,4,opt,name=finished_at,json=finishedAt,proto3" json:"finished_at,omitempty"`
+	Tasks         int32                  `protobuf:"varint,5,opt,name=tasks,proto3" json:"tasks,omitempty"`
+	Inserted      int64                  `protobuf:"varint,6,opt,name=inserted,proto3" json:"inserted,omitempty"`
+	Errors        int32                  `protobuf:"varint,7,opt,name=errors,proto3" json:"errors,omitempty"`
+	Error         string                 `protobuf:"bytes,8,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *Run) Reset() {
+	*x = Run{}
+	mi := &file_marketdata_proto_msgTypes[12]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Run) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Run) ProtoMessage() {}
+
+func (x *Run) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[12]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Run.ProtoReflect.Descriptor instead.
+func (*Run) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{12}
+}
+
+func (x *Run) GetKind() string {
+	if x != nil {
+		return x.Kind
+	}
+	return ""
+}
+
+func (x *Run) GetStatus() string {
+	if x != nil {
+		return x.Status
+	}
+	return ""
+}
+
+func (x *Run) GetStartedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.StartedAt
+	}
+	return nil
+}
+
+func (x *Run) GetFinishedAt() *timestamppb.Timestamp {
+	if x != nil {
+		return x.FinishedAt
+	}
+	return nil
+}
+
+func (x *Run) GetTasks() int32 {
+	if x != nil {
+		return x.Tasks
+	}
+	return 0
+}
+
+func (x *Run) GetInserted() int64 {
+	if x != nil {
+		return x.Inserted
+	}
+	return 0
+}
+
+func (x *Run) GetErrors() int32 {
+	if x != nil {
+		return x.Errors
+	}
+	return 0
+}
+
+func (x *Run) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type SyncLag struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	VsCurrency    string                 `protobuf:"bytes,1,opt,name=vs_currency,json=vsCurrency,proto3" json:"vs_currency,omitempty"`
+	LatestDate    string                 `protobuf:"bytes,2,opt,name=latest_date,json=latestDate,proto3" json:"latest_date,omitempty"`
+	LagDays       int32                  `protobuf:"varint,3,opt,name=lag_days,json=lagDays,proto3" json:"lag_days,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *SyncLag) Reset() {
+	*x = SyncLag{}
+	mi := &file_marketdata_proto_msgTypes[13]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *SyncLag) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SyncLag) ProtoMessage() {}
+
+func (x *SyncLag) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[13]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SyncLag.ProtoReflect.Descriptor instead.
+func (*SyncLag) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{13}
+}
+
+func (x *SyncLag) GetVsCurrency() string {
+	if x != nil {
+		return x.VsCurrency
+	}
+	return ""
+}
+
+func (x *SyncLag) GetLatestDate() string {
+	if x != nil {
+		return x.LatestDate
+	}
+	return ""
+}
+
+func (x *SyncLag) GetLagDays() int32 {
+	if x != nil {
+		return x.LagDays
+	}
+	return 0
+}
+
+type IngestionStatus struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	LatestRuns    []*Run                 `protobuf:"bytes,1,rep,name=latest_runs,json=latestRuns,proto3" json:"latest_runs,omitempty"`
+	Lag           []*SyncLag             `protobuf:"bytes,2,rep,name=lag,proto3" json:"lag,omitempty"`
+	Phase         string                 `protobuf:"bytes,3,opt,name=phase,proto3" json:"phase,omitempty"`
+	Round         int32                  `protobuf:"varint,4,opt,name=round,proto3" json:"round,omitempty"`
+	ActiveCoins   int32                  `protobuf:"varint,5,opt,name=active_coins,json=activeCoins,proto3" json:"active_coins,omitempty"`
+	Heartbeat     *timestamppb.Timestamp `protobuf:"bytes,6,opt,name=heartbeat,proto3" json:"heartbeat,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *IngestionStatus) Reset() {
+	*x = IngestionStatus{}
+	mi := &file_marketdata_proto_msgTypes[14]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *IngestionStatus) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*IngestionStatus) ProtoMessage() {}
+
+func (x *IngestionStatus) ProtoReflect() protoreflect.Message {
+	mi := &file_marketdata_proto_msgTypes[14]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use IngestionStatus.ProtoReflect.Descriptor instead.
+func (*IngestionStatus) Descriptor() ([]byte, []int) {
+	return file_marketdata_proto_rawDescGZIP(), []int{14}
+}
+
+func (x *IngestionStatus) GetLatestRuns() []*Run {
+	if x != nil {
+		return x.LatestRuns
+	}
+	return nil
+}
+
+func (x *IngestionStatus) GetLag() []*SyncLag {
+	if x != nil {
+		return x.Lag
+	}
+	return nil
+}
+
+func (x *IngestionStatus) GetPhase() string {
+	if x != nil {
+		return x.Phase
+	}
+	return ""
+}
+
+func (x *IngestionStatus) GetRound() int32 {
+	if x != nil {
+		return x.Round
+	}
+	return 0
+}
+
+func (x *IngestionStatus) GetActiveCoins() int32 {
+	if x != nil {
+		return x.ActiveCoins
+	}
+	return 0
+}
+
+func (x *IngestionStatus) GetHeartbeat() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Heartbeat
+	}
+	return nil
+}
+
+var File_marketdata_proto protoreflect.FileDescriptor
+
+const file_marketdata_proto_rawDesc = "" +
+	"\n" +
+	"\x10marketdata.proto\x12\x13cgetl.marketdata.v1\x1a\x1fgoogle/protobuf/timestamp.proto\"\xf0\x01\n" +
+	"\n" +
+	"DailyPoint\x12\x12\n" +
+	"\x04date\x18\x01 \x01(\tR\x04date\x12\x0e\n" +
+	"\x02id\x18\x02 \x01(\tR\x02id\x12\x16\n" +
+	"\x06symbol\x18\x03 \x01(\tR\x06symbol\x12\x1f\n" +
+	"\vvs_currency\x18\x04 \x01(\tR\n" +
+	"vsCurrency\x128\n" +
+	"\ttimestamp\x18\x05 \x01(\v2\x1a.google.protobuf.TimestampR\ttimestamp\x12\x14\n" +
+	"\x05price\x18\x06 \x01(\x01R\x05price\x12\x1d\n" +
+	"\n" +
+	"market_cap\x18\a \x01(\x01R\tmarketCap\x12\x16\n" +
+	"\x06volume\x18\b \x01(\x01R\x06volume\"\xb5\x01\n" +
+	"\x10GetSeriesRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1f\n" +
+	"\vvs_currency\x18\x02 \x01(\tR\n" +
+	"vsCurrency\x12\x12\n" +
+	"\x04from\x18\x03 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x04 \x01(\tR\x02to\x12\x14\n" +
+	"\x05limit\x18\x05 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x06 \x01(\x05R\x06offset\x12\x1e\n" +
+	"\n" +
+	"resolution\x18\a \x01(\tR\n" +
+	"resolution\"\x83\x02\n" +
+	"\vRollupPoint\x12\x12\n" +
+	"\x04date\x18\x01 \x01(\tR\x04date\x12\x1f\n" +
+	"\vfirst_price\x18\x02 \x01(\x01R\n" +
+	"firstPrice\x12\x1d\n" +
+	"\n" +
+	"last_price\x18\x03 \x01(\x01R\tlastPrice\x12\x1b\n" +
+	"\tmin_price\x18\x04 \x01(\x01R\bminPrice\x12\x1b\n" +
+	"\tmax_price\x18\x05 \x01(\x01R\bmaxPrice\x12\x1b\n" +
+	"\tavg_price\x18\x06 \x01(\x01R\bavgPrice\x12\x1d\n" +
+	"\n" +
+	"market_cap\x18\a \x01(\x01R\tmarketCap\x12\x16\n" +
+	"\x06volume\x18\b \x01(\x01R\x06volume\x12\x12\n" +
+	"\x04days\x18\t \x01(\x05R\x04days\"\xbe\x01\n" +
+	"\x11GetSeriesResponse\x127\n" +
+	"\x06points\x18\x01 \x03(\v2\x1f.cgetl.marketdata.v1.DailyPointR\x06points\x12$\n" +
+	"\vnext_offset\x18\x02 \x01(\x05H\x00R\n" +
+	"nextOffset\x88\x01\x01\x12:\n" +
+	"\arollups\x18\x03 \x03(\v2 .cgetl.marketdata.v1.RollupPointR\arollupsB\x0e\n" +
+	"\f_next_offset\"j\n" +
+	"\x13StreamSeriesRequest\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x1f\n" +
+	"\vvs_currency\x18\x02 \x01(\tR\n" +
+	"vsCurrency\x12\x12\n" +
+	"\x04from\x18\x03 \x01(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x04 \x01(\tR\x02to\"w\n" +
+	"\x12GetSnapshotRequest\x12\x12\n" +
+	"\x04date\x18\x01 \x01(\tR\x04date\x12\x1f\n" +
+	"\vvs_currency\x18\x02 \x01(\tR\n" +
+	"vsCurrency\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x04 \x01(\x05R\x06offset\"X\n" +
+	"\vRankedPoint\x12\x12\n" +
+	"\x04rank\x18\x01 \x01(\x05R\x04rank\x125\n" +
+	"\x05point\x18\x02 \x01(\v2\x1f.cgetl.marketdata.v1.DailyPointR\x05point\"\x99\x01\n" +
+	"\x13GetSnapshotResponse\x12\x12\n" +
+	"\x04date\x18\x01 \x01(\tR\x04date\x128\n" +
+	"\x06points\x18\x02 \x03(\v2 .cgetl.marketdata.v1.RankedPointR\x06points\x12$\n" +
+	"\vnext_offset\x18\x03 \x01(\x05H\x00R\n" +
+	"nextOffset\x88\x01\x01B\x0e\n" +
+	"\f_next_offset\"\x89\x01\n" +
+	"\x10ListCoinsRequest\x12\x1b\n" +
+	"\x06active\x18\x01 \x01(\bH\x00R\x06active\x88\x01\x01\x12\x1f\n" +
+	"\vvs_currency\x18\x02 \x01(\tR\n" +
+	"vsCurrency\x12\x14\n" +
+	"\x05limit\x18\x03 \x01(\x05R\x05limit\x12\x16\n" +
+	"\x06offset\x18\x04 \x01(\x05R\x06offsetB\t\n" +
+	"\a_active\"\x96\x01\n" +
+	"\x04Coin\x12\x0e\n" +
+	"\x02id\x18\x01 \x01(\tR\x02id\x12\x16\n" +
+	"\x06symbol\x18\x02 \x01(\tR\x06symbol\x12\x12\n" +
+	"\x04name\x18\x03 \x01(\tR\x04name\x12\x16\n" +
+	"\x06active\x18\x04 \x01(\bR\x06active\x12\x1d\n" +
+	"\n" +
+	"first_date\x18\x05 \x01(\tR\tfirstDate\x12\x1b\n" +
+	"\tlast_date\x18\x06 \x01(\tR\blastDate\"z\n" +
+	"\x11ListCoinsResponse\x12/\n" +
+	"\x05coins\x18\x01 \x03(\v2\x19.cgetl.marketdata.v1.CoinR\x05coins\x12$\n" +
+	"\vnext_offset\x18\x02 \x01(\x05H\x00R\n" +
+	"nextOffset\x88\x01\x01B\x0e\n" +
+	"\f_next_offset\"\x1b\n" +
+	"\x19GetIngestionStatusRequest\"\x89\x02\n" +
+	"\x03Run\x12\x12\n" +
+	"\x04kind\x18\x01 \x01(\tR\x04kind\x12\x16\n" +
+	"\x06status\x18\x02 \x01(\tR\x06status\x129\n" +
+	"\n" +
+	"started_at\x18\x03 \x01(\v2\x1a.google.protobuf.TimestampR\tstartedAt\x12;\n" +
+	"\vfinished_at\x18\x04 \x01(\v2\x1a.google.protobuf.TimestampR\n" +
+	"finishedAt\x12\x14\n" +
+	"\x05tasks\x18\x05 \x01(\x05R\x05tasks\x12\x1a\n" +
+	"\binserted\x18\x06 \x01(\x03R\binserted\x12\x16\n" +
+	"\x06errors\x18\a \x01(\x05R\x06errors\x12\x14\n" +
+	"\x05error\x18\b \x01(\tR\x05error\"f\n" +
+	"\aSyncLag\x12\x1f\n" +
+	"\vvs_currency\x18\x01 \x01(\tR\n" +
+	"vsCurrency\x12\x1f\n" +
+	"\vlatest_date\x18\x02 \x01(\tR\n" +
+	"latestDate\x12\x19\n" +
+	"\blag_days\x18\x03 \x01(\x05R\alagDays\"\x85\x02\n" +
+	"\x0fIngestionStatus\x129\n" +
+	"\vlatest_runs\x18\x01 \x03(\v2\x18.cgetl.marketdata.v1.RunR\n" +
+	"latestRuns\x12.\n" +
+	"\x03lag\x18\x02 \x03(\v2\x1c.cgetl.marketdata.v1.SyncLagR\x03lag\x12\x14\n" +
+	"\x05phase\x18\x03 \x01(\tR\x05phase\x12\x14\n" +
+	"\x05round\x18\x04 \x01(\x05R\x05round\x12!\n" +
+	"\factive_coins\x18\x05 \x01(\x05R\vactiveCoins\x128\n" +
+	"\theartbeat\x18\x06 \x01(\v2\x1a.google.protobuf.TimestampR\theartbeat2\xef\x03\n" +
+	"\n" +
+	"MarketData\x12Z\n" +
+	"\tGetSeries\x12%.cgetl.marketdata.v1.GetSeriesRequest\x1a&.cgetl.marketdata.v1.GetSeriesResponse\x12[\n" +
+	"\fStreamSeries\x12(.cgetl.marketdata.v1.StreamSeriesRequest\x1a\x1f.cgetl.marketdata.v1.DailyPoint0\x01\x12`\n" +
+	"\vGetSnapshot\x12'.cgetl.marketdata.v1.GetSnapshotRequest\x1a(.cgetl.marketdata.v1.GetSnapshotResponse\x12Z\n" +
+	"\tListCoins\x12%.cgetl.marketdata.v1.ListCoinsRequest\x1a&.cgetl.marketdata.v1.ListCoinsResponse\x12j\n" +
+	"\x12GetIngestionStatus\x12..cgetl.marketdata.v1.GetIngestionStatusRequest\x1a$.cgetl.marketdata.v1.IngestionStatusB\x16Z\x14testProject/marketpbb\x06proto3"
+
+var (
+	file_marketdata_proto_rawDescOnce sync.Once
+	file_marketdata_proto_rawDescData []byte
+)
+
+func file_marketdata_proto_rawDescGZIP() []byte {
+	file_marketdata_proto_rawDescOnce.Do(func() {
+		file_marketdata_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_marketdata_proto_rawDesc), len(file_marketdata_proto_rawDesc)))
+	})
+	return file_marketdata_proto_rawDescData
+}
+
+var file_marketdata_proto_msgTypes = make([]protoimpl.MessageInfo, 15)
+var file_marketdata_proto_goTypes = []any{
+	(*DailyPoint)(nil),                // 0: cgetl.marketdata.v1.DailyPoint
+	(*GetSeriesRequest)(nil),          // 1: cgetl.marketdata.v1.GetSeriesRequest
+	(*RollupPoint)(nil),               // 2: cgetl.marketdata.v1.RollupPoint
+	(*GetSeriesResponse)(nil),         // 3: cgetl.marketdata.v1.GetSeriesResponse
+	(*StreamSeriesRequest)(nil),       // 4: cgetl.marketdata.v1.StreamSeriesRequest
+	(*GetSnapshotRequest)(nil),        // 5: cgetl.marketdata.v1.GetSnapshotRequest
+	(*RankedPoint)(nil),               // 6: cgetl.marketdata.v1.RankedPoint
+	(*GetSnapshotResponse)(nil),       // 7: cgetl.marketdata.v1.GetSnapshotResponse
+	(*ListCoinsRequest)(nil),          // 8: cgetl.marketdata.v1.ListCoinsRequest
+	(*Coin)(nil),                      // 9: cgetl.marketdata.v1.Coin
+	(*ListCoinsResponse)(nil),         // 10: cgetl.marketdata.v1.ListCoinsResponse
+	(*GetIngestionStatusRequest)(nil), // 11: cgetl.marketdata.v1.GetIngestionStatusRequest
+	(*Run)(nil),                       // 12: cgetl.marketdata.v1.Run
+	(*SyncLag)(nil),                   // 13: cgetl.marketdata.v1.SyncLag
+	(*IngestionStatus)(nil),           // 14: cgetl.marketdata.v1.IngestionStatus
+	(*timestamppb.Timestamp)(nil),     // 15: google.protobuf.Timestamp
+}
+var file_marketdata_proto_depIdxs = []int32{
+	15, // 0: cgetl.marketdata.v1.DailyPoint.timestamp:type_name -> google.protobuf.Timestamp
+	0,  // 1: cgetl.marketdata.v1.GetSeriesResponse.points:type_name -> cgetl.marketdata.v1.DailyPoint
+	2,  // 2: cgetl.marketdata.v1.GetSeriesResponse.rollups:type_name -> cgetl.marketdata.v1.RollupPoint
+	0,  // 3: cgetl.marketdata.v1.RankedPoint.point:type_name -> cgetl.marketdata.v1.DailyPoint
+	6,  // 4: cgetl.marketdata.v1.GetSnapshotResponse.points:type_name -> cgetl.marketdata.v1.RankedPoint
+	9,  // 5: cgetl.marketdata.v1.ListCoinsResponse.coins:type_name -> cgetl.marketdata.v1.Coin
+	15, // 6: cgetl.marketdata.v1.Run.started_at:type_name -> google.protobuf.Timestamp
+	15, // 7: cgetl.marketdata.v1.Run.finished_at:type_name -> google.protobuf.Timestamp
+	12, // 8: cgetl.marketdata.v1.IngestionStatus.latest_runs:type_name -> cgetl.marketdata.v1.Run
+	13, // 9: cgetl.marketdata.v1.IngestionStatus.lag:type_name -> cgetl.marketdata.v1.SyncLag
+	15, // 10: cgetl.marketdata.v1.IngestionStatus.heartbeat:type_name -> google.protobuf.Timestamp
+	1,  // 11: cgetl.marketdata.v1.MarketData.GetSeries:input_type -> cgetl.marketdata.v1.GetSeriesRequest
+	4,  // 12: cgetl.marketdata.v1.MarketData.StreamSeries:input_type -> cgetl.marketdata.v1.StreamSeriesRequest
+	5,  // 13: cgetl.marketdata.v1.MarketData.GetSnapshot:input_type -> cgetl.marketdata.v1.GetSnapshotRequest
+	8,  // 14: cgetl.marketdata.v1.MarketData.ListCoins:input_type -> cgetl.marketdata.v1.ListCoinsRequest
+	11, // 15: cgetl.marketdata.v1.MarketData.GetIngestionStatus:input_type -> cgetl.marketdata.v1.GetIngestionStatusRequest
+	3,  // 16: cgetl.marketdata.v1.MarketData.GetSeries:output_type -> cgetl.marketdata.v1.GetSeriesResponse
+	0,  // 17: cgetl.marketdata.v1.MarketData.StreamSeries:output_type -> cgetl.marketdata.v1.DailyPoint
+	7,  // 18: cgetl.marketdata.v1.MarketData.GetSnapshot:output_type -> cgetl.marketdata.v1.GetSnapshotResponse
+	10, // 19: cgetl.marketdata.v1.MarketData.ListCoins:output_type -> cgetl.marketdata.v1.ListCoinsResponse
+	14, // 20: cgetl.marketdata.v1.MarketData.GetIngestionStatus:output_type -> cgetl.marketdata.v1.IngestionStatus
+	16, // [16:21] is the sub-list for method output_type
+	11, // [11:16] is the sub-list for method input_type
+	11, // [11:11] is the sub-list for extension type_name
+	11, // [11:11] is the sub-list for extension extendee
+	0,  // [0:11] is the sub-list for field type_name
+}
+
+func init() { file_marketdata_proto_init() }
+func file_marketdata_proto_init() {
+	if File_marketdata_proto != nil {
+		return
+	}
+	file_marketdata_proto_msgTypes[3].OneofWrappers = []any{}
+	file_marketdata_proto_msgTypes[7].OneofWrappers = []any{}
+	file_marketdata_proto_msgTypes[8].OneofWrappers = []any{}
+	file_marketdata_proto_msgTypes[10].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_marketdata_proto_rawDesc), len(file_marketdata_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   15,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_marketdata_proto_goTypes,
+		DependencyIndexes: file_marketdata_proto_depIdxs,
+		MessageInfos:      file_marketdata_proto_msgTypes,
+	}.Build()
+	File_marketdata_proto = out.File
+	file_marketdata_proto_goTypes = nil
+	file_marketdata_proto_depIdxs = nil
+}
@@ -0,0 +1,301 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.5.1
+// - protoc             v5.29.3
+// source: marketdata.proto
+
+package marketpb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.64.0 or later.
+const _ = grpc.SupportPackageIsVersion9
+
+const (
+	MarketData_GetSeries_FullMethodName          = "/cgetl.marketdata.v1.MarketData/GetSeries"
+	MarketData_StreamSeries_FullMethodName       = "/cgetl.marketdata.v1.MarketData/StreamSeries"
+	MarketData_GetSnapshot_FullMethodName        = "/cgetl.marketdata.v1.MarketData/GetSnapshot"
+	MarketData_ListCoins_FullMethodName          = "/cgetl.marketdata.v1.MarketData/ListCoins"
+	MarketData_GetIngestionStatus_FullMethodName = "/cgetl.marketdata.v1.MarketData/GetIngestionStatus"
+)
+
+// MarketDataClient is the client API for MarketData service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+//
+// MarketData serves the stored CoinGecko daily series. Paged calls take a
+// limit (default 1000, at most 10000) and an offset and return next_offset
+// while more rows are available.
+type MarketDataClient interface {
+	// GetSeries returns one page of the daily points of a coin, or of its
+	// weekly or monthly rollups.
+	GetSeries(ctx context.Context, in *GetSeriesRequest, opts ...grpc.CallOption) (*GetSeriesResponse, error)
+	// StreamSeries streams every daily point of a coin in the window as it is
+	// read from ClickHouse.
+	StreamSeries(ctx context.Context, in *StreamSeriesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DailyPoint], error)
+	// GetSnapshot returns the coins of one day ranked by market cap.
+	GetSnapshot(ctx context.Context, in *GetSnapshotRequest, opts ...grpc.CallOption) (*GetSnapshotResponse, error)
+	// ListCoins returns the stored coin universe.
+	ListCoins(ctx context.Context, in *ListCoinsRequest, opts ...grpc.CallOption) (*ListCoinsResponse, error)
+	// GetIngestionStatus reports the latest runs, sync lag and the progress
+	// of this process.
+	GetIngestionStatus(ctx context.Context, in *GetIngestionStatusRequest, opts ...grpc.CallOption) (*IngestionStatus, error)
+}
+
+type marketDataClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewMarketDataClient(cc grpc.ClientConnInterface) MarketDataClient {
+	return &marketDataClient{cc}
+}
+
+func (c *marketDataClient) GetSeries(ctx context.Context, in *GetSeriesRequest, opts ...grpc.CallOption) (*GetSeriesResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetSeriesResponse)
+	err := c.cc.Invoke(ctx, MarketData_GetSeries_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marketDataClient) StreamSeries(ctx context.Context, in *StreamSeriesRequest, opts ...grpc.CallOption) (grpc.ServerStreamingClient[DailyPoint], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &MarketData_ServiceDesc.Streams[0], MarketData_StreamSeries_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[StreamSeriesRequest, DailyPoint]{ClientStream: stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MarketData_StreamSeriesClient = grpc.ServerStreamingClient[DailyPoint]
+
+func (c *marketDataClient) GetSnapshot(ctx context.Context, in *GetSnapshotRequest, opts ...grpc.CallOption) (*GetSnapshotResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetSnapshotResponse)
+	err := c.cc.Invoke(ctx, MarketData_GetSnapshot_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marketDataClient) ListCoins(ctx context.Context, in *ListCoinsRequest, opts ...grpc.CallOption) (*ListCoinsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(ListCoinsResponse)
+	err := c.cc.Invoke(ctx, MarketData_ListCoins_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *marketDataClient) GetIngestionStatus(ctx context.Context, in *GetIngestionStatusRequest, opts ...grpc.CallOption) (*IngestionStatus, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(IngestionStatus)
+	err := c.cc.Invoke(ctx, MarketData_GetIngestionStatus_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// MarketDataServer is the server API for MarketData service.
+// All implementations must embed UnimplementedMarketDataServer
+// for forward compatibility.
+//
+// MarketData serves the stored CoinGecko daily series. Paged calls take a
+// limit (default 1000, at most 10000) and an offset and return next_offset
+// while more rows are available.
+type MarketDataServer interface {
+	// GetSeries returns one page of the daily points of a coin, or of its
+	// weekly or monthly rollups.
+	GetSeries(context.Context, *GetSeriesRequest) (*GetSeriesResponse, error)
+	// StreamSeries streams every daily point of a coin in the window as it is
+	// read from ClickHouse.
+	StreamSeries(*StreamSeriesRequest, grpc.ServerStreamingServer[DailyPoint]) error
+	// GetSnapshot returns the coins of one day ranked by market cap.
+	GetSnapshot(context.Context, *GetSnapshotRequest) (*GetSnapshotResponse, error)
+	// ListCoins returns the stored coin universe.
+	ListCoins(context.Context, *ListCoinsRequest) (*ListCoinsResponse, error)
+	// GetIngestionStatus reports the latest runs, sync lag and the progress
+	// of this process.
+	GetIngestionStatus(context.Context, *GetIngestionStatusRequest) (*IngestionStatus, error)
+	mustEmbedUnimplementedMarketDataServer()
+}
+
+// UnimplementedMarketDataServer must be embedded to have
+// forward compatible implementations.
+//
+// NOTE: this should be embedded by value instead of pointer to avoid a nil
+// pointer dereference when methods are called.
+type UnimplementedMarketDataServer struct{}
+
+func (UnimplementedMarketDataServer) GetSeries(context.Context, *GetSeriesRequest) (*GetSeriesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSeries not implemented")
+}
+func (UnimplementedMarketDataServer) StreamSeries(*StreamSeriesRequest, grpc.ServerStreamingServer[DailyPoint]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamSeries not implemented")
+}
+func (UnimplementedMarketDataServer) GetSnapshot(context.Context, *GetSnapshotRequest) (*GetSnapshotResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetSnapshot not implemented")
+}
+func (UnimplementedMarketDataServer) ListCoins(context.Context, *ListCoinsRequest) (*ListCoinsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListCoins not implemented")
+}
+func (UnimplementedMarketDataServer) GetIngestionStatus(context.Context, *GetIngestionStatusRequest) (*IngestionStatus, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetIngestionStatus not implemented")
+}
+func (UnimplementedMarketDataServer) mustEmbedUnimplementedMarketDataServer() {}
+func (UnimplementedMarketDataServer) testEmbeddedByValue()                    {}
+
+// UnsafeMarketDataServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to MarketDataServer will
+// result in compilation errors.
+type UnsafeMarketDataServer interface {
+	mustEmbedUnimplementedMarketDataServer()
+}
+
+func RegisterMarketDataServer(s grpc.ServiceRegistrar, srv MarketDataServer) {
+	// If the following call pancis, it indicates UnimplementedMarketDataServer was
+	// embedded by pointer and is nil.  This will cause panics if an
+	// unimplemented method is ever invoked, so we test this at initialization
+	// time to prevent it from happening at runtime later due to I/O.
+	if t, ok := srv.(interface{ testEmbeddedByValue() }); ok {
+		t.testEmbeddedByValue()
+	}
+	s.RegisterService(&MarketData_ServiceDesc, srv)
+}
+
+func _MarketData_GetSeries_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSeriesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).GetSeries(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_GetSeries_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).GetSeries(ctx, req.(*GetSeriesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MarketData_StreamSeries_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamSeriesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(MarketDataServer).StreamSeries(m, &grpc.GenericServerStream[StreamSeriesRequest, DailyPoint]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type MarketData_StreamSeriesServer = grpc.ServerStreamingServer[DailyPoint]
+
+func _MarketData_GetSnapshot_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetSnapshotRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).GetSnapshot(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_GetSnapshot_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).GetSnapshot(ctx, req.(*GetSnapshotRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MarketData_ListCoins_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListCoinsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).ListCoins(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_ListCoins_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).ListCoins(ctx, req.(*ListCoinsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _MarketData_GetIngestionStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetIngestionStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MarketDataServer).GetIngestionStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: MarketData_GetIngestionStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MarketDataServer).GetIngestionStatus(ctx, req.(*GetIngestionStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// MarketData_ServiceDesc is the grpc.ServiceDesc for MarketData service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var MarketData_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "cgetl.marketdata.v1.MarketData",
+	HandlerType: (*MarketDataServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetSeries",
+			Handler:    _MarketData_GetSeries_Handler,
+		},
+		{
+			MethodName: "GetSnapshot",
+			Handler:    _MarketData_GetSnapshot_Handler,
+		},
+		{
+			MethodName: "ListCoins",
+			Handler:    _MarketData_ListCoins_Handler,
+		},
+		{
+			MethodName: "GetIngestionStatus",
+			Handler:    _MarketData_GetIngestionStatus_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamSeries",
+			Handler:       _MarketData_StreamSeries_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "marketdata.proto",
+}
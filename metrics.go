@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const metricsNamespace = "cg_etl"
+
+var (
+	metricCGRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "coingecko_requests_total",
+		Help:      "CoinGecko API requests by endpoint and HTTP status (0 = transport error).",
+	}, []string{"endpoint", "status"})
+
+	metricCGRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "coingecko_request_duration_seconds",
+		Help:      "CoinGecko API request latency, excluding rate limiter wait.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"endpoint"})
+
+	metricLimiterWait = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "coingecko_limiter_wait_seconds",
+		Help:      "Time spent waiting for the CoinGecko rate limiter.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 10),
+	})
+
+	metricRetries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "retries_total",
+		Help:      "Retries of HTTP attempts inside a task and of whole tasks.",
+	}, []string{"kind", "phase"})
+
+	metricRowsInserted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rows_inserted_total",
+		Help:      "Daily rows inserted into ClickHouse by task phase; rows of derived currencies count as derived, ticker snapshots as tickers.",
+	}, []string{"phase"})
+
+	metricQuarantined = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "rows_quarantined_total",
+		Help:      "Fetched rows kept out of the daily table by validation rule.",
+	}, []string{"rule"})
+
+	metricAnomalies = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "anomalies_total",
+		Help:      "New anomalies found by the anomaly scan by kind.",
+	}, []string{"kind"})
+
+	metricGlobalSnapshots = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "global_snapshots_total",
+		Help:      "Stored /global and /global/decentralized_finance_defi snapshot pairs.",
+	})
+
+	metricFXMissing = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "fx_missing_total",
+		Help:      "Points not derived into a currency for lack of an FX rate.",
+	})
+
+	metricIndicatorValues = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "indicator_values_total",
+		Help:      "Indicator values written to ClickHouse.",
+	})
+
+	metricFeedEvents = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "feed_events_total",
+		Help:      "Points published to the live feed.",
+	})
+
+	metricFeedSubscribers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "feed_subscribers",
+		Help:      "Connected WebSocket and SSE feed clients.",
+	})
+
+	metricCHQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "clickhouse_query_duration_seconds",
+		Help:      "ClickHouse query latency by query.",
+		Buckets:   prometheus.ExponentialBuckets(0.002, 2, 14),
+	}, []string{"query"})
+
+	metricTaskQueue = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "task_queue_depth",
+		Help:      "Tasks waiting to be dispatched to workers.",
+	})
+
+	metricTasksInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "tasks_in_flight",
+		Help:      "Tasks dispatched to workers and not yet finished.",
+	})
+
+	metricWorkers = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "workers",
+		Help:      "Running task workers.",
+	})
+
+	metricTasks = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "tasks_total",
+		Help:      "Finished tasks by phase and outcome.",
+	}, []string{"phase", "outcome"})
+
+	metricPhaseTasks = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "phase_tasks",
+		Help:      "Progress of the current run of each phase: pending, in_flight and done tasks.",
+	}, []string{"phase", "state"})
+
+	metricSyncLag = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "sync_lag_days",
+		Help:      "Days between yesterday (UTC) and the newest stored day, per currency.",
+	}, []string{"vs_currency"})
+
+	metricLastIncremental = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "last_incremental_success_timestamp_seconds",
+		Help:      "Unix time of the last incremental run that finished without task errors.",
+	})
+)
+
+func observeQuery(name string, start time.Time) {
+	metricCHQueryDuration.WithLabelValues(name).Observe(time.Since(start).Seconds())
+}
+
+func taskOutcome(res TaskResult) string {
+	switch {
+	case res.Err != "":
+		return "error"
+	case len(res.MissingDates) > 0:
+		return "missing"
+	case res.Empty:
+		return "empty"
+	default:
+		return "ok"
+	}
+}
+
+// phaseProgress keeps the per-phase gauges of a runTasks call in sync.
+type phaseProgress map[TaskPhase][3]int
+
+func newPhaseProgress(pending []Task) phaseProgress {
+	p := phaseProgress{}
+	for _, t := range pending {
+		p[t.Phase] = [3]int{}
+	}
+	for phase := range p {
+		p.add(phase, progressInFlight, 0)
+		p.add(phase, progressDone, 0)
+	}
+	for _, t := range pending {
+		p.add(t.Phase, progressPending, 1)
+	}
+	return p
+}
+
+const (
+	progressPending = iota
+	progressInFlight
+	progressDone
+)
+
+var progressStates = [3]string{"pending", "in_flight", "done"}
+
+func (p phaseProgress) add(phase TaskPhase, state, n int) {
+	v := p[phase]
+	v[state] += n
+	p[phase] = v
+	metricPhaseTasks.WithLabelValues(string(phase), progressStates[state]).Set(float64(v[state]))
+}
+
+// updateSyncLag sets the lag gauge from the newest stored day of every currency.
+func updateSyncLag(ctx context.Context, db *sql.DB, table string) error {
+	latest, err := getLatestDates(ctx, db, table)
+	if err != nil {
+		return err
+	}
+
+	yday := yesterdayUTC()
+	for vs, d := range latest {
+		metricSyncLag.WithLabelValues(vs).Set(float64(daysBetween(d, yday)))
+	}
+	return nil
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultDiscoveryProbes is the assumed number of probes per coin whose
+// bounds are unknown when no discovered coins are stored to average over.
+const defaultDiscoveryProbes = 20
+
+// creditsPerCall is what CoinGecko charges for one market_chart/range or
+// coins/list request.
+const creditsPerCall = 1
+
+type PhasePlan struct {
+	Phase     TaskPhase `json:"phase"`
+	Coins     int       `json:"coins"`
+	Tasks     int       `json:"tasks"`
+	Days      int       `json:"days"`
+	Estimated bool      `json:"estimated,omitempty"`
+}
+
+type CoinPlan struct {
+	ID     string            `json:"id"`
+	Symbol string            `json:"symbol"`
+	Tasks  map[TaskPhase]int `json:"tasks"`
+	Days   int               `json:"days"`
+	Total  int               `json:"total_tasks"`
+}
+
+// Plan is what the next backfill and sync round would request from
+// CoinGecko. Unknown coin bounds are estimated: discovery uses the average
+// probe count and backfill assumes data from START_DATE to yesterday.
+type Plan struct {
+	GeneratedAt     time.Time   `json:"generated_at"`
+	UniverseCoins   int         `json:"universe_coins"`
+	UniverseSource  string      `json:"universe_source"`
+	UnknownBounds   int         `json:"unknown_bounds"`
+	Phases          []PhasePlan `json:"phases"`
+	Coins           []CoinPlan  `json:"coins"`
+	Calls           int         `json:"calls"`
+	Credits         int         `json:"credits"`
+	RPS             float64     `json:"rps"`
+	DurationSeconds float64     `json:"estimated_duration_seconds"`
+	Duration        string      `json:"estimated_duration"`
+}
+
+// buildPlan runs the planning steps of discovery, backfill, incremental,
+// revision and gap repair against the stored state without fetching data.
+func buildPlan(ctx context.Context, cfg Config, db *sql.DB, cg *CGClient) (Plan, error) {
+	yday := yesterdayUTC()
+	p := Plan{GeneratedAt: time.Now().UTC(), RPS: cfg.CGRPS}
+
+	all, active, err := fetchCoinsLists(ctx, cg, cfg)
+	p.Calls += 2
+	p.UniverseSource = "api"
+	if err != nil {
+		log.Warnf("plan: coins list unavailable, using stored universe: %v", err)
+		stored, serr := getUniverse(ctx, db, cfg.CHCoinsTable)
+		if serr != nil {
+			return p, serr
+		}
+		all, active = nil, nil
+		for id, c := range stored {
+			if cfg.CoinIDsFilter != nil && !cfg.CoinIDsFilter[id] {
+				continue
+			}
+			all = append(all, c.Coin)
+			if c.Active {
+				active = append(active, c.Coin)
+			}
+		}
+		p.UniverseSource = "stored"
+	}
+	p.UniverseCoins = len(all)
+
+	known, err := getCoinBounds(ctx, db, cfg.CHBoundsTable)
+	if err != nil {
+		return p, err
+	}
+	stored, err := getDateRanges(ctx, db, cfg.CHTable, cfg.VsCurrency)
+	if err != nil {
+		return p, err
+	}
+
+	probes, probed := 0, 0
+	for _, b := range known {
+		if b.Probes > 0 {
+			probes += b.Probes
+			probed++
+		}
+	}
+	perCoin := defaultDiscoveryProbes
+	if probed > 0 {
+		perCoin = int(math.Ceil(float64(probes) / float64(probed)))
+	}
+
+	coins := make(map[string]*CoinPlan)
+	phases := make(map[TaskPhase]*PhasePlan)
+	phaseCoins := make(map[TaskPhase]map[string]bool)
+	add := func(t Task, n int, estimated bool) {
+		c := coins[t.CoinID]
+		if c == nil {
+			c = &CoinPlan{ID: t.CoinID, Symbol: t.Symbol, Tasks: make(map[TaskPhase]int)}
+			coins[t.CoinID] = c
+		}
+		ph := phases[t.Phase]
+		if ph == nil {
+			ph = &PhasePlan{Phase: t.Phase}
+			phases[t.Phase] = ph
+			phaseCoins[t.Phase] = make(map[string]bool)
+		}
+		days := 0
+		if t.Phase != PhaseDiscovery {
+			days = daysBetween(t.From, t.To) + 1
+		}
+		c.Tasks[t.Phase] += n
+		c.Total += n
+		c.Days += days
+		ph.Tasks += n
+		ph.Days += days
+		ph.Estimated = ph.Estimated || estimated
+		phaseCoins[t.Phase][t.CoinID] = true
+	}
+
+	// Coins without stored bounds, or whose bounds are due a re-check, need
+	// discovery; their backfill is planned over the widest possible window.
+	bounds := make(map[string]CoinBounds, len(all))
+	for _, c := range all {
+		id := strings.TrimSpace(c.ID)
+		sym := strings.ToUpper(strings.TrimSpace(c.Symbol))
+		if id == "" || sym == "" {
+			continue
+		}
+		b, ok := known[id]
+		if r, has := stored[id]; ok && b.HasData && has && r.Max.After(b.Last) {
+			b.Last = r.Max
+		}
+		if ok && boundsReusable(cfg, b, yday) {
+			if b.HasData {
+				bounds[id] = b
+			}
+			continue
+		}
+		p.UnknownBounds++
+		add(Task{CoinID: id, Symbol: sym, Phase: PhaseDiscovery}, perCoin, true)
+		bounds[id] = CoinBounds{ID: id, Symbol: sym, HasData: true, First: cfg.StartDate, Last: yday}
+	}
+
+	backfill, _, _ := planBackfill(cfg, bounds, stored, yday)
+	for _, t := range backfill {
+		b, ok := known[t.CoinID]
+		add(t, 1, !ok || !b.HasData)
+	}
+
+	// Incremental sync runs after backfill, so it starts past the
+	// backfilled days.
+	maxDates := make(map[string]time.Time, len(stored))
+	for id, r := range stored {
+		maxDates[id] = r.Max
+	}
+	for _, t := range backfill {
+		if t.To.After(maxDates[t.CoinID]) {
+			maxDates[t.CoinID] = t.To
+		}
+	}
+	for _, t := range BuildIncrementalTasks(cfg, active, maxDates) {
+		add(t, 1, false)
+	}
+	for _, t := range BuildRevisionTasks(cfg, active, maxDates) {
+		add(t, 1, false)
+	}
+
+	gaps, err := findGaps(ctx, db, cfg.CHTable, cfg.CHEmptyDaysTable, cfg.CHQuarantineTable)
+	if err != nil {
+		return p, err
+	}
+	for _, t := range BuildGapTasks(cfg, gaps) {
+		add(t, 1, false)
+	}
+
+	for _, phase := range []TaskPhase{PhaseDiscovery, PhaseBackfill, PhaseIncremental, PhaseRevision, PhaseGapFill} {
+		if ph := phases[phase]; ph != nil {
+			ph.Coins = len(phaseCoins[phase])
+			p.Phases = append(p.Phases, *ph)
+			p.Calls += ph.Tasks
+		}
+	}
+	for _, c := range coins {
+		p.Coins = append(p.Coins, *c)
+	}
+	sort.Slice(p.Coins, func(i, j int) bool {
+		if p.Coins[i].Total != p.Coins[j].Total {
+			return p.Coins[i].Total > p.Coins[j].Total
+		}
+		return p.Coins[i].ID < p.Coins[j].ID
+	})
+
+	p.Credits = p.Calls * creditsPerCall
+	if cfg.CGRPS > 0 {
+		p.DurationSeconds = float64(p.Calls) / cfg.CGRPS
+	}
+	p.Duration = (time.Duration(p.DurationSeconds) * time.Second).String()
+	return p, nil
+}
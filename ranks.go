@@ -0,0 +1,302 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// marketRebuildChunk is how many days one rebuild query covers.
+const marketRebuildChunk = 31
+
+// changedDays collects, per currency, the days whose stored points changed
+// since the derived market tables were last refreshed.
+var changedDays = &dayTracker{name: "market"}
+
+// pendingTrackers are the trackers whose days are kept in the pending days
+// table until they are refreshed.
+var pendingTrackers = []*dayTracker{changedDays, revisedCoinDays, indicatorDays, categoryDays}
+
+// pendingFlushEvery is how often the days new to the trackers are stored.
+const pendingFlushEvery = 30 * time.Second
+
+// dayTracker is a concurrency-safe set of days per key. Once attached to the
+// pending days table, the days new to the tracker are stored there by a
+// periodic flush, and Clear deletes them after a refresh.
+type dayTracker struct {
+	name string
+	// ranged keeps only the first and last day of every key, for refreshes
+	// that cover a whole range anyway.
+	ranged bool
+
+	mu      sync.Mutex
+	days    map[string]map[time.Time]bool
+	unsaved map[string][]time.Time
+	db      *sql.DB
+	table   string
+}
+
+func (d *dayTracker) Add(key string, days ...time.Time) {
+	if len(days) == 0 {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	added := d.add(key, days)
+	if d.db != nil && len(added) > 0 {
+		if d.unsaved == nil {
+			d.unsaved = make(map[string][]time.Time)
+		}
+		d.unsaved[key] = append(d.unsaved[key], added...)
+	}
+}
+
+// add records days under key and returns the ones that were new. The caller
+// holds d.mu.
+func (d *dayTracker) add(key string, days []time.Time) []time.Time {
+	if d.days == nil {
+		d.days = make(map[string]map[time.Time]bool)
+	}
+	if d.ranged {
+		return d.addRange(key, days)
+	}
+	if d.days[key] == nil {
+		d.days[key] = make(map[time.Time]bool)
+	}
+	var added []time.Time
+	for _, day := range days {
+		day = dateOnlyUTC(day)
+		if !d.days[key][day] {
+			d.days[key][day] = true
+			added = append(added, day)
+		}
+	}
+	return added
+}
+
+// addRange widens the range of key to days and returns its new ends.
+func (d *dayTracker) addRange(key string, days []time.Time) []time.Time {
+	old := d.days[key]
+	var first, last time.Time
+	for _, day := range days {
+		day = dateOnlyUTC(day)
+		if first.IsZero() || day.Before(first) {
+			first = day
+		}
+		if day.After(last) {
+			last = day
+		}
+	}
+	for day := range old {
+		if day.Before(first) {
+			first = day
+		}
+		if day.After(last) {
+			last = day
+		}
+	}
+
+	var added []time.Time
+	ends := make(map[time.Time]bool, 2)
+	for _, day := range []time.Time{first, last} {
+		if !ends[day] && !old[day] {
+			added = append(added, day)
+		}
+		ends[day] = true
+	}
+	d.days[key] = ends
+	return added
+}
+
+// AddPoints adds the day of every point under its currency.
+func (d *dayTracker) AddPoints(pts []DailyPoint) {
+	d.addGrouped(pts, func(p DailyPoint) string { return p.VsCurrency })
+}
+
+// AddCoinPoints adds the day of every point under its coin and currency.
+func (d *dayTracker) AddCoinPoints(pts []DailyPoint) {
+	d.addGrouped(pts, func(p DailyPoint) string { return coinKey(p.ID, p.VsCurrency) })
+}
+
+func (d *dayTracker) addGrouped(pts []DailyPoint, key func(DailyPoint) string) {
+	byKey := make(map[string][]time.Time)
+	for _, p := range pts {
+		k := key(p)
+		byKey[k] = append(byKey[k], p.Timestamp)
+	}
+	for _, k := range sortedKeys(byKey) {
+		d.Add(k, byKey[k]...)
+	}
+}
+
+// Take returns the collected days in date order and resets the tracker. The
+// returned time is for Clear once the days are refreshed; it is zero when
+// there were none.
+func (d *dayTracker) Take() (map[string][]time.Time, time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.days) == 0 {
+		return nil, time.Time{}
+	}
+	out := make(map[string][]time.Time, len(d.days))
+	for key, set := range d.days {
+		days := make([]time.Time, 0, len(set))
+		for day := range set {
+			days = append(days, day)
+		}
+		sort.Slice(days, func(i, j int) bool { return days[i].Before(days[j]) })
+		out[key] = days
+	}
+	// Days not stored yet need no storing once refreshed; the ones that
+	// fail are added again.
+	d.days, d.unsaved = nil, nil
+	return out, time.Now()
+}
+
+// flush stores the days added since the last flush in one insert. Days that
+// fail to store are kept for the next flush unless they were taken meanwhile.
+func (d *dayTracker) flush(ctx context.Context) error {
+	d.mu.Lock()
+	unsaved, db, table := d.unsaved, d.db, d.table
+	d.unsaved = nil
+	// Taken under the lock, so it is before the time of any later Take,
+	// which takes these days too and clears their rows.
+	addedAt := time.Now()
+	d.mu.Unlock()
+
+	if db == nil || len(unsaved) == 0 {
+		return nil
+	}
+	err := insertPendingDays(ctx, db, table, d.name, unsaved, addedAt)
+	if err != nil {
+		d.mu.Lock()
+		for key, days := range unsaved {
+			for _, day := range days {
+				if d.days[key][day] {
+					if d.unsaved == nil {
+						d.unsaved = make(map[string][]time.Time)
+					}
+					d.unsaved[key] = append(d.unsaved[key], day)
+				}
+			}
+		}
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// Clear deletes the stored days added before takenAt, the time returned by
+// Take. Days that failed and were added again are stored later and stay.
+func (d *dayTracker) Clear(ctx context.Context, takenAt time.Time) {
+	d.mu.Lock()
+	db, table := d.db, d.table
+	d.mu.Unlock()
+	if db == nil || takenAt.IsZero() {
+		return
+	}
+	if err := deletePendingDays(ctx, db, table, d.name, takenAt); err != nil && ctx.Err() == nil {
+		log.WithField("tracker", d.name).Warnf("clear pending days: %v", err)
+	}
+}
+
+// attach loads the days stored in table into d and stores the days added
+// from now on.
+func (d *dayTracker) attach(ctx context.Context, db *sql.DB, table string) error {
+	stored, err := getPendingDays(ctx, db, table, d.name)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for key, days := range stored {
+		d.add(key, days)
+	}
+	d.db, d.table = db, table
+	return nil
+}
+
+// loadPendingDays restores the days an earlier process left to refresh and
+// starts storing the trackers' new days until ctx is cancelled.
+func (a *app) loadPendingDays(ctx context.Context) error {
+	table := a.config().CHPendingDaysTable
+	for _, t := range pendingTrackers {
+		if err := t.attach(ctx, a.db, table); err != nil {
+			return fmt.Errorf("%s: %w", t.name, err)
+		}
+	}
+	go flushPendingLoop(ctx)
+	return nil
+}
+
+// flushPendingLoop stores the trackers' new days every pendingFlushEvery and
+// once more when ctx is cancelled.
+func flushPendingLoop(ctx context.Context) {
+	ticker := time.NewTicker(pendingFlushEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			fctx, cancel := context.WithTimeout(context.Background(), pendingFlushEvery)
+			flushPending(fctx)
+			cancel()
+			return
+		case <-ticker.C:
+			flushPending(ctx)
+		}
+	}
+}
+
+func flushPending(ctx context.Context) {
+	for _, t := range pendingTrackers {
+		if err := t.flush(ctx); err != nil && ctx.Err() == nil {
+			log.WithField("tracker", t.name).Warnf("store pending days: %v", err)
+		}
+	}
+}
+
+// refreshMarketRanks rebuilds the rank, dominance and totals tables of vs for
+// days, a chunk at a time.
+func refreshMarketRanks(ctx context.Context, cfg Config, db *sql.DB, vs string, days []time.Time) error {
+	for start := 0; start < len(days); start += marketRebuildChunk {
+		chunk := days[start:min(start+marketRebuildChunk, len(days))]
+		if err := rebuildMarketDays(ctx, db, cfg.CHTable, cfg.CHMarketRankTable, cfg.CHMarketTotalsTable, vs, chunk); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshDerived brings the derived market tables, category market caps,
+// rollups and indicators up to date with the days changed by tasks since the
+// last call. Days that fail are kept for the next call.
+func (a *app) refreshDerived(ctx context.Context) {
+	a.refreshRollups(ctx)
+	a.refreshIndicators(ctx)
+
+	changed, takenAt := changedDays.Take()
+	a.refreshCategoryCaps(ctx, changed)
+
+	cfg := a.config()
+	for vs, days := range changed {
+		start := time.Now()
+		if err := refreshMarketRanks(ctx, cfg, a.db, vs, days); err != nil {
+			changedDays.Add(vs, days...)
+			if ctx.Err() == nil {
+				log.WithField("vs", vs).Warnf("market rank refresh failed: %v", err)
+			}
+			continue
+		}
+		log.WithFields(log.Fields{
+			"vs":      vs,
+			"days":    len(days),
+			"elapsed": time.Since(start).Round(time.Millisecond),
+		}).Info("market ranks refreshed")
+	}
+	changedDays.Clear(ctx, takenAt)
+}
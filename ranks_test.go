@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDayTracker(t *testing.T) {
+	type add struct {
+		key  string
+		days []string
+	}
+	tests := []struct {
+		name   string
+		ranged bool
+		adds   []add
+		want   map[string][]string
+	}{
+		{
+			name: "nothing added",
+		},
+		{
+			name: "days in date order without repeats",
+			adds: []add{
+				{"usd", []string{"2024-01-03", "2024-01-01"}},
+				{"usd", []string{"2024-01-02", "2024-01-03"}},
+				{"eur", []string{"2024-01-05"}},
+			},
+			want: map[string][]string{
+				"usd": {"2024-01-01", "2024-01-02", "2024-01-03"},
+				"eur": {"2024-01-05"},
+			},
+		},
+		{
+			name:   "ranged keeps the first and last day",
+			ranged: true,
+			adds: []add{
+				{"a|usd", []string{"2024-01-03", "2024-01-05", "2024-01-04"}},
+				{"a|usd", []string{"2024-01-01"}},
+				{"a|usd", []string{"2024-01-02"}},
+				{"b|usd", []string{"2024-01-07"}},
+			},
+			want: map[string][]string{
+				"a|usd": {"2024-01-01", "2024-01-05"},
+				"b|usd": {"2024-01-07"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := &dayTracker{name: "test", ranged: tt.ranged}
+			for _, a := range tt.adds {
+				var days []time.Time
+				for _, s := range a.days {
+					// The time of day is dropped.
+					days = append(days, mustParseDate(s).Add(13*time.Hour))
+				}
+				d.Add(a.key, days...)
+			}
+
+			taken, takenAt := d.Take()
+			var got map[string][]string
+			for key, days := range taken {
+				if got == nil {
+					got = make(map[string][]string)
+				}
+				for _, day := range days {
+					got[key] = append(got[key], formatDate(day))
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("Take = %v, want %v", got, tt.want)
+			}
+			if takenAt.IsZero() != (tt.want == nil) {
+				t.Fatalf("takenAt = %v with days %v", takenAt, got)
+			}
+			if again, _ := d.Take(); again != nil {
+				t.Fatalf("second Take = %v, want nothing", again)
+			}
+			// Not attached: nothing is stored or cleared.
+			d.Clear(context.Background(), takenAt)
+		})
+	}
+}
+
+func TestDayTrackerFailedFlush(t *testing.T) {
+	db, err := sql.Open("clickhouse", "clickhouse://127.0.0.1:1/default?dial_timeout=1s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	day := mustParseDate("2024-01-01")
+	d := &dayTracker{name: "test", db: db, table: "pending"}
+	d.Add("usd", day)
+	if err := d.flush(ctx); err == nil {
+		t.Fatal("flush to an unreachable server succeeded")
+	}
+	if want := map[string][]time.Time{"usd": {day}}; !reflect.DeepEqual(d.unsaved, want) {
+		t.Fatalf("unsaved after a failed flush = %v, want %v", d.unsaved, want)
+	}
+
+	// Taken days need no storing anymore.
+	d.Take()
+	if err := d.flush(ctx); err != nil {
+		t.Fatalf("flush after Take: %v", err)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// reloadable are the settings applied to a running process. Everything else
+// is only reported as needing a restart.
+var reloadable = map[string]bool{
+	"coingecko_rps":        true,
+	"coingecko_burst":      true,
+	"workers":              true,
+	"coingecko_ids":        true,
+	"coingecko_categories": true,
+	"sync_every":           true,
+}
+
+// watchConfig reloads the configuration on SIGHUP and, when a config file is
+// used, whenever its modification time changes.
+func (a *app) watchConfig(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	cfg := a.config()
+	var poll <-chan time.Time
+	if cfg.path != "" && cfg.ConfigWatchEvery > 0 {
+		t := time.NewTicker(cfg.ConfigWatchEvery)
+		defer t.Stop()
+		poll = t.C
+	}
+	modTime := fileModTime(cfg.path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			signal.Stop(hup)
+			return
+		case <-hup:
+			log.Info("SIGHUP received; reloading config")
+		case <-poll:
+			mt := fileModTime(cfg.path)
+			if mt.Equal(modTime) {
+				continue
+			}
+			modTime = mt
+			log.WithField("file", cfg.path).Info("config file changed; reloading config")
+		}
+		a.reload()
+	}
+}
+
+func fileModTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
+
+// reload loads the config again and applies the reloadable settings. An
+// invalid config is rejected as a whole and the running one is kept.
+func (a *app) reload() {
+	old := a.config()
+	next, err := LoadConfig(old.path, old.flags)
+	if err != nil {
+		log.Errorf("config reload rejected: %v", err)
+		return
+	}
+
+	cfg := old
+	cfg.CGRPS = next.CGRPS
+	cfg.CGBurst = next.CGBurst
+	cfg.Workers = next.Workers
+	cfg.CoinIDsFilter = next.CoinIDsFilter
+	cfg.CategoryFilter = next.CategoryFilter
+	if len(cfg.CategoryFilter) > 0 {
+		rctx, cancel := context.WithTimeout(context.Background(), 2*time.Minute)
+		err := resolveCategoryFilter(rctx, &cfg, a.cg)
+		cancel()
+		if err != nil {
+			log.Errorf("config reload rejected: COINGECKO_CATEGORIES: %v", err)
+			return
+		}
+	}
+	cfg.SyncEvery = next.SyncEvery
+	cfg.sources = next.sources
+
+	// Reloadable settings are compared as applied, with categories resolved
+	// into coin ids like in the running config; the rest as loaded.
+	var changed, restart []string
+	oldEntries, applied := old.Entries(), cfg.Entries()
+	for i, e := range next.Entries() {
+		switch {
+		case reloadable[e.Key]:
+			if applied[i].Value != oldEntries[i].Value {
+				changed = append(changed, e.Key)
+			}
+		case e.Value != oldEntries[i].Value:
+			restart = append(restart, e.Key)
+		}
+	}
+	if len(restart) > 0 {
+		log.WithField("settings", restart).Warn("config changes ignored until restart")
+	}
+	if len(changed) == 0 {
+		log.Info("config reloaded; nothing to apply")
+		return
+	}
+
+	a.mu.Lock()
+	a.cfg = cfg
+	pool := a.pool
+	a.mu.Unlock()
+
+	if cfg.CGRPS != old.CGRPS || cfg.CGBurst != old.CGBurst {
+		a.cg.SetRate(cfg.CGRPS, cfg.CGBurst)
+	}
+	if cfg.Workers != old.Workers && pool != nil {
+		pool.Resize(cfg.Workers)
+	}
+	select {
+	case a.reloaded <- struct{}{}:
+	default:
+	}
+
+	log.WithField("settings", changed).Info("config reloaded")
+}
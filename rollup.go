@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// rollup is a coarser resolution of the daily series, kept in an
+// AggregatingMergeTree table fed by a materialized view.
+type rollup struct {
+	resolution string
+	periodFn   string // ClickHouse function mapping a day to its period
+	start      func(time.Time) time.Time
+	table      func(Config) string
+}
+
+var rollups = []rollup{
+	{"week", "toMonday", weekStart, func(c Config) string { return c.CHWeeklyTable }},
+	{"month", "toStartOfMonth", monthStart, func(c Config) string { return c.CHMonthlyTable }},
+}
+
+// rollupFields are the values of a rollup period the API can return, in
+// their default order.
+var rollupFields = []string{"first_price", "last_price", "min_price", "max_price", "avg_price", "market_cap", "volume", "days"}
+
+// RollupPoint is one week or month of a coin. MarketCap is the last one of
+// the period and Volume the sum over its days.
+type RollupPoint struct {
+	Period     time.Time
+	FirstPrice float64
+	LastPrice  float64
+	MinPrice   float64
+	MaxPrice   float64
+	AvgPrice   float64
+	MarketCap  float64
+	Volume     float64
+	Days       int
+}
+
+func rollupFor(resolution string) (rollup, bool) {
+	for _, r := range rollups {
+		if r.resolution == resolution {
+			return r, true
+		}
+	}
+	return rollup{}, false
+}
+
+func (r rollup) view(cfg Config) string {
+	return r.table(cfg) + "_mv"
+}
+
+func weekStart(t time.Time) time.Time {
+	d := dateOnlyUTC(t)
+	return d.AddDate(0, 0, -(int(d.Weekday())+6)%7)
+}
+
+func monthStart(t time.Time) time.Time {
+	d := dateOnlyUTC(t)
+	return time.Date(d.Year(), d.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// revisedCoinDays collects the first and last revised day per coin and
+// currency. The views only add rows, so the periods between them are rebuilt
+// from the daily table.
+var revisedCoinDays = &dayTracker{name: "rollup", ranged: true}
+
+// coinKey is the tracker key of a coin in one currency.
+func coinKey(id, vs string) string {
+	return id + "|" + vs
+}
+
+// refreshRollups rebuilds the periods from the first to the last revised day
+// of every coin.
+func (a *app) refreshRollups(ctx context.Context) {
+	cfg := a.config()
+	revised, takenAt := revisedCoinDays.Take()
+	for key, days := range revised {
+		id, vs, _ := strings.Cut(key, "|")
+		for _, r := range rollups {
+			first, last := r.start(days[0]), r.start(days[len(days)-1])
+			if err := rebuildRollup(ctx, a.db, cfg.CHTable, r.table(cfg), r.periodFn, id, vs, first, last); err != nil {
+				revisedCoinDays.Add(key, days...)
+				if ctx.Err() == nil {
+					log.WithFields(log.Fields{
+						"id":         id,
+						"vs":         vs,
+						"resolution": r.resolution,
+					}).Warnf("rollup rebuild failed: %v", err)
+				}
+				break
+			}
+		}
+	}
+	revisedCoinDays.Clear(ctx, takenAt)
+}
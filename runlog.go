@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	RunRunning   = "running"
+	RunOK        = "ok"
+	RunWithError = "completed_with_errors"
+	RunFailed    = "failed"
+)
+
+// runFlushEvery is how many task results are buffered before they are
+// written to the task results table.
+const runFlushEvery = 1000
+
+// RunRecord is one row of etl_runs. Errors counts every failed task attempt,
+// Retried the attempts that were retries of an earlier one.
+type RunRecord struct {
+	ID         string
+	Kind       string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Status     string
+	ConfigHash string
+	Tasks      int
+	Inserted   int
+	APIDays    int
+	Errors     int
+	Retried    int
+	Missing    int
+	Error      string
+}
+
+type taskResultRow struct {
+	TaskResult
+	At time.Time
+}
+
+// etlRun records a single call of one of the phase runners and the outcome
+// of every task it dispatched. Audit write failures are logged and never
+// fail the run itself.
+type etlRun struct {
+	cfg Config
+	db  *sql.DB
+	rec RunRecord
+	buf []taskResultRow
+}
+
+func startRun(ctx context.Context, cfg Config, db *sql.DB, kind string) *etlRun {
+	r := &etlRun{
+		cfg: cfg,
+		db:  db,
+		rec: RunRecord{
+			ID:         uuid.NewString(),
+			Kind:       kind,
+			StartedAt:  time.Now().UTC(),
+			Status:     RunRunning,
+			ConfigHash: configHash(cfg),
+		},
+	}
+	if err := insertRun(ctx, db, cfg.CHRunsTable, r.rec); err != nil {
+		log.WithField("run", kind).Warnf("run log insert failed: %v", err)
+	}
+	return r
+}
+
+func (r *etlRun) record(ctx context.Context, res TaskResult) {
+	r.rec.Tasks++
+	r.rec.Inserted += res.Inserted
+	r.rec.APIDays += res.APIDays
+	r.rec.Missing += len(res.MissingDates)
+	if res.Err != "" {
+		r.rec.Errors++
+	}
+	if res.Task.Retry > 0 {
+		r.rec.Retried++
+	}
+
+	// Base currency tasks leave VsCurrency empty.
+	res.Task.VsCurrency = res.Task.vs(r.cfg)
+	r.buf = append(r.buf, taskResultRow{TaskResult: res, At: time.Now().UTC()})
+	if len(r.buf) >= runFlushEvery {
+		r.flush(ctx)
+	}
+}
+
+func (r *etlRun) flush(ctx context.Context) {
+	if err := insertTaskResults(ctx, r.db, r.cfg.CHTaskResultsTable, r.rec.ID, r.buf); err != nil {
+		log.WithFields(log.Fields{
+			"run":     r.rec.Kind,
+			"results": len(r.buf),
+		}).Warnf("task results insert failed: %v", err)
+	}
+	r.buf = r.buf[:0]
+}
+
+// finish writes the remaining task results and the final run row. It still
+// writes when ctx is already cancelled, so interrupted runs are recorded.
+func (r *etlRun) finish(ctx context.Context, err error) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+	defer cancel()
+
+	r.flush(ctx)
+
+	r.rec.FinishedAt = time.Now().UTC()
+	switch {
+	case err != nil:
+		r.rec.Status = RunFailed
+		r.rec.Error = err.Error()
+	case r.rec.Errors > 0:
+		r.rec.Status = RunWithError
+	default:
+		r.rec.Status = RunOK
+	}
+	if err := insertRun(ctx, r.db, r.cfg.CHRunsTable, r.rec); err != nil {
+		log.WithField("run", r.rec.Kind).Warnf("run log insert failed: %v", err)
+	}
+}
+
+// configHash identifies the settings a run was made with, without secrets.
+func configHash(cfg Config) string {
+	cfg.CGAPIKey = ""
+	cfg.CHPassword = ""
+	b, _ := json.Marshal(cfg)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
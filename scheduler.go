@@ -3,26 +3,55 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"strings"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type TaskPhase string
 
+// errTasksFailed is returned by the phase runners when they ran to the end
+// but some tasks still failed after their retries.
+var errTasksFailed = errors.New("tasks failed after retries")
+
 const (
+	PhaseDiscovery   TaskPhase = "discovery"
 	PhaseBackfill    TaskPhase = "backfill"
 	PhaseIncremental TaskPhase = "incremental"
+	PhaseGapFill     TaskPhase = "gapfill"
+	PhaseRevision    TaskPhase = "revision"
+
+	// PhaseExchangeVolume tasks fetch the BTC volume of the exchange in
+	// CoinID rather than a coin's market chart.
+	PhaseExchangeVolume TaskPhase = "exchange_volume"
 )
 
+const progressEvery = 200
+
 type Task struct {
-	CoinID string
-	Symbol string
-	From   time.Time
-	To     time.Time
-	Retry  int
-	Phase  TaskPhase
+	CoinID     string
+	Symbol     string
+	VsCurrency string
+	From       time.Time
+	To         time.Time
+	Retry      int
+	Phase      TaskPhase
+
+	// span is the task's root span, started when the task reaches the head
+	// of the queue; dispatchedAt marks that moment for the dispatch span.
+	span         trace.SpanContext
+	dispatchedAt time.Time
+}
+
+func (t Task) vs(cfg Config) string {
+	if t.VsCurrency != "" {
+		return t.VsCurrency
+	}
+	return cfg.VsCurrency
 }
 
 type TaskResult struct {
@@ -33,17 +62,12 @@ type TaskResult struct {
 	HTTPStatus   int
 	Err          string
 	MissingDates []string
+	EmptyDays    int
+	Revised      int
+	Quarantined  int
 	ActiveNow    bool
-}
-
-type CoinState struct {
-	SearchEnd   time.Time
-	SearchEmpty int
-	SeenData    bool
-
-	ConsecutiveEmpty int
-
-	Done bool
+	DataFrom     time.Time
+	DataTo       time.Time
 }
 
 func makeTaskFixedWindow(coinID, symbol string, end time.Time, startLimit time.Time) (Task, bool) {
@@ -65,296 +89,278 @@ func makeTaskFixedWindow(coinID, symbol string, end time.Time, startLimit time.T
 	}, true
 }
 
-func RunBackfill(ctx context.Context, cfg Config, db *sql.DB, coins []Coin, tasks chan<- Task, results <-chan TaskResult) (map[string]Coin, error) {
-	startLimit := cfg.StartDate
-	yday := yesterdayUTC()
+// runTasks feeds pending tasks to the workers and hands every result to onResult.
+// Tasks returned by onResult are queued ahead of the remaining ones.
+func runTasks(ctx context.Context, pending []Task, tasks chan<- Task, results <-chan TaskResult, onResult func(TaskResult) []Task) error {
+	inFlight := 0
+	progress := newPhaseProgress(pending)
+	spans := make(map[trace.SpanID]trace.Span)
+	defer func() {
+		metricTaskQueue.Set(0)
+		metricTasksInFlight.Set(0)
+		for _, span := range spans {
+			span.End()
+		}
+	}()
 
-	states := make(map[string]*CoinState, len(coins))
-	active := make(map[string]Coin)
+	for len(pending) > 0 || inFlight > 0 {
+		metricTaskQueue.Set(float64(len(pending)))
+		metricTasksInFlight.Set(float64(inFlight))
 
-	coinSymbolByID := make(map[string]string, len(coins))
-	for _, c := range coins {
-		id := strings.TrimSpace(c.ID)
-		sym := strings.ToUpper(strings.TrimSpace(c.Symbol))
-		if id == "" || sym == "" {
-			continue
+		var outCh chan<- Task
+		var next Task
+		if len(pending) > 0 {
+			if !pending[0].span.IsValid() {
+				pending[0] = startTaskSpan(ctx, pending[0], spans)
+			}
+			outCh = tasks
+			next = pending[0]
 		}
-		coinSymbolByID[id] = sym
-		if cfg.CoinIDsFilter != nil && !cfg.CoinIDsFilter[id] {
-			continue
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+
+		case res := <-results:
+			inFlight--
+			status.Beat()
+			progress.add(res.Task.Phase, progressInFlight, -1)
+			progress.add(res.Task.Phase, progressDone, 1)
+			metricTasks.WithLabelValues(string(res.Task.Phase), taskOutcome(res)).Inc()
+			endTaskSpan(res, spans)
+
+			if more := onResult(res); len(more) > 0 {
+				for i, t := range more {
+					more[i].span = trace.SpanContext{}
+					if t.Retry > 0 {
+						metricRetries.WithLabelValues("task", string(t.Phase)).Inc()
+					}
+					progress.add(t.Phase, progressPending, 1)
+				}
+				pending = append(more, pending...)
+			}
+
+		case outCh <- next:
+			pending = pending[1:]
+			inFlight++
+			status.Beat()
+			progress.add(next.Phase, progressPending, -1)
+			progress.add(next.Phase, progressInFlight, 1)
 		}
-		states[id] = &CoinState{SearchEnd: yday}
 	}
+	return nil
+}
+
+// backfillTasks splits [from, to] into fixed windows, newest first.
+func backfillTasks(id, sym string, from, to time.Time) []Task {
+	var out []Task
+	for end := to; !end.Before(from); {
+		t, ok := makeTaskFixedWindow(id, sym, end, from)
+		if !ok {
+			break
+		}
+		out = append(out, t)
+		end = t.From.AddDate(0, 0, -1)
+	}
+	return out
+}
+
+func RunBackfill(ctx context.Context, cfg Config, db *sql.DB, bounds map[string]CoinBounds, tasks chan<- Task, results <-chan TaskResult) (map[string]Coin, error) {
+	status.SetPhase(string(PhaseBackfill))
 
-	total := len(states)
-	if total == 0 {
-		log.Warn("backfill: no coins to process (after filters)")
+	startLimit := cfg.StartDate
+	yday := yesterdayUTC()
+
+	stored, err := getDateRanges(ctx, db, cfg.CHTable, cfg.VsCurrency)
+	if err != nil {
+		return make(map[string]Coin), err
+	}
+
+	pending, active, complete := planBackfill(cfg, bounds, stored, yday)
+	for _, id := range complete {
+		status.SetCoinState(id, CoinBackfilled)
+	}
+	remaining := make(map[string]int)
+	for _, t := range pending {
+		remaining[t.CoinID]++
+	}
+	for id := range remaining {
+		status.SetCoinState(id, CoinBackfill)
+	}
+	coins := len(remaining)
+
+	if len(pending) == 0 {
+		log.WithField("active_coins", len(active)).Info("backfill: nothing to do")
 		return active, nil
 	}
 
 	log.WithFields(log.Fields{
-		"coins":         total,
+		"coins":         coins,
+		"tasks":         len(pending),
 		"start_date":    formatDate(startLimit),
 		"yesterday":     formatDate(yday),
 		"workers":       cfg.Workers,
-		"empty_stop":    cfg.EmptyStopBlocks,
-		"max_search":    cfg.MaxSearchBlocks,
 		"retry_per_blk": cfg.MaxRetriesPerBlock,
 	}).Info("backfill started")
 
-	const progressEvery = 200
-
-	round := 0
-	for {
-
-		pending := make([]Task, 0, total)
-		doneCount := 0
-		scheduledCoins := 0
-
-		for id, st := range states {
-			if st.Done {
-				doneCount++
-				continue
-			}
-
-			var end time.Time
-			if round == 0 {
-				end = yday
-			} else {
-
-				if minD, ok, err := getMinDate(ctx, db, cfg.CHTable, id); err == nil && ok {
-					end = dateOnlyUTC(minD.AddDate(0, 0, -1))
-				} else {
-
-					end = st.SearchEnd
-				}
-			}
-
-			sym := coinSymbolByID[id]
-			if sym == "" {
-				sym = strings.ToUpper(id)
-			}
-
-			t, ok := makeTaskFixedWindow(id, sym, end, startLimit)
-			if !ok {
-				st.Done = true
-				doneCount++
-				continue
-			}
-
-			pending = append(pending, t)
-			scheduledCoins++
+	var (
+		doneTasks     = 0
+		sumInserted   = 0
+		sumErrors     = 0
+		sumEmpty      = 0
+		sumQuarantine = 0
+		sumRetried    = 0
+		sumMissingDay = 0
+	)
+
+	run := startRun(ctx, cfg, db, string(PhaseBackfill))
+	err = runTasks(ctx, pending, tasks, results, func(res TaskResult) []Task {
+		run.record(ctx, res)
+		doneTasks++
+
+		if len(res.MissingDates) > 0 && res.Task.Retry < cfg.MaxRetriesPerBlock {
+			rt := res.Task
+			rt.Retry++
+			sumRetried++
+			sumMissingDay += len(res.MissingDates)
+
+			log.WithFields(log.Fields{
+				"id":      res.Task.CoinID,
+				"symbol":  res.Task.Symbol,
+				"from":    formatDate(res.Task.From),
+				"to":      formatDate(res.Task.To),
+				"retry":   rt.Retry,
+				"missing": len(res.MissingDates),
+			}).Warn("block has missing days; retry scheduled")
+			return []Task{rt}
 		}
-
-		if doneCount == total {
-			break
+		if res.Err != "" && isRetryableStatus(res.HTTPStatus) && res.Task.Retry < cfg.MaxRetriesPerBlock {
+			rt := res.Task
+			rt.Retry++
+			sumRetried++
+
+			log.WithFields(log.Fields{
+				"id":     res.Task.CoinID,
+				"symbol": res.Task.Symbol,
+				"from":   formatDate(res.Task.From),
+				"to":     formatDate(res.Task.To),
+				"retry":  rt.Retry,
+				"status": res.HTTPStatus,
+			}).Warnf("task error: %s; retry scheduled", res.Err)
+			return []Task{rt}
 		}
 
-		log.WithFields(log.Fields{
-			"round":          round,
-			"scheduledCoins": scheduledCoins,
-			"done":           doneCount,
-			"total":          total,
-			"queue":          len(pending),
-		}).Info("backfill round scheduled")
-
-		var (
-			inFlight      = 0
-			doneTasks     = 0
-			sumInserted   = 0
-			sumErrors     = 0
-			sumEmpty      = 0
-			sumRetried    = 0
-			sumMissingDay = 0
-		)
-
-		for len(pending) > 0 || inFlight > 0 {
-			select {
-			case <-ctx.Done():
-				return active, ctx.Err()
-
-			case res := <-results:
-				inFlight--
-				doneTasks++
-
-				st := states[res.Task.CoinID]
-				if st == nil || st.Done {
-					continue
-				}
-
-				if len(res.MissingDates) > 0 && res.Task.Retry < cfg.MaxRetriesPerBlock {
-					rt := res.Task
-					rt.Retry++
-					pending = append([]Task{rt}, pending...)
-					sumRetried++
-					sumMissingDay += len(res.MissingDates)
-
-					log.WithFields(log.Fields{
-						"id":      res.Task.CoinID,
-						"symbol":  res.Task.Symbol,
-						"from":    formatDate(res.Task.From),
-						"to":      formatDate(res.Task.To),
-						"retry":   rt.Retry,
-						"missing": len(res.MissingDates),
-						"round":   round,
-					}).Warn("block has missing days; retry scheduled")
-					continue
-				}
+		sumInserted += res.Inserted
+		sumQuarantine += res.Quarantined
+		if res.Err != "" {
+			sumErrors++
+			log.WithFields(log.Fields{
+				"id":     res.Task.CoinID,
+				"symbol": res.Task.Symbol,
+				"from":   formatDate(res.Task.From),
+				"to":     formatDate(res.Task.To),
+			}).Warnf("task error: %s", res.Err)
+		}
 
-				sumInserted += res.Inserted
-				if res.Err != "" {
-					sumErrors++
-					log.WithFields(log.Fields{
-						"id":     res.Task.CoinID,
-						"symbol": res.Task.Symbol,
-						"from":   formatDate(res.Task.From),
-						"to":     formatDate(res.Task.To),
-						"round":  round,
-					}).Warnf("task error: %s", res.Err)
-				}
+		if res.Empty {
+			sumEmpty++
+		}
 
-				if res.Empty {
-					sumEmpty++
-				}
+		if res.ActiveNow {
+			active[res.Task.CoinID] = Coin{ID: res.Task.CoinID, Symbol: strings.ToLower(res.Task.Symbol)}
+		}
 
-				if res.ActiveNow {
-					active[res.Task.CoinID] = Coin{ID: res.Task.CoinID, Symbol: strings.ToLower(res.Task.Symbol)}
-				}
+		remaining[res.Task.CoinID]--
+		if remaining[res.Task.CoinID] == 0 {
+			status.SetCoinState(res.Task.CoinID, CoinBackfilled)
+		}
 
-				blockHasData := (res.Err == "" && !res.Empty && res.APIDays > 0)
-
-				if blockHasData {
-					if !st.SeenData {
-						log.WithFields(log.Fields{
-							"id":     res.Task.CoinID,
-							"symbol": res.Task.Symbol,
-							"from":   formatDate(res.Task.From),
-							"to":     formatDate(res.Task.To),
-							"round":  round,
-							"days":   res.APIDays,
-						}).Info("first data seen for coin")
-					}
-					st.SeenData = true
-					st.SearchEmpty = 0
-					st.ConsecutiveEmpty = 0
-				} else {
-					if st.SeenData {
-						st.ConsecutiveEmpty++
-						log.WithFields(log.Fields{
-							"id":        res.Task.CoinID,
-							"symbol":    res.Task.Symbol,
-							"from":      formatDate(res.Task.From),
-							"to":        formatDate(res.Task.To),
-							"round":     round,
-							"empty_seq": st.ConsecutiveEmpty,
-						}).Debug("empty block after data (counting towards stop)")
-					} else {
-						st.SearchEmpty++
-
-						st.SearchEnd = dateOnlyUTC(res.Task.From.AddDate(0, 0, -1))
-						log.WithFields(log.Fields{
-							"id":          res.Task.CoinID,
-							"symbol":      res.Task.Symbol,
-							"from":        formatDate(res.Task.From),
-							"to":          formatDate(res.Task.To),
-							"round":       round,
-							"searchEmpty": st.SearchEmpty,
-							"nextEnd":     formatDate(st.SearchEnd),
-						}).Debug("no data yet; keep searching older")
-					}
-				}
+		if doneTasks%progressEvery == 0 {
+			log.WithFields(log.Fields{
+				"doneTasks":   doneTasks,
+				"insertedSum": sumInserted,
+				"errors":      sumErrors,
+				"empty":       sumEmpty,
+				"retried":     sumRetried,
+				"active":      len(active),
+			}).Info("backfill progress")
+		}
+		return nil
+	})
+	run.finish(ctx, err)
+	if err != nil {
+		return active, err
+	}
 
-				if st.SeenData && st.ConsecutiveEmpty >= cfg.EmptyStopBlocks {
-					st.Done = true
-					log.WithFields(log.Fields{
-						"id":     res.Task.CoinID,
-						"symbol": res.Task.Symbol,
-						"round":  round,
-					}).Info("coin backfill completed (empty tail reached)")
-				}
+	log.WithFields(log.Fields{
+		"coins":          coins,
+		"doneTasks":      doneTasks,
+		"insertedSum":    sumInserted,
+		"errors":         sumErrors,
+		"empty":          sumEmpty,
+		"quarantined":    sumQuarantine,
+		"retried":        sumRetried,
+		"missingDaysSum": sumMissingDay,
+		"active_coins":   len(active),
+	}).Info("backfill finished")
 
-				if !st.SeenData && st.SearchEmpty >= cfg.MaxSearchBlocks {
-					st.Done = true
-					log.WithFields(log.Fields{
-						"id":     res.Task.CoinID,
-						"symbol": res.Task.Symbol,
-						"round":  round,
-					}).Warn("coin has no data within search window; stopping")
-				}
+	if sumErrors > 0 {
+		return active, fmt.Errorf("backfill: %w: %d tasks", errTasksFailed, sumErrors)
+	}
+	return active, nil
+}
 
-				if doneTasks%progressEvery == 0 {
-					log.WithFields(log.Fields{
-						"round":       round,
-						"doneTasks":   doneTasks,
-						"inFlight":    inFlight,
-						"queue":       len(pending),
-						"insertedSum": sumInserted,
-						"errors":      sumErrors,
-						"empty":       sumEmpty,
-						"retried":     sumRetried,
-						"active":      len(active),
-					}).Info("backfill round progress")
-				}
+// planBackfill returns the tasks that fill the part of each coin's bounds
+// missing from stored, the coins whose bounds reach the last days, and the
+// coins with nothing left to fill.
+func planBackfill(cfg Config, bounds map[string]CoinBounds, stored map[string]dateRange, yday time.Time) (tasks []Task, active map[string]Coin, complete []string) {
+	startLimit := cfg.StartDate
+	activeSince := yday.AddDate(0, 0, -2)
+	active = make(map[string]Coin)
 
-			default:
+	for id, b := range bounds {
+		if !b.HasData {
+			continue
+		}
+		if cfg.CoinIDsFilter != nil && !cfg.CoinIDsFilter[id] {
+			continue
+		}
+		sym := strings.ToUpper(strings.TrimSpace(b.Symbol))
+		if sym == "" {
+			sym = strings.ToUpper(id)
+		}
 
-				var outCh chan<- Task
-				var next Task
-				if len(pending) > 0 {
-					outCh = tasks
-					next = pending[0]
-				}
+		if !b.Last.Before(activeSince) {
+			active[id] = Coin{ID: id, Symbol: strings.ToLower(sym)}
+		}
 
-				select {
-				case <-ctx.Done():
-					return active, ctx.Err()
-				case outCh <- next:
-					pending = pending[1:]
-					inFlight++
-				default:
-					time.Sleep(10 * time.Millisecond)
-				}
-			}
+		from := b.First
+		if from.Before(startLimit) {
+			from = startLimit
+		}
+		to := b.Last
+		if to.After(yday) {
+			to = yday
+		}
+		if to.Before(from) {
+			continue
 		}
 
-		doneNow := 0
-		seenData := 0
-		searching := 0
-		for _, st := range states {
-			if st.Done {
-				doneNow++
-				continue
-			}
-			if st.SeenData {
-				seenData++
-			} else {
-				searching++
-			}
+		var coinTasks []Task
+		if r, ok := stored[id]; ok {
+			coinTasks = append(coinTasks, backfillTasks(id, sym, r.Max.AddDate(0, 0, 1), to)...)
+			coinTasks = append(coinTasks, backfillTasks(id, sym, from, r.Min.AddDate(0, 0, -1))...)
+		} else {
+			coinTasks = backfillTasks(id, sym, from, to)
 		}
 
-		log.WithFields(log.Fields{
-			"round":          round,
-			"doneTasks":      doneTasks,
-			"insertedSum":    sumInserted,
-			"errors":         sumErrors,
-			"empty":          sumEmpty,
-			"retried":        sumRetried,
-			"missingDaysSum": sumMissingDay,
-			"doneCoins":      doneNow,
-			"seenDataCoins":  seenData,
-			"searchingCoins": searching,
-			"activeCoins":    len(active),
-		}).Info("backfill round finished")
-
-		round++
+		if len(coinTasks) == 0 {
+			complete = append(complete, id)
+			continue
+		}
+		tasks = append(tasks, coinTasks...)
 	}
-
-	log.WithFields(log.Fields{
-		"coins_total":  total,
-		"active_coins": len(active),
-	}).Info("backfill finished")
-
-	return active, nil
+	return tasks, active, complete
 }
 
 func BuildIncrementalTasks(cfg Config, activeCoins []Coin, maxDates map[string]time.Time) []Task {
@@ -399,3 +405,48 @@ func BuildIncrementalTasks(cfg Config, activeCoins []Coin, maxDates map[string]t
 	}
 	return tasks
 }
+
+// BuildRevisionTasks re-fetches the last cfg.RevisionDays stored days of every
+// active coin, since CoinGecko keeps revising recent market caps and volumes.
+func BuildRevisionTasks(cfg Config, activeCoins []Coin, maxDates map[string]time.Time) []Task {
+	if cfg.RevisionDays <= 0 {
+		return nil
+	}
+
+	yday := yesterdayUTC()
+	from := yday.AddDate(0, 0, -(cfg.RevisionDays - 1))
+	if from.Before(cfg.StartDate) {
+		from = cfg.StartDate
+	}
+
+	var tasks []Task
+	for _, c := range activeCoins {
+		if cfg.CoinIDsFilter != nil && !cfg.CoinIDsFilter[c.ID] {
+			continue
+		}
+		id := strings.TrimSpace(c.ID)
+		sym := strings.ToUpper(strings.TrimSpace(c.Symbol))
+		if id == "" || sym == "" {
+			continue
+		}
+
+		maxD, ok := maxDates[id]
+		if !ok || maxD.Before(from) {
+			continue
+		}
+		to := maxD
+		if to.After(yday) {
+			to = yday
+		}
+
+		tasks = append(tasks, Task{
+			CoinID: id,
+			Symbol: sym,
+			From:   from,
+			To:     to,
+			Retry:  0,
+			Phase:  PhaseRevision,
+		})
+	}
+	return tasks
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	CoinSearching  = "searching"
+	CoinDiscovered = "discovered"
+	CoinNoData     = "no_data"
+	CoinFailed     = "failed"
+	CoinBackfill   = "backfilling"
+	CoinBackfilled = "backfilled"
+)
+
+// etlStatus is the process-wide view of the scheduler served by /status and
+// used by /healthz to detect a stalled task loop.
+type etlStatus struct {
+	mu sync.RWMutex
+
+	startedAt       time.Time
+	phase           string
+	phaseSince      time.Time
+	round           int
+	heartbeat       time.Time
+	lastIncremental time.Time
+	activeCoins     int
+	coins           map[string]string
+}
+
+var status = &etlStatus{
+	startedAt: time.Now().UTC(),
+	phase:     "startup",
+	coins:     make(map[string]string),
+}
+
+func (s *etlStatus) SetPhase(phase string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.phase = phase
+	s.phaseSince = time.Now().UTC()
+	s.heartbeat = s.phaseSince
+}
+
+func (s *etlStatus) SetRound(round int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.round = round
+}
+
+func (s *etlStatus) Beat() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.heartbeat = time.Now().UTC()
+}
+
+func (s *etlStatus) SetCoinState(id, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.coins[id] = state
+}
+
+func (s *etlStatus) SetActiveCoins(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.activeCoins = n
+}
+
+func (s *etlStatus) IncrementalDone(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastIncremental = t.UTC()
+}
+
+// Stalled reports whether a working phase has gone longer than timeout
+// without any task activity.
+func (s *etlStatus) Stalled(timeout time.Duration) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.phase == "idle" || timeout <= 0 || s.heartbeat.IsZero() {
+		return false
+	}
+	return time.Since(s.heartbeat) > timeout
+}
+
+type StatusSnapshot struct {
+	StartedAt       time.Time         `json:"started_at"`
+	Phase           string            `json:"phase"`
+	PhaseSince      time.Time         `json:"phase_since"`
+	Round           int               `json:"round"`
+	Heartbeat       time.Time         `json:"heartbeat"`
+	LastIncremental *time.Time        `json:"last_incremental_success,omitempty"`
+	ActiveCoins     int               `json:"active_coins"`
+	CoinStates      map[string]int    `json:"backfill_coin_states"`
+	Coins           map[string]string `json:"coins,omitempty"`
+}
+
+func (s *etlStatus) Snapshot(withCoins bool) StatusSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := StatusSnapshot{
+		StartedAt:   s.startedAt,
+		Phase:       s.phase,
+		PhaseSince:  s.phaseSince,
+		Round:       s.round,
+		Heartbeat:   s.heartbeat,
+		ActiveCoins: s.activeCoins,
+		CoinStates:  make(map[string]int),
+	}
+	if !s.lastIncremental.IsZero() {
+		t := s.lastIncremental
+		out.LastIncremental = &t
+	}
+	for _, st := range s.coins {
+		out.CoinStates[st]++
+	}
+	if withCoins {
+		out.Coins = make(map[string]string, len(s.coins))
+		for id, st := range s.coins {
+			out.Coins[id] = st
+		}
+	}
+	return out
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const serviceName = "cg-range-etl"
+
+// tracer is resolved through the global provider, so spans are no-ops until
+// setupTracing installs an exporter.
+var tracer = otel.Tracer(serviceName)
+
+// setupTracing installs the global tracer provider for cfg.TraceExporter and
+// returns its shutdown func, which flushes buffered spans.
+func setupTracing(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	var (
+		exp sdktrace.SpanExporter
+		err error
+	)
+	switch strings.ToLower(cfg.TraceExporter) {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "stdout":
+		exp, err = stdouttrace.New()
+	case "otlp":
+		exp, err = otlptracehttp.New(ctx)
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", cfg.TraceExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithFromEnv(),
+		resource.WithAttributes(attribute.String("service.name", serviceName)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.TraceSampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+func taskAttrs(t Task) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("coin.id", t.CoinID),
+		attribute.String("coin.symbol", t.Symbol),
+		attribute.String("task.phase", string(t.Phase)),
+		attribute.String("task.vs_currency", t.VsCurrency),
+		attribute.String("window.from", formatDate(t.From)),
+		attribute.String("window.to", formatDate(t.To)),
+		attribute.Int("window.days", daysBetween(t.From, t.To)+1),
+		attribute.Int("task.retry", t.Retry),
+	}
+}
+
+// startTaskSpan opens the root span of a task about to be dispatched and
+// keeps it in spans until its result comes back.
+func startTaskSpan(ctx context.Context, t Task, spans map[trace.SpanID]trace.Span) Task {
+	_, span := tracer.Start(ctx, "task", trace.WithNewRoot(), trace.WithAttributes(taskAttrs(t)...))
+	t.span = span.SpanContext()
+	t.dispatchedAt = time.Now()
+	if t.span.IsValid() {
+		spans[t.span.SpanID()] = span
+	}
+	return t
+}
+
+func endTaskSpan(res TaskResult, spans map[trace.SpanID]trace.Span) {
+	id := res.Task.span.SpanID()
+	span, ok := spans[id]
+	if !ok {
+		return
+	}
+	delete(spans, id)
+
+	span.SetAttributes(
+		attribute.String("task.outcome", taskOutcome(res)),
+		attribute.Int("http.status_code", res.HTTPStatus),
+		attribute.Int("task.api_days", res.APIDays),
+		attribute.Int("task.inserted", res.Inserted),
+		attribute.Int("task.missing_days", len(res.MissingDates)),
+	)
+	if res.Err != "" {
+		span.SetStatus(codes.Error, res.Err)
+	}
+	span.End()
+}
+
+// taskContext parents the worker's spans under the task span and records the
+// time the task waited for a free worker.
+func taskContext(ctx context.Context, t Task) context.Context {
+	if !t.span.IsValid() {
+		return ctx
+	}
+	ctx = trace.ContextWithSpanContext(ctx, t.span)
+	_, span := tracer.Start(ctx, "task.dispatch", trace.WithTimestamp(t.dispatchedAt))
+	span.End()
+	return ctx
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
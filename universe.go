@@ -0,0 +1,396 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type UniverseCoin struct {
+	Coin
+	Active    bool
+	FirstSeen time.Time
+}
+
+type UniverseDiff struct {
+	Listed   []Coin
+	Delisted []Coin
+	Relisted []Coin
+	Changed  []UniverseCoin
+	Events   []UniverseEvent
+}
+
+const (
+	EventListed        = "listed"
+	EventDelisted      = "delisted"
+	EventRelisted      = "relisted"
+	EventSymbolChanged = "symbol_changed"
+	EventNameChanged   = "name_changed"
+	EventIDMigrated    = "id_migrated"
+)
+
+type UniverseEvent struct {
+	At   time.Time
+	ID   string
+	Kind string
+	Old  string
+	New  string
+}
+
+// SymbolVersion is one row of the coins_universe history: the symbol and name
+// a coin id carried from ValidFrom until ValidTo (zero while current).
+type SymbolVersion struct {
+	ID        string
+	Symbol    string
+	Name      string
+	ValidFrom time.Time
+	ValidTo   time.Time
+}
+
+// historyStart is the valid_from of a coin's first version, so that days
+// stored before the coin was first seen resolve to its earliest symbol.
+var historyStart = time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// SymbolHistory resolves the symbol in effect for a coin on a given day.
+// It is shared by the workers and swapped after every universe refresh.
+type SymbolHistory struct {
+	mu   sync.RWMutex
+	byID map[string][]SymbolVersion
+}
+
+func (h *SymbolHistory) Set(byID map[string][]SymbolVersion) {
+	for _, vs := range byID {
+		sort.Slice(vs, func(i, j int) bool { return vs[i].ValidFrom.Before(vs[j].ValidFrom) })
+	}
+	h.mu.Lock()
+	h.byID = byID
+	h.mu.Unlock()
+}
+
+func (h *SymbolHistory) SymbolAt(id string, day time.Time) (string, bool) {
+	if h == nil {
+		return "", false
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	vs := h.byID[id]
+	if len(vs) == 0 {
+		return "", false
+	}
+	sym := vs[0].Symbol
+	for _, v := range vs {
+		if v.ValidFrom.After(day) {
+			break
+		}
+		sym = v.Symbol
+	}
+	return strings.ToUpper(sym), sym != ""
+}
+
+// diffUniverse compares a fresh /coins/list snapshot with the stored universe.
+// Changed holds every coin whose stored row must be rewritten.
+func diffUniverse(stored map[string]UniverseCoin, all, active []Coin, now time.Time) UniverseDiff {
+	activeIDs := make(map[string]bool, len(active))
+	for _, c := range active {
+		activeIDs[c.ID] = true
+	}
+
+	var d UniverseDiff
+	event := func(id, kind, old, cur string) {
+		d.Events = append(d.Events, UniverseEvent{At: now, ID: id, Kind: kind, Old: old, New: cur})
+	}
+
+	seen := make(map[string]bool, len(all))
+	var added []Coin
+	for _, c := range all {
+		seen[c.ID] = true
+		isActive := activeIDs[c.ID]
+
+		old, ok := stored[c.ID]
+		if !ok {
+			if isActive {
+				d.Listed = append(d.Listed, c)
+				if len(stored) > 0 {
+					event(c.ID, EventListed, "", c.Symbol)
+				}
+			}
+			added = append(added, c)
+			d.Changed = append(d.Changed, UniverseCoin{Coin: c, Active: isActive, FirstSeen: now})
+			continue
+		}
+
+		changed := false
+		if old.Active && !isActive {
+			d.Delisted = append(d.Delisted, c)
+			event(c.ID, EventDelisted, "", "")
+			changed = true
+		}
+		if !old.Active && isActive {
+			d.Relisted = append(d.Relisted, c)
+			event(c.ID, EventRelisted, "", "")
+			changed = true
+		}
+		if old.Symbol != c.Symbol {
+			event(c.ID, EventSymbolChanged, old.Symbol, c.Symbol)
+			changed = true
+		}
+		if old.Name != c.Name {
+			event(c.ID, EventNameChanged, old.Name, c.Name)
+			changed = true
+		}
+		if changed {
+			d.Changed = append(d.Changed, UniverseCoin{Coin: c, Active: isActive, FirstSeen: old.FirstSeen})
+		}
+	}
+
+	// An id that vanished while a new one with the same symbol and name
+	// appeared is treated as a migration rather than a delisting.
+	addedByKey := make(map[string]Coin, len(added))
+	for _, c := range added {
+		addedByKey[strings.ToLower(c.Symbol+"|"+c.Name)] = c
+	}
+
+	ids := make([]string, 0)
+	for id := range stored {
+		if !seen[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+
+	for _, id := range ids {
+		old := stored[id]
+		if c, ok := addedByKey[strings.ToLower(old.Symbol+"|"+old.Name)]; ok {
+			event(id, EventIDMigrated, id, c.ID)
+		} else if old.Active {
+			d.Delisted = append(d.Delisted, old.Coin)
+			event(id, EventDelisted, "", "")
+		}
+		if old.Active {
+			d.Changed = append(d.Changed, UniverseCoin{Coin: old.Coin, Active: false, FirstSeen: old.FirstSeen})
+		}
+	}
+
+	sort.Slice(d.Delisted, func(i, j int) bool { return d.Delisted[i].ID < d.Delisted[j].ID })
+	return d
+}
+
+// universeVersions returns the coins_universe rows to write for a snapshot:
+// the closed previous version and the new open one for every symbol or name
+// change, and a first version for ids without history.
+func universeVersions(current map[string]SymbolVersion, all []Coin, today time.Time) []SymbolVersion {
+	var out []SymbolVersion
+	for _, c := range all {
+		cur, ok := current[c.ID]
+		switch {
+		case !ok:
+			out = append(out, SymbolVersion{ID: c.ID, Symbol: c.Symbol, Name: c.Name, ValidFrom: historyStart})
+		case cur.Symbol == c.Symbol && cur.Name == c.Name:
+			continue
+		case !cur.ValidFrom.Before(today):
+			out = append(out, SymbolVersion{ID: c.ID, Symbol: c.Symbol, Name: c.Name, ValidFrom: cur.ValidFrom})
+		default:
+			cur.ValidTo = today.AddDate(0, 0, -1)
+			out = append(out, cur)
+			out = append(out, SymbolVersion{ID: c.ID, Symbol: c.Symbol, Name: c.Name, ValidFrom: today})
+		}
+	}
+	return out
+}
+
+// saveUniverse stores the snapshot, its symbol history and change events,
+// and returns what changed since the last one.
+func saveUniverse(ctx context.Context, cfg Config, db *sql.DB, syms *SymbolHistory, all, active []Coin) (UniverseDiff, error) {
+	stored, err := getUniverse(ctx, db, cfg.CHCoinsTable)
+	if err != nil {
+		return UniverseDiff{}, err
+	}
+	if cfg.CoinIDsFilter != nil {
+		for id := range stored {
+			if !cfg.CoinIDsFilter[id] {
+				delete(stored, id)
+			}
+		}
+	}
+
+	history, err := getSymbolHistory(ctx, db, cfg.CHUniverseTable)
+	if err != nil {
+		return UniverseDiff{}, err
+	}
+	current := make(map[string]SymbolVersion, len(history))
+	for id, vs := range history {
+		for _, v := range vs {
+			if v.ValidTo.IsZero() {
+				current[id] = v
+			}
+		}
+	}
+
+	now := time.Now().UTC()
+	d := diffUniverse(stored, all, active, now)
+	versions := universeVersions(current, all, dateOnlyUTC(now))
+
+	if err := insertUniverse(ctx, db, cfg.CHCoinsTable, d.Changed); err != nil {
+		return d, err
+	}
+	if err := insertSymbolVersions(ctx, db, cfg.CHUniverseTable, versions); err != nil {
+		return d, err
+	}
+	if err := insertUniverseEvents(ctx, db, cfg.CHUniverseEventsTable, d.Events); err != nil {
+		return d, err
+	}
+
+	if len(versions) > 0 {
+		if history, err = getSymbolHistory(ctx, db, cfg.CHUniverseTable); err != nil {
+			return d, err
+		}
+	}
+	syms.Set(history)
+
+	log.WithFields(log.Fields{
+		"stored":   len(stored),
+		"listed":   len(d.Listed),
+		"delisted": len(d.Delisted),
+		"relisted": len(d.Relisted),
+		"changed":  len(d.Changed),
+		"versions": len(versions),
+		"events":   len(d.Events),
+	}).Info("coin universe saved")
+
+	return d, nil
+}
+
+// refreshUniverse reloads /coins/list, backfills new listings and gives delisted
+// coins a final top-up. It returns the active set for incremental sync, or
+// current unchanged when the lists could not be loaded.
+func refreshUniverse(
+	ctx context.Context,
+	cfg Config,
+	cg *CGClient,
+	db *sql.DB,
+	syms *SymbolHistory,
+	current []Coin,
+	tasksCh chan<- Task,
+	resultsCh <-chan TaskResult,
+) []Coin {
+	status.SetPhase("universe_refresh")
+
+	all, active, err := fetchCoinsLists(ctx, cg, cfg)
+	if err != nil || len(active) == 0 {
+		log.Warn("coin universe refresh skipped: coins list unavailable")
+		return current
+	}
+
+	d, err := saveUniverse(ctx, cfg, db, syms, all, active)
+	if err != nil {
+		log.Warnf("coin universe refresh failed: %v", err)
+		return current
+	}
+
+	onboard := d.Listed
+	if len(d.Relisted) > 0 {
+		// A coin first stored while inactive was never backfilled, and
+		// incremental sync only extends stored history.
+		unstored, err := unstoredCoins(ctx, cfg, db, d.Relisted)
+		if err != nil {
+			log.Warnf("relisted coins: date ranges query failed: %v", err)
+		}
+		onboard = append(onboard[:len(onboard):len(onboard)], unstored...)
+	}
+	if len(onboard) > 0 {
+		onboardCoins(ctx, cfg, db, onboard, tasksCh, resultsCh)
+	}
+	if len(d.Delisted) > 0 {
+		topUpCoins(ctx, cfg, db, d.Delisted, tasksCh, resultsCh)
+	}
+
+	return active
+}
+
+func onboardCoins(ctx context.Context, cfg Config, db *sql.DB, coins []Coin, tasksCh chan<- Task, resultsCh <-chan TaskResult) {
+	log.WithField("coins", len(coins)).Info("onboarding new listings")
+
+	bounds, err := RunDiscovery(ctx, cfg, db, coins, tasksCh, resultsCh)
+	if err != nil {
+		log.Warnf("onboarding discovery failed: %v", err)
+		if !errors.Is(err, errTasksFailed) {
+			return
+		}
+	}
+	if _, err := RunBackfill(ctx, cfg, db, bounds, tasksCh, resultsCh); err != nil {
+		log.Warnf("onboarding backfill failed: %v", err)
+	}
+}
+
+// unstoredCoins returns the coins without stored points.
+func unstoredCoins(ctx context.Context, cfg Config, db *sql.DB, coins []Coin) ([]Coin, error) {
+	ranges, err := getDateRanges(ctx, db, cfg.CHTable, cfg.VsCurrency)
+	if err != nil {
+		return nil, err
+	}
+	var out []Coin
+	for _, c := range coins {
+		if _, ok := ranges[c.ID]; !ok {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// topUpCoins fetches the days after the last stored one for coins that are
+// about to be retired from incremental sync.
+func topUpCoins(ctx context.Context, cfg Config, db *sql.DB, coins []Coin, tasksCh chan<- Task, resultsCh <-chan TaskResult) {
+	status.SetPhase("top_up")
+
+	ranges, err := getDateRanges(ctx, db, cfg.CHTable, cfg.VsCurrency)
+	if err != nil {
+		log.Warnf("top-up: date ranges query failed: %v", err)
+		return
+	}
+
+	maxDates := make(map[string]time.Time, len(coins))
+	for _, c := range coins {
+		if r, ok := ranges[c.ID]; ok {
+			maxDates[c.ID] = r.Max
+		}
+	}
+
+	tasks := BuildIncrementalTasks(cfg, coins, maxDates)
+	log.WithFields(log.Fields{
+		"coins": len(coins),
+		"tasks": len(tasks),
+	}).Info("final top-up for delisted coins")
+
+	inserted := 0
+	run := startRun(ctx, cfg, db, "top_up")
+	err = runTasks(ctx, tasks, tasksCh, resultsCh, func(res TaskResult) []Task {
+		run.record(ctx, res)
+		if (res.Err != "" || len(res.MissingDates) > 0) && res.Task.Retry < cfg.MaxRetriesPerBlock {
+			rt := res.Task
+			rt.Retry++
+			return []Task{rt}
+		}
+		if res.Err != "" {
+			log.WithFields(log.Fields{
+				"id":   res.Task.CoinID,
+				"from": formatDate(res.Task.From),
+				"to":   formatDate(res.Task.To),
+			}).Warnf("top-up task error: %s", res.Err)
+		}
+		inserted += res.Inserted
+		return nil
+	})
+	run.finish(ctx, err)
+	if err != nil {
+		return
+	}
+
+	log.WithField("insertedSum", inserted).Info("final top-up finished")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func coinIDs(cs []Coin) []string {
+	var out []string
+	for _, c := range cs {
+		out = append(out, c.ID)
+	}
+	return out
+}
+
+func TestDiffUniverse(t *testing.T) {
+	now := mustParseDate("2024-06-30")
+	seen := mustParseDate("2023-01-01")
+	btc := Coin{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin"}
+	eth := Coin{ID: "ethereum", Symbol: "eth", Name: "Ethereum"}
+	stored := func(active bool, cs ...Coin) map[string]UniverseCoin {
+		m := make(map[string]UniverseCoin)
+		for _, c := range cs {
+			m[c.ID] = UniverseCoin{Coin: c, Active: active, FirstSeen: seen}
+		}
+		return m
+	}
+
+	tests := []struct {
+		name     string
+		stored   map[string]UniverseCoin
+		all      []Coin
+		active   []Coin
+		listed   []string
+		delisted []string
+		relisted []string
+		events   []string // id:kind:old:new
+		changed  []string // id:active
+	}{
+		{
+			name:    "first snapshot",
+			stored:  map[string]UniverseCoin{},
+			all:     []Coin{btc, eth},
+			active:  []Coin{btc},
+			listed:  []string{"bitcoin"},
+			changed: []string{"bitcoin:true", "ethereum:false"},
+		},
+		{
+			name:   "unchanged",
+			stored: stored(true, btc, eth),
+			all:    []Coin{btc, eth},
+			active: []Coin{btc, eth},
+		},
+		{
+			name:    "listed",
+			stored:  stored(true, btc),
+			all:     []Coin{btc, eth},
+			active:  []Coin{btc, eth},
+			listed:  []string{"ethereum"},
+			events:  []string{"ethereum:listed::eth"},
+			changed: []string{"ethereum:true"},
+		},
+		{
+			name:     "delisted but still listed in /coins/list",
+			stored:   stored(true, btc, eth),
+			all:      []Coin{btc, eth},
+			active:   []Coin{btc},
+			delisted: []string{"ethereum"},
+			events:   []string{"ethereum:delisted::"},
+			changed:  []string{"ethereum:false"},
+		},
+		{
+			name:     "relisted",
+			stored:   stored(false, eth),
+			all:      []Coin{eth},
+			active:   []Coin{eth},
+			relisted: []string{"ethereum"},
+			events:   []string{"ethereum:relisted::"},
+			changed:  []string{"ethereum:true"},
+		},
+		{
+			name:    "symbol and name changed",
+			stored:  stored(true, eth),
+			all:     []Coin{{ID: "ethereum", Symbol: "ethw", Name: "Ether"}},
+			active:  []Coin{{ID: "ethereum"}},
+			events:  []string{"ethereum:symbol_changed:eth:ethw", "ethereum:name_changed:Ethereum:Ether"},
+			changed: []string{"ethereum:true"},
+		},
+		{
+			name:     "vanished",
+			stored:   stored(true, btc, eth),
+			all:      []Coin{btc},
+			active:   []Coin{btc},
+			delisted: []string{"ethereum"},
+			events:   []string{"ethereum:delisted::"},
+			changed:  []string{"ethereum:false"},
+		},
+		{
+			name:   "vanished while inactive",
+			stored: stored(false, eth),
+		},
+		{
+			name:    "id migrated",
+			stored:  stored(true, eth),
+			all:     []Coin{{ID: "ethereum-2", Symbol: "ETH", Name: "Ethereum"}},
+			active:  []Coin{{ID: "ethereum-2"}},
+			listed:  []string{"ethereum-2"},
+			events:  []string{"ethereum-2:listed::ETH", "ethereum:id_migrated:ethereum:ethereum-2"},
+			changed: []string{"ethereum-2:true", "ethereum:false"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := diffUniverse(tt.stored, tt.all, tt.active, now)
+
+			for _, c := range []struct {
+				what      string
+				got, want []string
+			}{
+				{"Listed", coinIDs(d.Listed), tt.listed},
+				{"Delisted", coinIDs(d.Delisted), tt.delisted},
+				{"Relisted", coinIDs(d.Relisted), tt.relisted},
+			} {
+				if !reflect.DeepEqual(c.got, c.want) {
+					t.Errorf("%s = %v, want %v", c.what, c.got, c.want)
+				}
+			}
+
+			var events []string
+			for _, e := range d.Events {
+				if !e.At.Equal(now) {
+					t.Errorf("event %s at %v, want %v", e.Kind, e.At, now)
+				}
+				events = append(events, e.ID+":"+e.Kind+":"+e.Old+":"+e.New)
+			}
+			if !reflect.DeepEqual(events, tt.events) {
+				t.Errorf("Events = %v, want %v", events, tt.events)
+			}
+
+			var changed []string
+			for _, c := range d.Changed {
+				changed = append(changed, fmt.Sprintf("%s:%v", c.ID, c.Active))
+				if _, ok := tt.stored[c.ID]; ok && !c.FirstSeen.Equal(seen) {
+					t.Errorf("%s FirstSeen = %v, want %v", c.ID, c.FirstSeen, seen)
+				}
+			}
+			if !reflect.DeepEqual(changed, tt.changed) {
+				t.Errorf("Changed = %v, want %v", changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestUniverseVersions(t *testing.T) {
+	today := mustParseDate("2024-06-30")
+	since := mustParseDate("2024-01-01")
+	current := map[string]SymbolVersion{
+		"bitcoin":  {ID: "bitcoin", Symbol: "btc", Name: "Bitcoin", ValidFrom: historyStart},
+		"ethereum": {ID: "ethereum", Symbol: "eth", Name: "Ethereum", ValidFrom: since},
+		"renamed":  {ID: "renamed", Symbol: "old", Name: "Old", ValidFrom: today},
+	}
+
+	tests := []struct {
+		name string
+		coin Coin
+		want []SymbolVersion
+	}{
+		{
+			name: "new id",
+			coin: Coin{ID: "solana", Symbol: "sol", Name: "Solana"},
+			want: []SymbolVersion{{ID: "solana", Symbol: "sol", Name: "Solana", ValidFrom: historyStart}},
+		},
+		{
+			name: "unchanged",
+			coin: Coin{ID: "bitcoin", Symbol: "btc", Name: "Bitcoin"},
+		},
+		{
+			name: "symbol changed",
+			coin: Coin{ID: "ethereum", Symbol: "ethw", Name: "Ethereum"},
+			want: []SymbolVersion{
+				{ID: "ethereum", Symbol: "eth", Name: "Ethereum", ValidFrom: since, ValidTo: today.AddDate(0, 0, -1)},
+				{ID: "ethereum", Symbol: "ethw", Name: "Ethereum", ValidFrom: today},
+			},
+		},
+		{
+			name: "changed again the same day",
+			coin: Coin{ID: "renamed", Symbol: "new", Name: "New"},
+			want: []SymbolVersion{{ID: "renamed", Symbol: "new", Name: "New", ValidFrom: today}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := universeVersions(current, []Coin{tt.coin}, today)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("universeVersions = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSymbolAt(t *testing.T) {
+	h := &SymbolHistory{}
+	h.Set(map[string][]SymbolVersion{
+		// Out of order on purpose: Set sorts the versions.
+		"ethereum": {
+			{ID: "ethereum", Symbol: "ethw", ValidFrom: mustParseDate("2024-03-01")},
+			{ID: "ethereum", Symbol: "eth", ValidFrom: historyStart, ValidTo: mustParseDate("2024-02-29")},
+		},
+		"blank": {{ID: "blank", ValidFrom: historyStart}},
+	})
+
+	tests := []struct {
+		name string
+		h    *SymbolHistory
+		id   string
+		day  string
+		want string
+		ok   bool
+	}{
+		{"before the first version", h, "ethereum", "1960-01-01", "ETH", true},
+		{"first version", h, "ethereum", "2024-02-29", "ETH", true},
+		{"switch day", h, "ethereum", "2024-03-01", "ETHW", true},
+		{"current version", h, "ethereum", "2024-06-30", "ETHW", true},
+		{"unknown id", h, "solana", "2024-06-30", "", false},
+		{"empty symbol", h, "blank", "2024-06-30", "", false},
+		{"nil history", nil, "ethereum", "2024-06-30", "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.h.SymbolAt(tt.id, mustParseDate(tt.day))
+			if got != tt.want || ok != tt.ok {
+				t.Fatalf("SymbolAt = %q, %v, want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
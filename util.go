@@ -55,3 +55,33 @@ func parseCSVSet(s string) map[string]bool {
 	}
 	return set
 }
+
+func daysBetween(from, to time.Time) int {
+	return int(dateOnlyUTC(to).Sub(dateOnlyUTC(from)).Hours() / 24)
+}
+
+func sameSet(a, b map[string]bool) bool {
+	if (a == nil) != (b == nil) || len(a) != len(b) {
+		return false
+	}
+	for k := range a {
+		if !b[k] {
+			return false
+		}
+	}
+	return true
+}
+
+// filterCoins keeps the coins allowed by filter; a nil filter allows all.
+func filterCoins(coins []Coin, filter map[string]bool) []Coin {
+	if filter == nil {
+		return coins
+	}
+	out := make([]Coin, 0, len(coins))
+	for _, c := range coins {
+		if filter[c.ID] {
+			out = append(out, c)
+		}
+	}
+	return out
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+)
+
+// Validation rules, enabled by name in VALIDATION_RULES.
+const (
+	ruleFinite      = "finite"       // price, market cap and volume are not NaN or ±Inf
+	ruleNegative    = "negative"     // no value is below zero
+	ruleZeroPrice   = "zero_price"   // price is above zero
+	ruleVolumeRatio = "volume_ratio" // volume is at most VALIDATION_MAX_VOLUME_RATIO × market cap
+	rulePriceJump   = "price_jump"   // price moves at most VALIDATION_MAX_JUMP_RATIO × day over day
+	ruleSupplyJump  = "supply_jump"  // market cap / price stays within VALIDATION_MAX_SUPPLY_RATIO of the known supply
+	ruleMcapZero    = "mcap_zero"    // market cap does not drop to zero from a non-zero value
+)
+
+var validationRules = []string{ruleFinite, ruleNegative, ruleZeroPrice, ruleVolumeRatio, rulePriceJump, ruleSupplyJump, ruleMcapZero}
+
+// validationLookbackDays bounds how far back the reference point of the
+// day-over-day rules is looked up.
+const validationLookbackDays = 30
+
+// QuarantinedPoint is a fetched point that failed validation and is stored
+// in the quarantine table instead of the main one.
+type QuarantinedPoint struct {
+	DailyPoint
+	Phase   TaskPhase
+	Reasons []string
+	Detail  string
+}
+
+// pointValidator checks the points of one coin and currency in date order.
+// Day-over-day rules compare with the last accepted point. A change that the
+// previous raw point already showed is a new level rather than an outlier,
+// so a jump is only quarantined once and the series then follows it.
+type pointValidator struct {
+	cfg      Config
+	accepted *DailyPoint
+	prev     *DailyPoint
+}
+
+// newPointValidator loads the reference points before from: the last stored
+// day and, when it is newer, the last quarantined one.
+func newPointValidator(ctx context.Context, cfg Config, db *sql.DB, id, vs string, from time.Time) (*pointValidator, error) {
+	v := &pointValidator{cfg: cfg}
+	if !cfg.ValidationRules[rulePriceJump] && !cfg.ValidationRules[ruleSupplyJump] && !cfg.ValidationRules[ruleMcapZero] {
+		return v, nil
+	}
+
+	since := from.AddDate(0, 0, -validationLookbackDays)
+	stored, ok, err := getLastPointBefore(ctx, db, cfg.CHTable, id, vs, since, from)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		v.observe(stored, true)
+	}
+	quarantined, ok, err := getLastPointBefore(ctx, db, cfg.CHQuarantineTable, id, vs, since, from)
+	if err != nil {
+		return nil, err
+	}
+	if ok && (v.prev == nil || quarantined.Timestamp.After(v.prev.Timestamp)) {
+		v.observe(quarantined, false)
+	}
+	return v, nil
+}
+
+// observe records p as the previous raw point and, if ok, as the last
+// accepted one.
+func (v *pointValidator) observe(p DailyPoint, ok bool) {
+	v.prev = &p
+	if ok {
+		v.accepted = &p
+	}
+}
+
+// check returns the rules p breaks, with a readable detail per rule. It does
+// not record p; callers observe it once they know whether it is stored.
+func (v *pointValidator) check(p DailyPoint) (reasons []string, detail string) {
+	var details []string
+	fail := func(rule, format string, args ...any) {
+		if v.cfg.ValidationRules[rule] {
+			reasons = append(reasons, rule)
+			details = append(details, fmt.Sprintf(format, args...))
+		}
+	}
+
+	finite := true
+	for _, f := range []struct {
+		name string
+		v    float64
+	}{{"price", p.Price}, {"market_cap", p.MarketCap}, {"volume", p.Volume}} {
+		switch {
+		case math.IsNaN(f.v) || math.IsInf(f.v, 0):
+			fail(ruleFinite, "%s is %v", f.name, f.v)
+			finite = false
+		case f.v < 0:
+			fail(ruleNegative, "%s is negative: %g", f.name, f.v)
+		}
+	}
+	if !finite {
+		return reasons, strings.Join(details, "; ")
+	}
+
+	if p.Price == 0 {
+		fail(ruleZeroPrice, "price is zero")
+	}
+	if p.MarketCap > 0 && p.Volume/p.MarketCap > v.cfg.ValidationMaxVolumeRatio {
+		fail(ruleVolumeRatio, "volume is %.1f× market cap", p.Volume/p.MarketCap)
+	}
+
+	a := v.accepted
+	if a == nil {
+		return reasons, strings.Join(details, "; ")
+	}
+	if r := jumpRatio(a.Price, p.Price); r > v.cfg.ValidationMaxJumpRatio && jumpRatio(v.prev.Price, p.Price) > v.cfg.ValidationMaxJumpRatio {
+		fail(rulePriceJump, "price moved %.1f× from %g on %s", r, a.Price, formatDate(a.Timestamp))
+	}
+	if r := jumpRatio(impliedSupply(*a), impliedSupply(p)); r > v.cfg.ValidationMaxSupplyRatio && jumpRatio(impliedSupply(*v.prev), impliedSupply(p)) > v.cfg.ValidationMaxSupplyRatio {
+		fail(ruleSupplyJump, "market cap / price moved %.1f× from %.0f on %s", r, impliedSupply(*a), formatDate(a.Timestamp))
+	}
+	if p.MarketCap == 0 && a.MarketCap > 0 && v.prev.MarketCap > 0 {
+		fail(ruleMcapZero, "market cap dropped to zero from %g on %s", a.MarketCap, formatDate(a.Timestamp))
+	}
+	return reasons, strings.Join(details, "; ")
+}
+
+// jumpRatio is how many times larger the bigger of a and b is. Values that
+// can't be compared give 1.
+func jumpRatio(a, b float64) float64 {
+	if a <= 0 || b <= 0 {
+		return 1
+	}
+	return math.Max(a/b, b/a)
+}
+
+// impliedSupply is the circulating supply market cap and price imply, or 0
+// when either is unknown.
+func impliedSupply(p DailyPoint) float64 {
+	if p.Price <= 0 || p.MarketCap <= 0 {
+		return 0
+	}
+	return p.MarketCap / p.Price
+}
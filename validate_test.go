@@ -0,0 +1,141 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestPointValidator(t *testing.T) {
+	allRules := make(map[string]bool)
+	for _, r := range validationRules {
+		allRules[r] = true
+	}
+	cfg := Config{
+		ValidationRules:          allRules,
+		ValidationMaxJumpRatio:   10,
+		ValidationMaxSupplyRatio: 2,
+		ValidationMaxVolumeRatio: 50,
+	}
+	pt := func(day string, price, mcap, volume float64) DailyPoint {
+		return DailyPoint{ID: "x", VsCurrency: "usd", Timestamp: mustParseDate(day), Price: price, MarketCap: mcap, Volume: volume}
+	}
+	type step struct {
+		p       DailyPoint
+		reasons []string
+	}
+
+	tests := []struct {
+		name  string
+		rules []string // all rules when empty
+		steps []step
+	}{
+		{
+			name: "clean series",
+			steps: []step{
+				{p: pt("2024-01-01", 1, 1000, 10)},
+				{p: pt("2024-01-02", 2, 2000, 10)},
+				{p: pt("2024-01-03", 0.5, 500, 10)},
+			},
+		},
+		{
+			name: "non-finite values",
+			steps: []step{
+				{p: pt("2024-01-01", math.NaN(), math.Inf(1), 10), reasons: []string{ruleFinite, ruleFinite}},
+			},
+		},
+		{
+			name: "negative and zero price",
+			steps: []step{
+				{p: pt("2024-01-01", 0, -1, 10), reasons: []string{ruleNegative, ruleZeroPrice}},
+			},
+		},
+		{
+			name: "volume far above market cap",
+			steps: []step{
+				{p: pt("2024-01-01", 1, 1000, 60000), reasons: []string{ruleVolumeRatio}},
+			},
+		},
+		{
+			name: "one-day price spike",
+			steps: []step{
+				{p: pt("2024-01-01", 1, 0, 10)},
+				{p: pt("2024-01-02", 20, 0, 10), reasons: []string{rulePriceJump}},
+				{p: pt("2024-01-03", 1.1, 0, 10)},
+			},
+		},
+		{
+			name: "new price level is followed",
+			steps: []step{
+				{p: pt("2024-01-01", 1, 0, 10)},
+				{p: pt("2024-01-02", 20, 0, 10), reasons: []string{rulePriceJump}},
+				{p: pt("2024-01-03", 21, 0, 10)},
+				{p: pt("2024-01-04", 22, 0, 10)},
+			},
+		},
+		{
+			name: "supply jump",
+			steps: []step{
+				{p: pt("2024-01-01", 1, 1000, 10)},
+				{p: pt("2024-01-02", 1, 5000, 10), reasons: []string{ruleSupplyJump}},
+				{p: pt("2024-01-03", 1, 1100, 10)},
+			},
+		},
+		{
+			name: "market cap drops to zero once",
+			steps: []step{
+				{p: pt("2024-01-01", 1, 1000, 10)},
+				{p: pt("2024-01-02", 1, 0, 10), reasons: []string{ruleMcapZero}},
+				{p: pt("2024-01-03", 1, 0, 10)},
+			},
+		},
+		{
+			name:  "disabled rules are skipped",
+			rules: []string{ruleFinite},
+			steps: []step{
+				{p: pt("2024-01-01", 1, 1000, 10)},
+				{p: pt("2024-01-02", 0, -1, 1e9)},
+				{p: pt("2024-01-03", 100, 0, 10)},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := cfg
+			if len(tt.rules) > 0 {
+				c.ValidationRules = make(map[string]bool)
+				for _, r := range tt.rules {
+					c.ValidationRules[r] = true
+				}
+			}
+			v := &pointValidator{cfg: c}
+			for i, s := range tt.steps {
+				reasons, detail := v.check(s.p)
+				if !reflect.DeepEqual(reasons, s.reasons) {
+					t.Fatalf("step %d: reasons = %v (%s), want %v", i, reasons, detail, s.reasons)
+				}
+				if len(reasons) > 0 && detail == "" {
+					t.Fatalf("step %d: no detail for %v", i, reasons)
+				}
+				v.observe(s.p, len(reasons) == 0)
+			}
+		})
+	}
+}
+
+func TestJumpRatio(t *testing.T) {
+	tests := []struct {
+		a, b, want float64
+	}{
+		{1, 10, 10},
+		{10, 1, 10},
+		{2, 2, 1},
+		{0, 5, 1},
+		{5, -1, 1},
+	}
+	for _, tt := range tests {
+		if got := jumpRatio(tt.a, tt.b); got != tt.want {
+			t.Errorf("jumpRatio(%g, %g) = %g, want %g", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
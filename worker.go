@@ -4,10 +4,14 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"math"
 	"sort"
+	"sync"
 	"time"
 
 	log "github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type dailyAgg struct {
@@ -17,38 +21,75 @@ type dailyAgg struct {
 	v  float64
 }
 
-func worker(ctx context.Context, wid int, cfg Config, cg *CGClient, db *sql.DB, tasks <-chan Task, results chan<- TaskResult) {
+// worker runs tasks until ctx is cancelled or stop is closed; a stopped
+// worker always finishes the task it is handling first.
+func worker(ctx context.Context, wid int, cfg Config, cg *CGClient, db *sql.DB, syms *SymbolHistory, stop <-chan struct{}, tasks <-chan Task, results chan<- TaskResult) {
 	for {
+		select {
+		case <-stop:
+			return
+		default:
+		}
+
 		select {
 		case <-ctx.Done():
 			return
+		case <-stop:
+			return
 		case t, ok := <-tasks:
 			if !ok {
 				return
 			}
-			res := handleTask(ctx, cfg, cg, db, t)
+			ctx := taskContext(ctx, t)
+			var res TaskResult
+			switch t.Phase {
+			case PhaseDiscovery:
+				res = handleProbeTask(ctx, cfg, cg, t)
+			case PhaseExchangeVolume:
+				res = handleExchangeVolumeTask(ctx, cfg, cg, db, t)
+			default:
+				res = handleTask(ctx, cfg, cg, db, syms, t)
+			}
 			results <- res
 		}
 	}
 }
 
-func handleTask(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB, t Task) TaskResult {
-	fromStr := formatDate(t.From)
-	toStr := formatDate(t.To)
+// workerPool keeps a resizable set of workers reading the same task channel.
+type workerPool struct {
+	mu    sync.Mutex
+	start func(wid int, stop <-chan struct{})
+	stops []chan struct{}
+	next  int
+}
 
-	allDays := daysInclusive(t.From, t.To)
+func newWorkerPool(start func(wid int, stop <-chan struct{})) *workerPool {
+	return &workerPool{start: start}
+}
 
-	existing, err := getExistingDays(ctx, db, cfg.CHTable, t.CoinID, t.From, t.To)
-	if err == nil && t.Retry == 0 && len(existing) == len(allDays) && len(allDays) > 0 {
-		return TaskResult{
-			Task:       t,
-			Inserted:   0,
-			APIDays:    0,
-			Empty:      false,
-			HTTPStatus: 200,
-			Err:        "",
-		}
+// Resize starts or stops workers until n are running. Stopped workers exit
+// after their current task, so nothing in flight is lost.
+func (p *workerPool) Resize(n int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for len(p.stops) < n {
+		stop := make(chan struct{})
+		p.stops = append(p.stops, stop)
+		go p.start(p.next, stop)
+		p.next++
+	}
+	for len(p.stops) > n {
+		last := len(p.stops) - 1
+		close(p.stops[last])
+		p.stops = p.stops[:last]
 	}
+	metricWorkers.Set(float64(len(p.stops)))
+}
+
+func fetchRange(ctx context.Context, cfg Config, cg *CGClient, t Task) (MarketChartRangeResp, int, []byte, error) {
+	fromStr := formatDate(t.From)
+	toStr := formatDate(t.To)
 
 	var resp MarketChartRangeResp
 	var status int
@@ -56,7 +97,10 @@ func handleTask(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB, t Tas
 	var lastErr error
 
 	for attempt := 0; attempt <= cfg.MaxRetriesPerBlock; attempt++ {
-		r, st, b, e := cg.MarketChartRange(ctx, t.CoinID, cfg.VsCurrency, fromStr, toStr, cfg.Interval)
+		actx, span := tracer.Start(ctx, "coingecko.attempt", trace.WithAttributes(attribute.Int("attempt", attempt)))
+		r, st, b, e := cg.MarketChartRange(actx, t.CoinID, t.vs(cfg), fromStr, toStr, cfg.Interval)
+		span.SetAttributes(attribute.Int("http.status_code", st))
+		endSpan(span, e)
 		resp, status, lastBody, lastErr = r, st, b, e
 		if e == nil {
 			break
@@ -65,20 +109,16 @@ func handleTask(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB, t Tas
 			break
 		}
 		logHTTPError(t.CoinID, fromStr, toStr, st, b, e)
+		if attempt < cfg.MaxRetriesPerBlock {
+			metricRetries.WithLabelValues("http", string(t.Phase)).Inc()
+		}
 		time.Sleep(backoffSleep(attempt))
 	}
 
-	if lastErr != nil {
-		return TaskResult{
-			Task:       t,
-			Inserted:   0,
-			APIDays:    0,
-			Empty:      true,
-			HTTPStatus: status,
-			Err:        fmt.Sprintf("%v; body=%s", lastErr, truncate(lastBody, 300)),
-		}
-	}
+	return resp, status, lastBody, lastErr
+}
 
+func aggregateDaily(resp MarketChartRangeResp) map[string]*dailyAgg {
 	byDay := make(map[string]*dailyAgg)
 
 	apply := func(arr [][]float64, kind string) {
@@ -114,6 +154,81 @@ func handleTask(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB, t Tas
 	apply(resp.MarketCaps, "mc")
 	apply(resp.TotalVolumes, "v")
 
+	return byDay
+}
+
+func handleProbeTask(ctx context.Context, cfg Config, cg *CGClient, t Task) TaskResult {
+	resp, status, body, err := fetchRange(ctx, cfg, cg, t)
+	if err != nil {
+		return TaskResult{
+			Task:       t,
+			Empty:      true,
+			HTTPStatus: status,
+			Err:        fmt.Sprintf("%v; body=%s", err, truncate(body, 300)),
+		}
+	}
+
+	res := TaskResult{
+		Task:       t,
+		Empty:      true,
+		HTTPStatus: status,
+	}
+
+	for day := range aggregateDaily(resp) {
+		d := mustParseDate(day)
+		if d.Before(t.From) || d.After(t.To) {
+			continue
+		}
+		if res.APIDays == 0 || d.Before(res.DataFrom) {
+			res.DataFrom = d
+		}
+		if res.APIDays == 0 || d.After(res.DataTo) {
+			res.DataTo = d
+		}
+		res.APIDays++
+	}
+	res.Empty = res.APIDays == 0
+
+	return res
+}
+
+func handleTask(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB, syms *SymbolHistory, t Task) TaskResult {
+	vs := t.vs(cfg)
+	allDays := daysInclusive(t.From, t.To)
+
+	qctx, span := tracer.Start(ctx, "clickhouse.existing_days")
+	existing, err := getExistingDays(qctx, db, cfg.CHTable, t.CoinID, vs, t.From, t.To)
+	endSpan(span, err)
+	if err == nil && t.Phase != PhaseRevision && t.Retry == 0 && len(existing) == len(allDays) && len(allDays) > 0 {
+		return TaskResult{
+			Task:       t,
+			Inserted:   0,
+			APIDays:    0,
+			Empty:      false,
+			HTTPStatus: 200,
+			Err:        "",
+		}
+	}
+
+	resp, status, lastBody, lastErr := fetchRange(ctx, cfg, cg, t)
+	if lastErr != nil {
+		return TaskResult{
+			Task:       t,
+			Inserted:   0,
+			APIDays:    0,
+			Empty:      true,
+			HTTPStatus: status,
+			Err:        fmt.Sprintf("%v; body=%s", lastErr, truncate(lastBody, 300)),
+		}
+	}
+
+	byDay := aggregateDaily(resp)
+
+	emptyDays := 0
+	if t.Phase == PhaseGapFill {
+		emptyDays = recordEmptyDays(ctx, cfg, db, t, allDays, byDay, existing)
+	}
+
 	if len(byDay) == 0 {
 		return TaskResult{
 			Task:       t,
@@ -122,6 +237,7 @@ func handleTask(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB, t Tas
 			Empty:      true,
 			HTTPStatus: 200,
 			Err:        "",
+			EmptyDays:  emptyDays,
 		}
 	}
 
@@ -132,27 +248,107 @@ func handleTask(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB, t Tas
 	sort.Strings(apiDays)
 
 	if existing == nil {
-		existing, _ = getExistingDays(ctx, db, cfg.CHTable, t.CoinID, t.From, t.To)
+		existing, _ = getExistingDays(ctx, db, cfg.CHTable, t.CoinID, vs, t.From, t.To)
+	}
+
+	var stored map[string]DailyPoint
+	if t.Phase == PhaseRevision {
+		stored, err = getDailyPoints(ctx, db, cfg.CHTable, t.CoinID, vs, t.From, t.To)
+		if err != nil {
+			return TaskResult{
+				Task:       t,
+				APIDays:    len(apiDays),
+				HTTPStatus: 200,
+				Err:        err.Error(),
+			}
+		}
+	}
+
+	val, err := newPointValidator(ctx, cfg, db, t.CoinID, vs, t.From)
+	if err != nil {
+		return TaskResult{
+			Task:       t,
+			APIDays:    len(apiDays),
+			HTTPStatus: 200,
+			Err:        fmt.Sprintf("validation reference: %v", err),
+		}
 	}
 
 	toInsert := make([]DailyPoint, 0, len(apiDays))
+	var revised []Revision
+	var revisedDays []string
+	var quarantined []QuarantinedPoint
+	rejected := make(map[string]bool)
 	for _, day := range apiDays {
-		if _, ok := existing[day]; ok {
-			continue
-		}
 		a := byDay[day]
-		toInsert = append(toInsert, DailyPoint{
+		sym, ok := syms.SymbolAt(t.CoinID, a.ts)
+		if !ok {
+			sym = t.Symbol
+		}
+		p := DailyPoint{
 			ID:         t.CoinID,
-			Symbol:     t.Symbol,
-			VsCurrency: cfg.VsCurrency,
+			Symbol:     sym,
+			VsCurrency: vs,
 			Timestamp:  a.ts,
 			Price:      a.p,
 			MarketCap:  a.mc,
 			Volume:     a.v,
-		})
+		}
+		old, isStored := stored[day]
+		if _, ok := existing[day]; ok && (!isStored || !pointChanged(old, p)) {
+			val.observe(p, true)
+			continue
+		}
+
+		// A revision that fails validation keeps the stored value.
+		if reasons, detail := val.check(p); len(reasons) > 0 {
+			if isStored {
+				val.observe(old, true)
+			}
+			val.observe(p, false)
+			quarantined = append(quarantined, QuarantinedPoint{DailyPoint: p, Phase: t.Phase, Reasons: reasons, Detail: detail})
+			rejected[day] = true
+			continue
+		}
+		val.observe(p, true)
+
+		if isStored {
+			revised = append(revised, Revision{Old: old, New: p})
+			revisedDays = append(revisedDays, day)
+		}
+		toInsert = append(toInsert, p)
 	}
 
-	inserted, insErr := insertDailyPoints(ctx, db, cfg.CHTable, toInsert)
+	if len(quarantined) > 0 {
+		if err := insertQuarantined(ctx, db, cfg.CHQuarantineTable, quarantined); err != nil {
+			return TaskResult{
+				Task:       t,
+				APIDays:    len(apiDays),
+				HTTPStatus: 200,
+				Err:        fmt.Sprintf("quarantine insert: %v", err),
+			}
+		}
+		for _, q := range quarantined {
+			for _, r := range q.Reasons {
+				metricQuarantined.WithLabelValues(r).Inc()
+			}
+		}
+		log.WithFields(log.Fields{
+			"id":          t.CoinID,
+			"from":        formatDate(t.From),
+			"to":          formatDate(t.To),
+			"quarantined": len(quarantined),
+			"detail":      quarantined[0].Detail,
+		}).Warn("points failed validation")
+	}
+
+	// Revised days are inserted before the rows they replace are deleted, so
+	// a failure leaves the days stored twice rather than not at all.
+	insertStart := time.Now()
+	ictx, span := tracer.Start(ctx, "clickhouse.insert", trace.WithAttributes(attribute.Int("rows", len(toInsert))))
+	inserted, insErr := insertDailyPoints(ictx, db, cfg.CHTable, toInsert)
+	endSpan(span, insErr)
+	metricRowsInserted.WithLabelValues(string(t.Phase)).Add(float64(inserted))
 	if insErr != nil {
 		return TaskResult{
 			Task:       t,
@@ -164,11 +360,58 @@ func handleTask(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB, t Tas
 		}
 	}
 
-	after, err2 := getExistingDays(ctx, db, cfg.CHTable, t.CoinID, t.From, t.To)
+	if err := deleteSuperseded(ctx, db, cfg.CHTable, t.CoinID, vs, revisedDays, insertStart); err != nil {
+		log.WithFields(log.Fields{
+			"id":   t.CoinID,
+			"from": formatDate(t.From),
+			"to":   formatDate(t.To),
+		}).Warnf("superseded revision rows not deleted: %v", err)
+	}
+
+	written := toInsert
+	revisedVs := []string{vs}
+	if vs == cfg.VsCurrency && len(cfg.DerivedVsCurrencies) > 0 {
+		derived, err := storeDerived(ctx, cfg, db, t, toInsert, revisedDays)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"id":   t.CoinID,
+				"from": formatDate(t.From),
+				"to":   formatDate(t.To),
+			}).Warnf("derived currencies not stored: %v", err)
+		}
+		written = append(written[:len(written):len(written)], derived...)
+		revisedVs = append(revisedVs, sortedKeys(cfg.DerivedVsCurrencies)...)
+	}
+
+	changedDays.AddPoints(written)
+	indicatorDays.AddCoinPoints(written)
+	revisedDates := make([]time.Time, len(revisedDays))
+	for i, d := range revisedDays {
+		revisedDates[i] = mustParseDate(d)
+	}
+	for _, rvs := range revisedVs {
+		revisedCoinDays.Add(coinKey(t.CoinID, rvs), revisedDates...)
+	}
+	if t.Phase == PhaseIncremental || t.Phase == PhaseRevision {
+		feed.Publish(t.Phase, written)
+	}
+
+	if err := insertRevisions(ctx, db, cfg.CHRevisionsTable, revised); err != nil {
+		log.WithFields(log.Fields{
+			"id":      t.CoinID,
+			"from":    formatDate(t.From),
+			"to":      formatDate(t.To),
+			"revised": len(revised),
+		}).Warnf("revision audit insert failed: %v", err)
+	}
+
+	vctx, span := tracer.Start(ctx, "clickhouse.verify")
+	after, err2 := getExistingDays(vctx, db, cfg.CHTable, t.CoinID, vs, t.From, t.To)
+	endSpan(span, err2)
 	missing := make([]string, 0)
 	if err2 == nil {
 		for _, d := range apiDays {
-			if _, ok := after[d]; !ok {
+			if _, ok := after[d]; !ok && !rejected[d] {
 				missing = append(missing, d)
 			}
 		}
@@ -202,6 +445,52 @@ func handleTask(ctx context.Context, cfg Config, cg *CGClient, db *sql.DB, t Tas
 		HTTPStatus:   200,
 		Err:          "",
 		MissingDates: missing,
+		EmptyDays:    emptyDays,
+		Revised:      len(revised),
+		Quarantined:  len(quarantined),
 		ActiveNow:    activeNow,
 	}
 }
+
+const revisionTolerance = 1e-9
+
+func pointChanged(old, cur DailyPoint) bool {
+	return valueChanged(old.Price, cur.Price) ||
+		valueChanged(old.MarketCap, cur.MarketCap) ||
+		valueChanged(old.Volume, cur.Volume)
+}
+
+func valueChanged(a, b float64) bool {
+	d := math.Abs(a - b)
+	scale := math.Max(math.Abs(a), math.Abs(b))
+	if scale == 0 {
+		return false
+	}
+	return d/scale > revisionTolerance
+}
+
+// recordEmptyDays stores the days of a gap window the API answered without data,
+// so later gap scans don't schedule them again.
+func recordEmptyDays(ctx context.Context, cfg Config, db *sql.DB, t Task, allDays []time.Time, byDay map[string]*dailyAgg, existing map[string]struct{}) int {
+	var empty []time.Time
+	for _, d := range allDays {
+		day := formatDate(d)
+		if _, ok := byDay[day]; ok {
+			continue
+		}
+		if _, ok := existing[day]; ok {
+			continue
+		}
+		empty = append(empty, d)
+	}
+
+	if err := insertEmptyDays(ctx, db, cfg.CHEmptyDaysTable, t.CoinID, t.vs(cfg), empty); err != nil {
+		log.WithFields(log.Fields{
+			"id":   t.CoinID,
+			"from": formatDate(t.From),
+			"to":   formatDate(t.To),
+		}).Warnf("record empty days failed: %v", err)
+		return 0
+	}
+	return len(empty)
+}